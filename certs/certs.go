@@ -0,0 +1,304 @@
+// Package certs provides TLS configurations for the sigma gRPC servers and
+// clients and issues client certificates for function nodes
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+var (
+	// ErrNoCertificate is returned if PEM data does not contain a
+	// certificate
+	ErrNoCertificate = errors.New("no certificate found")
+
+	// ErrNotAuthority is returned if the certificate of an authority cannot
+	// be used to sign other certificates
+	ErrNotAuthority = errors.New("certificate is not a certificate authority")
+
+	// ErrUnsupportedKey is returned if the private key of an authority
+	// cannot be used for signing
+	ErrUnsupportedKey = errors.New("unsupported private key")
+)
+
+// ServerConfig returns a TLS configuration for a server using the
+// certificate and key from certFile and keyFile. If clientCA is set,
+// clients must present a certificate signed by the authority in clientCA
+func ServerConfig(certFile, keyFile, clientCA string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCA != "" {
+		pool, err := loadPool(clientCA)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+// ClientConfig returns a TLS configuration for a client. If caFile is
+// empty the system roots are used to verify the server. certFile and
+// keyFile are optional and hold the client certificate
+func ClientConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		pool, err := loadPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// ClientConfigFromPEM works like ClientConfig but expects PEM encoded
+// certificates and keys instead of file names
+func ClientConfigFromPEM(ca, cert, key []byte) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if len(ca) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, ErrNoCertificate
+		}
+		cfg.RootCAs = pool
+	}
+
+	if len(cert) > 0 || len(key) > 0 {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+
+	return cfg, nil
+}
+
+// PeerCommonName returns the common name of the verified TLS client
+// certificate of the gRPC peer in ctx
+func PeerCommonName(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return "", false
+	}
+
+	if len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+
+	return info.State.VerifiedChains[0][0].Subject.CommonName, true
+}
+
+func loadPool(file string) (*x509.CertPool, error) {
+	blob, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(blob) {
+		return nil, ErrNoCertificate
+	}
+
+	return pool, nil
+}
+
+// Authority issues client certificates
+type Authority struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     crypto.Signer
+}
+
+// LoadAuthority loads the certificate and private key of an authority from
+// certFile and keyFile
+func LoadAuthority(certFile, keyFile string) (*Authority, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewAuthority(certPEM, keyPEM)
+}
+
+// NewAuthority returns an authority for the PEM encoded certificate and
+// private key
+func NewAuthority(certPEM, keyPEM []byte) (*Authority, error) {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if !cert.IsCA {
+		return nil, ErrNotAuthority
+	}
+
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+
+	return &Authority{
+		cert:    cert,
+		certPEM: encodeCert(pair.Certificate[0]),
+		key:     key,
+	}, nil
+}
+
+// NewSelfSignedAuthority creates a new authority with a self-signed
+// certificate
+func NewSelfSignedAuthority(commonName string, validity time.Duration) (*Authority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template(commonName, validity)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Authority{
+		cert:    cert,
+		certPEM: encodeCert(der),
+		key:     key,
+	}, nil
+}
+
+// Certificate returns the PEM encoded certificate of the authority
+func (a *Authority) Certificate() []byte {
+	return a.certPEM
+}
+
+// Issue issues a new client certificate for commonName and returns the
+// PEM encoded certificate and private key. If validity is zero, the
+// certificate is valid as long as the certificate of the authority
+func (a *Authority) Issue(commonName string, validity time.Duration) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if validity <= 0 {
+		validity = time.Until(a.cert.NotAfter)
+	}
+
+	tmpl, err := template(commonName, validity)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, a.cert, key.Public(), a.key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: keyDER,
+	})
+
+	return encodeCert(der), keyPEM, nil
+}
+
+func template(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: commonName,
+		},
+		// allow for some clock skew between sigma and the nodes
+		NotBefore: now.Add(-time.Minute),
+		NotAfter:  now.Add(validity),
+	}, nil
+}
+
+func encodeCert(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: der,
+	})
+}
@@ -0,0 +1,84 @@
+package certs
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthority_Issue(t *testing.T) {
+	assert := assert.New(t)
+
+	ca, err := NewSelfSignedAuthority("sigma", time.Hour)
+	assert.NoError(err)
+
+	certPEM, keyPEM, err := ca.Issue("urn:node", time.Hour)
+	assert.NoError(err)
+	assert.NotEmpty(keyPEM)
+
+	block, _ := pem.Decode(certPEM)
+	if !assert.NotNil(block) {
+		return
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	assert.NoError(err)
+	assert.Equal("urn:node", cert.Subject.CommonName)
+
+	pool := x509.NewCertPool()
+	assert.True(pool.AppendCertsFromPEM(ca.Certificate()))
+
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     pool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	assert.NoError(err)
+
+	// client certificates cannot issue other certificates
+	_, err = NewAuthority(certPEM, keyPEM)
+	assert.Equal(ErrNotAuthority, err)
+
+	// the issued credentials can be used by nodes
+	cfg, err := ClientConfigFromPEM(ca.Certificate(), certPEM, keyPEM)
+	assert.NoError(err)
+	assert.Len(cfg.Certificates, 1)
+	assert.NotNil(cfg.RootCAs)
+}
+
+func TestAuthority_IssueForLifetime(t *testing.T) {
+	assert := assert.New(t)
+
+	ca, err := NewSelfSignedAuthority("sigma", 365*24*time.Hour)
+	assert.NoError(err)
+
+	certPEM, _, err := ca.Issue("urn:node", 0)
+	assert.NoError(err)
+
+	block, _ := pem.Decode(certPEM)
+	if !assert.NotNil(block) {
+		return
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if !assert.NoError(err) {
+		return
+	}
+
+	// the certificate expires together with the authority
+	assert.WithinDuration(ca.cert.NotAfter, cert.NotAfter, time.Second)
+}
+
+func TestClientConfigFromPEM_Invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := ClientConfigFromPEM([]byte("garbage"), nil, nil)
+	assert.Equal(ErrNoCertificate, err)
+
+	cfg, err := ClientConfigFromPEM(nil, nil, nil)
+	assert.NoError(err)
+	assert.Nil(cfg.RootCAs)
+	assert.Empty(cfg.Certificates)
+}
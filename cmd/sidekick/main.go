@@ -2,144 +2,110 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"google.golang.org/grpc/metadata"
-
-	"google.golang.org/grpc"
-
-	"github.com/homebot/core/utils"
-	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
 	"github.com/homebot/sigma/launcher"
+	"github.com/homebot/sigma/sdk"
 )
 
-var binary = flag.String("binary", "", "The binary to execute")
+// killTimeout is the time the function may take to exit after it's standard
+// input has been closed
+const killTimeout = 5 * time.Second
 
-type InitMessage struct {
-	URN        string         `json:"urn" yaml:"urn"`
-	Parameters utils.ValueMap `json:"parameters" yaml:"parameters"`
-	Content    []byte         `json:"content" yaml:"content"`
-}
+var (
+	binary      = flag.String("binary", "", "The binary to execute")
+	concurrency = flag.Int("concurrency", 1, "The number of requests the binary handles concurrently")
+	maxPayload  = flag.Int("max-payload", 0, "The maximum size of event payloads in bytes (0 means unlimited)")
+)
 
 func main() {
 	flag.Parse()
 
 	if *binary == "" {
 		os.Stderr.Write([]byte(fmt.Sprintf("missing -binary argument")))
-		return
+		os.Exit(1)
 	}
 
-	c := launcher.ConfigFromEnv()
-
-	f, err := os.Create("/tmp/env")
-	if err != nil {
-		os.Stderr.Write([]byte(fmt.Sprintf("invalid URN received")))
-		return
+	if err := run(); err != nil {
+		os.Stderr.Write([]byte(err.Error()))
+		os.Exit(1)
 	}
-	f.Write([]byte(fmt.Sprintf("%#v", c)))
-	f.Close()
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+func run() error {
+	c := launcher.ConfigFromEnv()
+
+	// the function binary does not need the TLS credentials of the node
+	env := c
+	env.TLS = nil
 
 	// start binary
-	cmd := exec.CommandContext(ctx, *binary)
-	for key, value := range c.EnvVars() {
+	cmd := exec.Command(*binary)
+	for key, value := range env.EnvVars() {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
 	}
+	cmd.Stderr = os.Stderr
 
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
-		os.Stderr.Write([]byte(err.Error()))
-		return
+		return err
 	}
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		os.Stderr.Write([]byte(err.Error()))
-		return
-	}
-	go io.Copy(os.Stdout, stdout)
-
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		os.Stderr.Write([]byte(err.Error()))
-		return
-	}
-	go io.Copy(os.Stderr, stderr)
-
-	conn, err := grpc.Dial(c.Address, grpc.WithInsecure())
-	if err != nil {
-		os.Stderr.Write([]byte(err.Error()))
-		return
-	}
-	defer conn.Close()
-
-	cli := sigmaV1.NewNodeHandlerClient(conn)
-
-	md := metadata.Pairs("node-urn", c.URN, "node-secret", c.Secret)
-	callCtx := metadata.NewOutgoingContext(ctx, md)
-
-	res, err := cli.Register(callCtx, &sigmaV1.NodeRegistrationRequest{
-		Urn:      c.URN,
-		NodeType: "dummy",
-	})
-	if err != nil {
-		os.Stderr.Write([]byte(err.Error()))
-		return
+		return err
 	}
 
 	if err := cmd.Start(); err != nil {
-		os.Stderr.Write([]byte(err.Error()))
-		return
+		return err
 	}
 
-	init := InitMessage{
-		URN:        res.GetUrn(),
-		Content:    res.GetContent(),
-		Parameters: utils.ValueMapFrom(res.GetParameters()),
-	}
-
-	blob, err := json.Marshal(init)
-	if err != nil {
-		os.Stderr.Write([]byte(err.Error()))
-		return
-	}
+	fn := newFunction(stdin, stdout)
 
-	stdin.Write(blob)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	stream, err := cli.Subscribe(callCtx)
-	if err != nil {
-		os.Stderr.Write([]byte(err.Error()))
-		return
-	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
 
+	// stop serving if we receive a signal or the binary exits
 	go func() {
-		defer cancel()
-		for {
-			msg, err := stream.Recv()
-			if err != nil {
-				return
-			}
-
-			if err := stream.Send(&sigmaV1.ExecutionResult{
-				Id: msg.GetId(),
-				ExecutionResult: &sigmaV1.ExecutionResult_Result{
-					Result: msg.GetPayload(),
-				},
-			}); err != nil {
-				return
-			}
+		select {
+		case <-sig:
+		case <-fn.Done():
+		case <-ctx.Done():
 		}
+		cancel()
 	}()
 
-	if err := cmd.Wait(); err != nil {
-		os.Stderr.Write([]byte(err.Error()))
-		return
+	runErr := sdk.New(fn,
+		sdk.WithConfig(c),
+		sdk.WithConcurrency(*concurrency),
+		sdk.WithMaxPayloadSize(*maxPayload),
+		sdk.WithInit(fn.Init),
+	).Run(ctx)
+
+	// closing stdin asks the binary to exit
+	stdin.Close()
+
+	select {
+	case <-fn.Done():
+	case <-time.After(killTimeout):
+		cmd.Process.Kill()
+		<-fn.Done()
 	}
+
+	// Wait must not be called before all reads from stdout completed
+	if err := cmd.Wait(); err != nil && runErr == nil {
+		runErr = err
+	}
+
+	return runErr
 }
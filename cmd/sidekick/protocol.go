@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/sdk"
+)
+
+const (
+	// typeInit is sent to the function once before any request
+	typeInit = "init"
+
+	// typeRequest asks the function to execute an event
+	typeRequest = "request"
+
+	// typeResult reports the result of a request
+	typeResult = "result"
+
+	// typeError reports that a request failed
+	typeError = "error"
+)
+
+// maxMessageSize is the maximum size of a single line written by the
+// function
+const maxMessageSize = 16 * 1024 * 1024
+
+var (
+	// ErrFunctionExited is returned for requests that are pending when the
+	// function closed it's standard output
+	ErrFunctionExited = errors.New("function exited")
+
+	// ErrDuplicateRequest is returned if a request with the same ID is
+	// already pending
+	ErrDuplicateRequest = errors.New("duplicate request ID")
+)
+
+// message is a single line of the stdio protocol. See README.md
+type message struct {
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+
+	// URN, Content and Parameters are set for init messages
+	URN        string         `json:"urn,omitempty"`
+	Content    []byte         `json:"content,omitempty"`
+	Parameters sigma.ValueMap `json:"parameters,omitempty"`
+
+	// Event is the type of the event of a request
+	Event string `json:"event,omitempty"`
+
+	// Payload holds the event data of a request or the data of a result
+	Payload []byte `json:"payload,omitempty"`
+
+	// Error holds the error message of an error
+	Error string `json:"error,omitempty"`
+}
+
+// function multiplexes requests to a function over it's standard input
+// and output. It implements sdk.Handler
+type function struct {
+	wmu sync.Mutex
+	enc *json.Encoder
+
+	mu      sync.Mutex
+	pending map[string]chan message
+	done    chan struct{}
+}
+
+// newFunction returns a new function writing requests to stdin and
+// reading results from stdout
+func newFunction(stdin io.Writer, stdout io.Reader) *function {
+	f := &function{
+		enc:     json.NewEncoder(stdin),
+		pending: make(map[string]chan message),
+		done:    make(chan struct{}),
+	}
+
+	go f.read(stdout)
+
+	return f
+}
+
+// Done returns a channel that is closed once the function closed it's
+// standard output
+func (f *function) Done() <-chan struct{} {
+	return f.done
+}
+
+// Init sends the init message to the function
+func (f *function) Init(i sdk.Init) error {
+	return f.write(message{
+		Type:       typeInit,
+		URN:        i.URN,
+		Content:    i.Content,
+		Parameters: i.Parameters,
+	})
+}
+
+// Handle sends a request for the event to the function and waits for the
+// result
+func (f *function) Handle(ctx context.Context, e sdk.Event) ([]byte, error) {
+	ch := make(chan message, 1)
+
+	f.mu.Lock()
+	select {
+	case <-f.done:
+		f.mu.Unlock()
+		return nil, ErrFunctionExited
+	default:
+	}
+
+	if _, ok := f.pending[e.ID]; ok {
+		f.mu.Unlock()
+		return nil, ErrDuplicateRequest
+	}
+	f.pending[e.ID] = ch
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		delete(f.pending, e.ID)
+		f.mu.Unlock()
+	}()
+
+	if err := f.write(message{
+		Type:    typeRequest,
+		ID:      e.ID,
+		Event:   e.Type,
+		Payload: e.Payload,
+	}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case msg := <-ch:
+		if msg.Type == typeError {
+			return nil, errors.New(msg.Error)
+		}
+		return msg.Payload, nil
+	case <-f.done:
+		return nil, ErrFunctionExited
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (f *function) write(msg message) error {
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+
+	// Encode terminates each message with a newline
+	return f.enc.Encode(msg)
+}
+
+// read passes results and errors to the pending requests until stdout is
+// closed. Lines that are not part of the protocol are written to stderr
+func (f *function) read(stdout io.Reader) {
+	defer close(f.done)
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		var msg message
+		if err := json.Unmarshal(line, &msg); err != nil || (msg.Type != typeResult && msg.Type != typeError) {
+			fmt.Fprintf(os.Stderr, "%s\n", line)
+			continue
+		}
+
+		f.mu.Lock()
+		ch, ok := f.pending[msg.ID]
+		delete(f.pending, msg.ID)
+		f.mu.Unlock()
+
+		if !ok {
+			fmt.Fprintf(os.Stderr, "dropping %s for unknown request %q\n", msg.Type, msg.ID)
+			continue
+		}
+
+		ch <- msg
+	}
+
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read from function: %s\n", err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/homebot/sigma/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeFunction reads requests from r and answers them in reverse order
+// once n requests have been received. It exits on a request with the
+// payload "exit"
+func fakeFunction(r io.Reader, w io.WriteCloser, n int, inits chan<- message) {
+	defer w.Close()
+
+	enc := json.NewEncoder(w)
+	scanner := bufio.NewScanner(r)
+
+	var requests []message
+	for scanner.Scan() {
+		var msg message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return
+		}
+
+		if msg.Type == typeInit {
+			inits <- msg
+			continue
+		}
+
+		if string(msg.Payload) == "exit" {
+			return
+		}
+
+		requests = append(requests, msg)
+		if len(requests) < n {
+			continue
+		}
+
+		// not part of the protocol and must be ignored
+		w.Write([]byte("some log line\n"))
+
+		for i := len(requests) - 1; i >= 0; i-- {
+			req := requests[i]
+			if string(req.Payload) == "fail" {
+				enc.Encode(message{Type: typeError, ID: req.ID, Error: "invalid_argument: failed"})
+				continue
+			}
+			enc.Encode(message{Type: typeResult, ID: req.ID, Payload: append(req.Payload, '!')})
+		}
+		requests = nil
+	}
+}
+
+func TestFunction(t *testing.T) {
+	assert := assert.New(t)
+
+	stdinR, stdinW := io.Pipe()
+	stdoutR, stdoutW := io.Pipe()
+
+	inits := make(chan message, 1)
+	go fakeFunction(stdinR, stdoutW, 3, inits)
+
+	fn := newFunction(stdinW, stdoutR)
+
+	assert.NoError(fn.Init(sdk.Init{URN: "urn:node", Content: []byte("content")}))
+	init := <-inits
+	assert.Equal("urn:node", init.URN)
+	assert.Equal([]byte("content"), init.Content)
+
+	type result struct {
+		res []byte
+		err error
+	}
+
+	results := make(map[string]chan result)
+	for _, id := range []string{"a", "b", "fail"} {
+		ch := make(chan result, 1)
+		results[id] = ch
+
+		go func(id string) {
+			res, err := fn.Handle(context.Background(), sdk.Event{ID: id, Payload: []byte(id)})
+			ch <- result{res, err}
+		}(id)
+	}
+
+	a := <-results["a"]
+	assert.NoError(a.err)
+	assert.Equal([]byte("a!"), a.res)
+
+	b := <-results["b"]
+	assert.NoError(b.err)
+	assert.Equal([]byte("b!"), b.res)
+
+	failed := <-results["fail"]
+	if assert.Error(failed.err) {
+		assert.Equal(&sdk.Error{Code: sdk.CodeInvalidArgument, Message: "failed"}, sdk.ParseError(failed.err.Error()))
+	}
+
+	// pending requests fail once the function exits
+	_, err := fn.Handle(context.Background(), sdk.Event{ID: "c", Payload: []byte("exit")})
+	assert.Equal(ErrFunctionExited, err)
+
+	_, err = fn.Handle(context.Background(), sdk.Event{ID: "d"})
+	assert.Equal(ErrFunctionExited, err)
+}
@@ -23,8 +23,9 @@ import (
 )
 
 var (
-	destroyName string
-	destroyURN  string
+	destroyName  string
+	destroyURN   string
+	destroyForce bool
 )
 
 // destroyCmd represents the destroy command
@@ -51,7 +52,8 @@ var destroyCmd = &cobra.Command{
 		ctx, _ := getContext(context.Background())
 
 		_, err = cli.Destroy(ctx, &sigmaV1.DestroyRequest{
-			Name: target,
+			Name:  target,
+			Force: destroyForce,
 		})
 		if err != nil {
 			log.Fatal(err)
@@ -66,4 +68,5 @@ func init() {
 
 	destroyCmd.Flags().StringVarP(&destroyName, "name", "n", "", "Name of the function to destroy")
 	destroyCmd.Flags().StringVarP(&destroyURN, "urn", "u", "", "URN of the function to destroy")
+	destroyCmd.Flags().BoolVarP(&destroyForce, "force", "f", false, "Stop all nodes immediately instead of waiting for running executions")
 }
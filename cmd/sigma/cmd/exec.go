@@ -17,7 +17,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"os"
 
 	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
 	"github.com/homebot/sigma"
@@ -30,6 +32,7 @@ var (
 	execEventType    string
 	execEventPayload string
 	execVerbose      bool
+	execStream       bool
 )
 
 // execCmd represents the exec command
@@ -60,13 +63,22 @@ var execCmd = &cobra.Command{
 
 		ctx, _ := getContext(context.Background())
 
-		res, err := cli.Dispatch(ctx, &sigmaV1.DispatchRequest{
+		req := &sigmaV1.DispatchRequest{
 			Target: target,
 			Event: &sigmaV1.DispatchEvent{
 				Type:    e.Type(),
 				Payload: e.Payload(),
 			},
-		})
+		}
+
+		if execStream {
+			if err := execStreaming(ctx, cli, req); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		res, err := cli.Dispatch(ctx, req)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -82,6 +94,40 @@ var execCmd = &cobra.Command{
 	},
 }
 
+// execStreaming dispatches req using the streaming RPC and prints output
+// as it arrives. Progress reports are printed to stderr
+func execStreaming(ctx context.Context, cli sigmaV1.SigmaClient, req *sigmaV1.DispatchRequest) error {
+	stream, err := cli.DispatchStream(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch v := res.GetResult().(type) {
+		case *sigmaV1.DispatchResult_Chunk:
+			os.Stdout.Write(v.Chunk)
+		case *sigmaV1.DispatchResult_Progress:
+			fmt.Fprintf(os.Stderr, "[%3.0f%%] %s\n", v.Progress.GetPercent(), v.Progress.GetMessage())
+		case *sigmaV1.DispatchResult_Error:
+			return errors.New(v.Error)
+		case *sigmaV1.DispatchResult_Data:
+			fmt.Println(string(v.Data))
+
+			if execVerbose {
+				fmt.Fprintf(os.Stderr, "\nNode: %s\n", res.GetNode())
+			}
+		}
+	}
+}
+
 func init() {
 	RootCmd.AddCommand(execCmd)
 
@@ -90,4 +136,5 @@ func init() {
 	execCmd.Flags().StringVarP(&execEventType, "type", "t", "", "The event type to publish")
 	execCmd.Flags().StringVarP(&execEventPayload, "payload", "d", "", "The data to send to the function")
 	execCmd.Flags().BoolVarP(&execVerbose, "verbose", "v", false, "Disable versbose output")
+	execCmd.Flags().BoolVarP(&execStream, "stream", "s", false, "Print output and progress as it arrives")
 }
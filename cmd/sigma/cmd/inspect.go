@@ -61,6 +61,34 @@ var inspectCmd = &cobra.Command{
 		fmt.Printf("Resource-ID: %s\n", u)
 		fmt.Printf("Type: %s\n", spec.Type)
 
+		if len(res.Triggers) > 0 {
+			fmt.Println("\nTriggers:")
+			for _, t := range res.Triggers {
+				state := "disabled"
+				if t.GetEnabled() {
+					state = "enabled"
+				}
+				fmt.Printf("%s:\t%s\t%s\t%s\t% 3d restarts\n", t.GetName(), t.GetType(), state, t.GetHealth(), t.GetRestarts())
+				if t.GetError() != "" {
+					fmt.Printf("\tLast-Error: %s\n", t.GetError())
+				}
+				if t.GetDropped() > 0 || t.GetCoalesced() > 0 {
+					fmt.Printf("\tEvents: %d (%d dropped, %d coalesced)\n", t.GetEvents(), t.GetDropped(), t.GetCoalesced())
+				}
+			}
+		}
+
+		if len(spec.Outputs) > 0 {
+			fmt.Println("\nOutputs:")
+			for _, o := range spec.Outputs {
+				on := o.On
+				if on == "" {
+					on = sigma.OutputOnResult
+				}
+				fmt.Printf("%s:\t%s\t%s\n", on, o.Function, o.Condition)
+			}
+		}
+
 		if !inspectVerbose {
 			fmt.Println("")
 			for _, n := range res.Nodes {
@@ -74,6 +102,8 @@ var inspectCmd = &cobra.Command{
 				last, lastErr := ptypes.Timestamp(n.Statistics.GetLastInvocation())
 				mean, meanErr := ptypes.Duration(n.Statistics.GetMeanExecTime())
 				total, totalErr := ptypes.Duration(n.Statistics.GetTotalExecTime())
+				rtt, rttErr := ptypes.Duration(n.Statistics.GetRtt())
+				seen, seenErr := ptypes.Timestamp(n.Statistics.GetLastSeen())
 
 				fmt.Printf("\n[%s]\n", n.GetUrn())
 				fmt.Printf("\tState: %s\n", n.State.String())
@@ -90,6 +120,12 @@ var inspectCmd = &cobra.Command{
 				if totalErr == nil {
 					fmt.Printf("\tTotal-Execution-Time: %s\n", total)
 				}
+				if rttErr == nil {
+					fmt.Printf("\tRound-Trip-Time: %s\n", rtt)
+				}
+				if seenErr == nil {
+					fmt.Printf("\tLast-Seen: %s\n", seen)
+				}
 			}
 		}
 	},
@@ -0,0 +1,117 @@
+// Copyright © 2017 The IoT-Cloud Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+
+	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
+	"github.com/spf13/cobra"
+)
+
+var (
+	logsFollow bool
+	logsNode   string
+	logsSince  string
+)
+
+// logsCmd represents the logs command
+var logsCmd = &cobra.Command{
+	Use:   "logs [function]",
+	Short: "Print the output of a function",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatal(errors.New("expected one argument: function-urn"))
+		}
+
+		req := &sigmaV1.LogsRequest{
+			Function: args[0],
+			Node:     logsNode,
+			Follow:   logsFollow,
+		}
+
+		if logsSince != "" {
+			since, err := parseSince(logsSince, time.Now())
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			req.Since, err = ptypes.TimestampProto(since)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		cli, conn, err := getClient()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer conn.Close()
+
+		ctx, _ := getContext(context.Background())
+		stream, err := cli.Logs(ctx, req)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for {
+			e, err := stream.Recv()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			t, _ := ptypes.Timestamp(e.GetTime())
+
+			invocation := e.GetInvocation()
+			if invocation == "" {
+				invocation = "-"
+			}
+
+			fmt.Printf("%s %s %s %s %s\n", t.Format(time.RFC3339), e.GetNode(), invocation, e.GetStream(), e.GetLine())
+		}
+	},
+}
+
+// parseSince parses s either as a duration relative to now or as a
+// RFC3339 timestamp
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --since %q: expected a duration or a RFC3339 timestamp", s)
+	}
+
+	return t, nil
+}
+
+func init() {
+	RootCmd.AddCommand(logsCmd)
+
+	logsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "Stream new log lines as they are written")
+	logsCmd.Flags().StringVar(&logsNode, "node", "", "Only print the output of the given node")
+	logsCmd.Flags().StringVar(&logsSince, "since", "", "Only print lines written since a duration (e.g. 10m) or a RFC3339 timestamp")
+}
@@ -27,6 +27,12 @@ var cfgFile string
 var (
 	sigmaServerAddress string
 	idamTokenFile      string
+
+	tlsEnabled    bool
+	tlsCA         string
+	tlsCert       string
+	tlsKey        string
+	tlsServerName string
 )
 
 // RootCmd represents the base command when called without any subcommands
@@ -51,6 +57,11 @@ func init() {
 	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.sigma.yaml)")
 	RootCmd.PersistentFlags().StringVarP(&sigmaServerAddress, "server", "S", "localhost:50051", "The address of the sigma server")
 	RootCmd.PersistentFlags().StringVarP(&idamTokenFile, "jwt", "j", "", "Path to IDAM JWT file for authentication")
+	RootCmd.PersistentFlags().BoolVar(&tlsEnabled, "tls", false, "Connect to the sigma server using TLS. Implied by the other --tls-* flags")
+	RootCmd.PersistentFlags().StringVar(&tlsCA, "tls-ca", "", "Path to the CA certificate used to verify the sigma server. Defaults to the system roots")
+	RootCmd.PersistentFlags().StringVar(&tlsCert, "tls-cert", "", "Path to the client certificate")
+	RootCmd.PersistentFlags().StringVar(&tlsKey, "tls-key", "", "Path to the private key of the client certificate")
+	RootCmd.PersistentFlags().StringVar(&tlsServerName, "tls-server-name", "", "Override the server name used to verify the sigma server certificate")
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -14,23 +14,35 @@
 package cmd
 
 import (
+	"context"
+	"io/ioutil"
 	"log"
 	"net"
 	"os"
 	"strings"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
+	"github.com/homebot/core/resource"
 	"github.com/homebot/idam/policy"
 	"github.com/homebot/insight/logger"
 	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
+	"github.com/homebot/sigma/certs"
 	"github.com/homebot/sigma/cmd/sigma/config"
+	"github.com/homebot/sigma/events"
 	"github.com/homebot/sigma/launcher"
 	"github.com/homebot/sigma/launcher/docker"
 	"github.com/homebot/sigma/launcher/process"
+	"github.com/homebot/sigma/logs"
 	"github.com/homebot/sigma/node"
+	"github.com/homebot/sigma/orchestrator"
 	"github.com/homebot/sigma/scheduler"
 	"github.com/homebot/sigma/server"
+	"github.com/homebot/sigma/trigger/builtin/fswatch"
+	"github.com/homebot/sigma/trigger/builtin/poll"
+	"github.com/homebot/sigma/trigger/plugin"
 	"github.com/spf13/cobra"
 )
 
@@ -77,17 +89,56 @@ var serverCmd = &cobra.Command{
 			log.Fatal("Invalid or no launcher configured")
 		}
 
-		nodeServer := node.NewNodeServer()
-		deployer := node.NewDeployer(nodeServer, launcher, c.Nodes.Listen)
-		scheduler, err := scheduler.NewScheduler(deployer)
+		logStore := logs.DefaultStore
+		if c.Logs.Size > 0 {
+			logStore = logs.NewStore(c.Logs.Size)
+		}
+
+		nodeOpts := []node.ServerOption{
+			node.WithLogStore(logStore),
+		}
+		if c.Nodes.HeartbeatInterval != "" {
+			interval, err := time.ParseDuration(c.Nodes.HeartbeatInterval)
+			if err != nil {
+				log.Fatalf("invalid heartbeat interval: %s", err)
+			}
+			nodeOpts = append(nodeOpts, node.WithHeartbeatInterval(interval))
+		}
+		if c.Nodes.HeartbeatThreshold > 0 {
+			nodeOpts = append(nodeOpts, node.WithHeartbeatThreshold(c.Nodes.HeartbeatThreshold))
+		}
+		if c.Nodes.GracePeriod != "" {
+			grace, err := time.ParseDuration(c.Nodes.GracePeriod)
+			if err != nil {
+				log.Fatalf("invalid grace period: %s", err)
+			}
+			nodeOpts = append(nodeOpts, node.WithGracePeriod(grace))
+		}
+		nodeOpts = append(nodeOpts, node.WithRedelivery(c.Nodes.Redeliver))
+		if c.Nodes.TLS != nil && c.Nodes.TLS.ClientCAKey != "" {
+			nodeOpts = append(nodeOpts, node.WithPeerVerification(true))
+		}
+
+		deployerOpts, err := getDeployerOptions(c.Nodes.TLS)
 		if err != nil {
 			log.Fatal(err)
 		}
-		server, err := server.NewServer(scheduler)
+
+		nodeServer := node.NewNodeServer(nodeOpts...)
+		deployer := node.NewDeployer(nodeServer, launcher, c.Nodes.Listen, deployerOpts...)
+		var schedulerOpts []scheduler.Option
+		if c.Nodes.DrainTimeout != "" {
+			timeout, err := time.ParseDuration(c.Nodes.DrainTimeout)
+			if err != nil {
+				log.Fatalf("invalid drain timeout: %s", err)
+			}
+			schedulerOpts = append(schedulerOpts, scheduler.WithDrainTimeout(timeout))
+		}
+
+		scheduler, err := scheduler.NewScheduler(deployer, schedulerOpts...)
 		if err != nil {
 			log.Fatal(err)
 		}
-
 		grpcNodeListener, err := net.Listen("tcp", c.Nodes.Listen)
 		if err != nil {
 			log.Fatal(err)
@@ -100,7 +151,12 @@ var serverCmd = &cobra.Command{
 		}
 		log.Printf("sigma server running on %s\n", grpcServerListener.Addr())
 
-		grpcNodeServer := grpc.NewServer()
+		nodeCreds, err := getServerCredentials(c.Nodes.TLS)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		grpcNodeServer := grpc.NewServer(nodeCreds...)
 		sigmaV1.RegisterNodeHandlerServer(grpcNodeServer, nodeServer)
 
 		l, err := logger.NewInsightLogger(logger.WithServiceType("sigma"))
@@ -108,13 +164,48 @@ var serverCmd = &cobra.Command{
 			log.Fatal(err)
 		}
 
+		orch, err := getOrchestrator(*c, scheduler, l)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer orch.Close()
+
+		server, err := server.NewServer(scheduler,
+			server.WithOrchestrator(orch),
+			server.WithLogStore(logStore),
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		poll.DefaultFactory.AllowCommand = c.Triggers.Poll.AllowCommand
+		poll.DefaultFactory.AllowFile = c.Triggers.Poll.AllowFile
+		poll.DefaultFactory.AllowedHosts = c.Triggers.Poll.AllowedHosts
+		fswatch.DefaultFactory.AllowedPaths = c.Triggers.FSWatch.AllowedPaths
+
+		if c.Plugins.Triggers != "" {
+			host := plugin.NewHost(c.Plugins.Triggers, l)
+			defer host.Close()
+
+			names, err := host.Load()
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("loaded trigger plugins: %s\n", strings.Join(names, ", "))
+		}
+
 		p, err := policy.NewEnforcer("homebot/api/sigma/v1/sigma.proto")
 		if err != nil {
 			log.Fatal(err)
 		}
 		p.SetLogger(l)
 
-		grpcSigmaServer := grpc.NewServer(p.ServerOptions()...)
+		sigmaCreds, err := getServerCredentials(c.Server.TLS)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		grpcSigmaServer := grpc.NewServer(append(p.ServerOptions(), sigmaCreds...)...)
 		sigmaV1.RegisterSigmaServer(grpcSigmaServer, server)
 
 		ch := make(chan struct{})
@@ -143,6 +234,87 @@ func init() {
 	serverCmd.Flags().BoolVar(&logEvents, "log-events", false, "Log events to stderr")
 }
 
+// getServerCredentials returns the gRPC server options for the TLS
+// configuration. A nil configuration disables TLS
+func getServerCredentials(c *config.TLSConfig) ([]grpc.ServerOption, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	tlsConfig, err := certs.ServerConfig(c.Cert, c.Key, c.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+
+	return []grpc.ServerOption{
+		grpc.Creds(credentials.NewTLS(tlsConfig)),
+	}, nil
+}
+
+// getDeployerOptions returns the deployer options to provision launched
+// nodes with the TLS credentials required by the node server
+func getDeployerOptions(c *config.TLSConfig) ([]node.DeployerOption, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	caFile := c.CA
+	if caFile == "" {
+		caFile = c.Cert
+	}
+
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var authority *certs.Authority
+	if c.ClientCAKey != "" {
+		authority, err = certs.LoadAuthority(c.ClientCA, c.ClientCAKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return []node.DeployerOption{
+		node.WithNodeTLS(ca, authority),
+	}, nil
+}
+
+func getOrchestrator(c config.Config, s scheduler.Scheduler, l logger.Logger) (*orchestrator.Orchestrator, error) {
+	// functions are not persisted so resumed executions have to wait
+	// until their functions are created again
+	deployed := func(id string) bool {
+		_, err := s.Inspect(context.Background(), resource.Name(id))
+		return err == nil
+	}
+
+	opts := []orchestrator.Option{
+		orchestrator.WithLogger(l),
+		orchestrator.WithFunctions(events.DefaultBus, deployed),
+	}
+
+	if c.Workflows.State != "" {
+		store, err := orchestrator.NewFileStore(c.Workflows.State)
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, orchestrator.WithStore(store))
+	}
+
+	o, err := orchestrator.New(s, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := o.Resume(); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
 func getLauncher(c config.Config) launcher.Launcher {
 	if c.Launchers.Process != nil {
 		types := make(map[string]process.TypeConfig)
@@ -25,7 +25,6 @@ import (
 	"strings"
 
 	yaml "github.com/ghodss/yaml"
-	"github.com/homebot/core/utils"
 	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
 	"github.com/homebot/sigma"
 
@@ -144,7 +143,7 @@ func init() {
 	submitCmd.Flags().StringVarP(&idOverride, "name", "n", "", "Name for the function to submit. Overrides values from the spec")
 }
 
-func parseParameters(m utils.ValueMap) error {
+func parseParameters(m sigma.ValueMap) error {
 	for _, v := range intParams {
 		k, i, err := splitInt(v)
 		if err != nil {
@@ -0,0 +1,96 @@
+// Copyright © 2017 The IoT-Cloud Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
+	"github.com/spf13/cobra"
+)
+
+var (
+	triggerURN string
+)
+
+// triggerCmd represents the trigger command
+var triggerCmd = &cobra.Command{
+	Use:   "trigger",
+	Short: "Manage the triggers of a function",
+}
+
+var triggerEnableCmd = &cobra.Command{
+	Use:   "enable [trigger]",
+	Short: "Enable a function trigger",
+	Run: func(cmd *cobra.Command, args []string) {
+		setTriggerEnabled(args, true)
+	},
+}
+
+var triggerDisableCmd = &cobra.Command{
+	Use:   "disable [trigger]",
+	Short: "Disable a function trigger",
+	Run: func(cmd *cobra.Command, args []string) {
+		setTriggerEnabled(args, false)
+	},
+}
+
+func setTriggerEnabled(args []string, enabled bool) {
+	if len(args) != 1 {
+		log.Fatal(errors.New("expected one argument: trigger-name"))
+	}
+
+	if triggerURN == "" {
+		log.Fatal("--urn must be specified")
+	}
+
+	cli, conn, err := getClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, _ := getContext(context.Background())
+
+	req := &sigmaV1.TriggerRequest{
+		Function: triggerURN,
+		Trigger:  args[0],
+	}
+
+	if enabled {
+		_, err = cli.EnableTrigger(ctx, req)
+	} else {
+		_, err = cli.DisableTrigger(ctx, req)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if enabled {
+		log.Printf("Trigger %s enabled", args[0])
+	} else {
+		log.Printf("Trigger %s disabled", args[0])
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(triggerCmd)
+	triggerCmd.AddCommand(triggerEnableCmd)
+	triggerCmd.AddCommand(triggerDisableCmd)
+
+	triggerCmd.PersistentFlags().StringVarP(&triggerURN, "urn", "u", "", "The URN of the function")
+}
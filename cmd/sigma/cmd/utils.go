@@ -5,7 +5,9 @@ import (
 
 	"github.com/homebot/idam/token"
 	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
+	"github.com/homebot/sigma/certs"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
 )
 
@@ -14,7 +16,13 @@ func getClient() (sigmaV1.SigmaClient, *grpc.ClientConn, error) {
 	if addr == "" {
 		addr = "localhost:50051"
 	}
-	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+
+	dialOpt, err := getDialOption()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := grpc.Dial(addr, dialOpt)
 
 	if err != nil {
 		return nil, nil, err
@@ -23,6 +31,22 @@ func getClient() (sigmaV1.SigmaClient, *grpc.ClientConn, error) {
 	return sigmaV1.NewSigmaClient(conn), conn, nil
 }
 
+// getDialOption returns the transport security option for the --tls-*
+// flags
+func getDialOption() (grpc.DialOption, error) {
+	if !tlsEnabled && tlsCA == "" && tlsCert == "" && tlsKey == "" && tlsServerName == "" {
+		return grpc.WithInsecure(), nil
+	}
+
+	tlsConfig, err := certs.ClientConfig(tlsCA, tlsCert, tlsKey)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.ServerName = tlsServerName
+
+	return grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)), nil
+}
+
 func getContext(ctx context.Context) (context.Context, string) {
 	// try to read the IDAM token file
 	var paths []string
@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"time"
+
+	yaml "github.com/ghodss/yaml"
+	"github.com/golang/protobuf/ptypes"
+	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
+	"github.com/homebot/sigma/orchestrator"
+	"github.com/spf13/cobra"
+)
+
+var (
+	workflowPayload string
+	workflowWait    bool
+)
+
+// workflowCmd represents the workflow command
+var workflowCmd = &cobra.Command{
+	Use:   "workflow",
+	Short: "Manage and execute workflows",
+}
+
+var workflowSubmitCmd = &cobra.Command{
+	Use:   "submit [file]",
+	Short: "Submit a workflow spec to the Sigma server",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatal(errors.New("expected one argument: workflow-file"))
+		}
+
+		content, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var w orchestrator.Workflow
+		if err := yaml.Unmarshal(content, &w); err != nil {
+			log.Fatal(err)
+		}
+
+		spec, err := json.Marshal(w)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		cli, conn, err := getClient()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer conn.Close()
+
+		ctx, _ := getContext(context.Background())
+		res, err := cli.CreateWorkflow(ctx, &sigmaV1.CreateWorkflowRequest{
+			Spec: spec,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("Workflow created successfully\nURN: %s\n", res.GetName())
+	},
+}
+
+var workflowRunCmd = &cobra.Command{
+	Use:   "run [workflow]",
+	Short: "Start a new execution of a workflow",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatal(errors.New("expected one argument: workflow-urn"))
+		}
+
+		cli, conn, err := getClient()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer conn.Close()
+
+		ctx, _ := getContext(context.Background())
+		res, err := cli.RunWorkflow(ctx, &sigmaV1.RunWorkflowRequest{
+			Name:    args[0],
+			Payload: []byte(workflowPayload),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if !workflowWait {
+			fmt.Printf("Execution: %s\n", res.GetExecution())
+			return
+		}
+
+		for {
+			e, err := cli.InspectExecution(ctx, &sigmaV1.InspectExecutionRequest{
+				Id: res.GetExecution(),
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			switch orchestrator.State(e.GetState()) {
+			case orchestrator.StateSucceeded:
+				fmt.Println(string(e.GetOutput()))
+				return
+			case orchestrator.StateFailed:
+				log.Fatal(errors.New(e.GetError()))
+			}
+
+			time.Sleep(500 * time.Millisecond)
+		}
+	},
+}
+
+var workflowInspectCmd = &cobra.Command{
+	Use:   "inspect [execution]",
+	Short: "Inspect a workflow execution step by step",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatal(errors.New("expected one argument: execution-id"))
+		}
+
+		cli, conn, err := getClient()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer conn.Close()
+
+		ctx, _ := getContext(context.Background())
+		e, err := cli.InspectExecution(ctx, &sigmaV1.InspectExecutionRequest{
+			Id: args[0],
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("Execution: %s\n", e.GetId())
+		fmt.Printf("Workflow: %s\n", e.GetWorkflow())
+		fmt.Printf("State: %s\n", e.GetState())
+		if started, err := ptypes.Timestamp(e.GetStarted()); err == nil {
+			fmt.Printf("Started: %s\n", started)
+		}
+		if finished, err := ptypes.Timestamp(e.GetFinished()); err == nil {
+			fmt.Printf("Finished: %s\n", finished)
+		}
+		if e.GetError() != "" {
+			fmt.Printf("Error: %s\n", e.GetError())
+		}
+
+		fmt.Println("\nSteps:")
+		for _, s := range e.GetSteps() {
+			fmt.Printf("%s:\t%s\t% 3d attempts\n", s.GetPath(), s.GetState(), s.GetAttempts())
+			if s.GetError() != "" {
+				fmt.Printf("\tLast-Error: %s\n", s.GetError())
+			}
+			if len(s.GetOutput()) > 0 {
+				fmt.Printf("\tOutput: %s\n", string(s.GetOutput()))
+			}
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(workflowCmd)
+	workflowCmd.AddCommand(workflowSubmitCmd)
+	workflowCmd.AddCommand(workflowRunCmd)
+	workflowCmd.AddCommand(workflowInspectCmd)
+
+	workflowRunCmd.Flags().StringVarP(&workflowPayload, "payload", "d", "", "The input to pass to the workflow")
+	workflowRunCmd.Flags().BoolVarP(&workflowWait, "wait", "w", false, "Wait for the execution to finish and print the output")
+}
@@ -5,16 +5,62 @@ import (
 	"errors"
 	"io"
 	"io/ioutil"
+	"time"
 
 	"github.com/homebot/sigma/launcher/docker"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
+// TLSConfig holds transport security settings of a gRPC server
+type TLSConfig struct {
+	// Cert and Key hold the paths to the PEM encoded server certificate
+	// and private key
+	Cert string `json:"cert" yaml:"cert"`
+	Key  string `json:"key" yaml:"key"`
+
+	// ClientCA holds the path to the certificate authority client
+	// certificates are verified with. If set, clients must present a
+	// certificate signed by it
+	ClientCA string `json:"clientCA" yaml:"clientCA"`
+
+	// ClientCAKey holds the path to the private key of ClientCA. It is only
+	// supported by the node server, which uses it to issue a client
+	// certificate for each node it launches
+	ClientCAKey string `json:"clientCAKey" yaml:"clientCAKey"`
+
+	// CA holds the path to the certificate authority that signed Cert. It
+	// is only supported by the node server and handed to launched nodes to
+	// verify the server. Defaults to Cert
+	CA string `json:"ca" yaml:"ca"`
+}
+
+// valid checks the TLS configuration of the sigma server (node = false) or
+// the node server (node = true)
+func (t TLSConfig) valid(node bool) error {
+	if t.Cert == "" || t.Key == "" {
+		return errors.New("TLS requires a certificate and a key")
+	}
+
+	if !node && (t.ClientCAKey != "" || t.CA != "") {
+		return errors.New("clientCAKey and ca are only supported by the node server")
+	}
+
+	if node && (t.ClientCA == "") != (t.ClientCAKey == "") {
+		return errors.New("clientCA and clientCAKey of the node server must be set together")
+	}
+
+	return nil
+}
+
 // SigmaServerConfig is the configuration for the sigma server
 type SigmaServerConfig struct {
 	// Listen holds the address the sigma server should listen on
 	Listen string `json:"listen" yaml:"listen"`
+
+	// TLS holds the transport security settings. If nil, the server does
+	// not use TLS
+	TLS *TLSConfig `json:"tls" yaml:"tls"`
 }
 
 // NodeServerConfig is the configuration for the node handler server
@@ -25,6 +71,34 @@ type NodeServerConfig struct {
 	// AdvertiseAddress holds the address to advertise to new node
 	// instances
 	AdvertiseAddress string `json:"advertise" yaml:"advertise"`
+
+	// HeartbeatInterval holds the interval heartbeats are sent to nodes.
+	// Defaults to 10s if empty. Use "0s" to disable heartbeats
+	HeartbeatInterval string `json:"heartbeatInterval" yaml:"heartbeatInterval"`
+
+	// HeartbeatThreshold holds the number of consecutive heartbeats a node
+	// may miss before it is marked as unhealthy. Defaults to 3
+	HeartbeatThreshold int `json:"heartbeatThreshold" yaml:"heartbeatThreshold"`
+
+	// GracePeriod holds the duration a node may stay disconnected before it
+	// is marked as unhealthy. Defaults to 30s if empty
+	GracePeriod string `json:"gracePeriod" yaml:"gracePeriod"`
+
+	// Redeliver is set if events that have not been acknowledged before a
+	// node disconnected should be sent again when the node reconnects.
+	// Otherwise those events fail
+	Redeliver bool `json:"redeliver" yaml:"redeliver"`
+
+	// DrainTimeout holds the duration nodes may take to finish in-flight
+	// events when they are removed before they are stopped anyway.
+	// Defaults to 30s if empty
+	DrainTimeout string `json:"drainTimeout" yaml:"drainTimeout"`
+
+	// TLS holds the transport security settings. If a client CA and it's
+	// key are configured, nodes must authenticate with a client
+	// certificate issued for their URN. If nil, the server does not use
+	// TLS
+	TLS *TLSConfig `json:"tls" yaml:"tls"`
 }
 
 // ProcessTypeConfig holds type configuration values for a process launcher
@@ -48,6 +122,61 @@ type Launcher struct {
 	Process *ProcessLauncherConfig `json:"process" yaml:"process"`
 }
 
+// PluginConfig is the configuration for out-of-process plugins
+type PluginConfig struct {
+	// Triggers holds the directory to load trigger plugins from
+	Triggers string `json:"triggers" yaml:"triggers"`
+}
+
+// TriggerConfig is the configuration for built-in triggers
+type TriggerConfig struct {
+	// Poll is the configuration for the poll trigger
+	Poll PollTriggerConfig `json:"poll" yaml:"poll"`
+
+	// FSWatch is the configuration for the fswatch trigger
+	FSWatch FSWatchTriggerConfig `json:"fswatch" yaml:"fswatch"`
+}
+
+// FSWatchTriggerConfig is the configuration for the fswatch trigger
+type FSWatchTriggerConfig struct {
+	// AllowedPaths holds the files and directories that may be watched. The
+	// contents of all files below them can be read by everyone allowed to
+	// create functions. If empty, fswatch triggers cannot be created
+	AllowedPaths []string `json:"allowedPaths" yaml:"allowedPaths"`
+}
+
+// PollTriggerConfig is the configuration for the poll trigger. The command
+// and file sources are disabled by default as they give everyone allowed to
+// create functions access to the sigma server host
+type PollTriggerConfig struct {
+	// AllowCommand enables the `command` source. Commands are executed
+	// using `sh -c` with the permissions of the sigma server
+	AllowCommand bool `json:"allowCommand" yaml:"allowCommand"`
+
+	// AllowFile enables the `file` source. Any file readable by the sigma
+	// server can be polled
+	AllowFile bool `json:"allowFile" yaml:"allowFile"`
+
+	// AllowedHosts restricts the hosts that can be polled using the `url`
+	// source. Entries starting with "*." match all subdomains. If empty,
+	// every host reachable from the sigma server can be polled
+	AllowedHosts []string `json:"allowedHosts" yaml:"allowedHosts"`
+}
+
+// WorkflowConfig is the configuration for the workflow orchestrator
+type WorkflowConfig struct {
+	// State holds the directory used to persist workflows and the state of
+	// their executions. If empty, workflows are only kept in memory
+	State string `json:"state" yaml:"state"`
+}
+
+// LogConfig is the configuration for capturing function logs
+type LogConfig struct {
+	// Size holds the maximum number of log lines kept in memory. Defaults
+	// to 10000
+	Size int `json:"size" yaml:"size"`
+}
+
 // Config holds the configuration for a sigma server
 type Config struct {
 	// Server is the configurtaion for the sigma server
@@ -58,6 +187,18 @@ type Config struct {
 
 	// Launchers holds launcher configuration values
 	Launchers Launcher `json:"launcher" yaml:"launcher"`
+
+	// Plugins holds plugin configuration values
+	Plugins PluginConfig `json:"plugins" yaml:"plugins"`
+
+	// Triggers holds the configuration for built-in triggers
+	Triggers TriggerConfig `json:"triggers" yaml:"triggers"`
+
+	// Workflows holds the configuration for the workflow orchestrator
+	Workflows WorkflowConfig `json:"workflows" yaml:"workflows"`
+
+	// Logs holds the configuration for capturing function logs
+	Logs LogConfig `json:"logs" yaml:"logs"`
 }
 
 // Valid checks if the configuration is valid
@@ -84,6 +225,42 @@ func (c Config) Valid() error {
 		return errors.New("no execution types configured")
 	}
 
+	if c.Nodes.HeartbeatInterval != "" {
+		if _, err := time.ParseDuration(c.Nodes.HeartbeatInterval); err != nil {
+			return errors.New("invalid heartbeat interval: " + err.Error())
+		}
+	}
+
+	if c.Nodes.GracePeriod != "" {
+		if _, err := time.ParseDuration(c.Nodes.GracePeriod); err != nil {
+			return errors.New("invalid grace period: " + err.Error())
+		}
+	}
+
+	if c.Nodes.DrainTimeout != "" {
+		if d, err := time.ParseDuration(c.Nodes.DrainTimeout); err != nil {
+			return errors.New("invalid drain timeout: " + err.Error())
+		} else if d <= 0 {
+			return errors.New("drain timeout must be positive")
+		}
+	}
+
+	if c.Server.TLS != nil {
+		if err := c.Server.TLS.valid(false); err != nil {
+			return errors.New("invalid server TLS configuration: " + err.Error())
+		}
+	}
+
+	if c.Nodes.TLS != nil {
+		if err := c.Nodes.TLS.valid(true); err != nil {
+			return errors.New("invalid node server TLS configuration: " + err.Error())
+		}
+	}
+
+	if c.Nodes.HeartbeatThreshold < 0 {
+		return errors.New("heartbeat threshold must not be negative")
+	}
+
 	return nil
 }
 
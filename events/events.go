@@ -0,0 +1,134 @@
+package events
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	// FunctionCreated is published when a function has been created
+	FunctionCreated = "sigma.function.created"
+
+	// FunctionDestroyed is published when a function has been destroyed
+	FunctionDestroyed = "sigma.function.destroyed"
+
+	// NodeCreated is published when a node has been attached to a function
+	NodeCreated = "sigma.node.created"
+
+	// NodeDestroyed is published when a node of a function has been
+	// destroyed. The data of the event holds the reason
+	NodeDestroyed = "sigma.node.destroyed"
+
+	// ExecutionFailed is published when the execution of a function failed.
+	// The data of the event holds the error
+	ExecutionFailed = "sigma.execution.failed"
+)
+
+// DefaultBufferSize is the number of events buffered for each subscription
+const DefaultBufferSize = 64
+
+// Event is a lifecycle event of the sigma platform
+type Event struct {
+	// Type is the type of the event
+	Type string `json:"type"`
+
+	// Function is the ID of the function the event belongs to
+	Function string `json:"function,omitempty"`
+
+	// Node is the ID of the node the event belongs to, if any
+	Node string `json:"node,omitempty"`
+
+	// Time is the time the event has been published
+	Time time.Time `json:"time"`
+
+	// Data holds additional, event specific, JSON data
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Bus distributes lifecycle events to all subscribers. Publishing never
+// blocks; events are dropped for subscribers that do not keep up
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// DefaultBus is the bus used by the scheduler and the internal trigger
+var DefaultBus = NewBus()
+
+// NewBus returns a new event bus
+func NewBus() *Bus {
+	return &Bus{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish publishes evt to all subscribers. If evt.Time is zero, it is set to
+// the current time
+func (b *Bus) Publish(evt Event) {
+	if evt.Time.IsZero() {
+		evt.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for s := range b.subs {
+		select {
+		case s.ch <- evt:
+		default:
+			s.mu.Lock()
+			s.dropped++
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Subscribe returns a new subscription for all events published on the bus
+func (b *Bus) Subscribe(size int) *Subscription {
+	s := &Subscription{
+		bus: b,
+		ch:  make(chan Event, size),
+	}
+	s.C = s.ch
+
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+
+	return s
+}
+
+// Subscription receives events published on a bus
+type Subscription struct {
+	// C receives all events published on the bus and is closed when the
+	// subscription is closed
+	C <-chan Event
+
+	bus  *Bus
+	ch   chan Event
+	once sync.Once
+
+	mu      sync.Mutex
+	dropped int
+}
+
+// Dropped returns the number of events dropped because the subscriber did
+// not keep up
+func (s *Subscription) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.dropped
+}
+
+// Close removes the subscription from the bus and closes C
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subs, s)
+		s.bus.mu.Unlock()
+
+		close(s.ch)
+	})
+}
@@ -0,0 +1,43 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBus(t *testing.T) {
+	assert := assert.New(t)
+
+	b := NewBus()
+
+	first := b.Subscribe(1)
+	second := b.Subscribe(1)
+
+	b.Publish(Event{Type: NodeCreated, Function: "fn", Node: "node"})
+
+	for _, s := range []*Subscription{first, second} {
+		evt := <-s.C
+		assert.Equal(NodeCreated, evt.Type)
+		assert.Equal("fn", evt.Function)
+		assert.False(evt.Time.IsZero())
+	}
+
+	// slow subscribers do not block the publisher
+	b.Publish(Event{Type: NodeDestroyed})
+	b.Publish(Event{Type: NodeDestroyed})
+	assert.Equal(1, first.Dropped())
+
+	first.Close()
+	first.Close()
+
+	_, ok := <-first.C
+	assert.True(ok, "buffered events are still delivered")
+	_, ok = <-first.C
+	assert.False(ok)
+
+	// events are dropped as long as the buffer is full
+	b.Publish(Event{Type: ExecutionFailed})
+	assert.Equal(NodeDestroyed, (<-second.C).Type)
+	assert.Equal(2, second.Dropped())
+}
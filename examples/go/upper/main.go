@@ -0,0 +1,33 @@
+// Command upper is an example sigma function runtime written in Go using
+// the sdk package. It returns the payload of each event in upper case
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"unicode/utf8"
+
+	"github.com/homebot/sigma/sdk"
+)
+
+func handle(ctx context.Context, e sdk.Event) ([]byte, error) {
+	if !utf8.Valid(e.Payload) {
+		return nil, sdk.Errorf(sdk.CodeInvalidArgument, "payload is not valid UTF-8")
+	}
+
+	return bytes.ToUpper(e.Payload), nil
+}
+
+func main() {
+	err := sdk.Run(sdk.HandlerFunc(handle),
+		sdk.WithConcurrency(4),
+		sdk.WithInit(func(i sdk.Init) error {
+			log.Printf("node %s initialized", i.URN)
+			return nil
+		}),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
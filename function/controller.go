@@ -2,9 +2,11 @@ package function
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
-	"io"
+	"fmt"
 	"reflect"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,11 +14,11 @@ import (
 
 	"github.com/homebot/core/event"
 	"github.com/homebot/core/resource"
-	"github.com/homebot/core/utils"
 	"github.com/homebot/insight/logger"
 	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
 	"github.com/homebot/sigma"
 	"github.com/homebot/sigma/autoscale"
+	"github.com/homebot/sigma/events"
 	"github.com/homebot/sigma/metrics"
 	"github.com/homebot/sigma/node"
 	"github.com/homebot/sigma/trigger"
@@ -48,8 +50,40 @@ var (
 	// ErrMissingDeployer is returned when a auto-scaler is configured but no node
 	// launcher has been set
 	ErrMissingDeployer = errors.New("auto-scaling can only be used with a node launcher")
+
+	// ErrDuplicateTrigger is returned when two triggers of a function share the
+	// same name
+	ErrDuplicateTrigger = errors.New("duplicate trigger name")
+
+	// ErrUnknownTrigger is returned when the trigger in question does not exist
+	// on the function controller
+	ErrUnknownTrigger = errors.New("unknown trigger")
+
+	// ErrInvalidCondition is returned when the condition of a trigger cannot
+	// be compiled
+	ErrInvalidCondition = errors.New("invalid trigger condition")
+
+	// ErrInvalidTransform is returned when the transform of a trigger cannot
+	// be compiled
+	ErrInvalidTransform = errors.New("invalid trigger transform")
+
+	// ErrInvalidBatch is returned when the batch options of a trigger are
+	// invalid
+	ErrInvalidBatch = errors.New("invalid trigger batch")
+
+	// ErrInvalidLimit is returned when the rate limit, debounce or throttle
+	// options of a trigger are invalid
+	ErrInvalidLimit = errors.New("invalid trigger limit")
+
+	// ErrInvalidOutput is returned when an output route of the function is
+	// invalid
+	ErrInvalidOutput = errors.New("invalid function output")
 )
 
+// DefaultDrainTimeout is the default time nodes may take to finish their
+// in-flight events when being drained
+const DefaultDrainTimeout = 30 * time.Second
+
 // ControlLoopHook is executed during each interation of the function controllers
 // control loop
 type ControlLoopHook func(c Controller)
@@ -68,12 +102,20 @@ type Controller interface {
 	// DestroyAll destroys all node controllers
 	DestroyAll() error
 
+	// DrainAll drains all node controllers and destroys them once their
+	// in-flight events have finished or the drain timeout elapsed
+	DrainAll(context.Context) error
+
 	// AddNodeController creates a new controller for the given node
 	AddNodeController(node.Controller) error
 
 	// DestroyNode destroys the given controller
 	DestroyNode(string) error
 
+	// DrainNode drains the given controller and destroys it once it's
+	// in-flight events have finished or the drain timeout elapsed
+	DrainNode(context.Context, string) error
+
 	// Nodes returns the state for all controllers registered
 	Nodes() map[string]node.State
 
@@ -84,9 +126,27 @@ type Controller interface {
 	// managed by this registry
 	FunctionSpec() sigma.FunctionSpec
 
+	// Triggers returns the state of all triggers of the function
+	Triggers() []TriggerState
+
+	// EnableTrigger enables the trigger with the given name
+	EnableTrigger(string) error
+
+	// DisableTrigger disables the trigger with the given name. Disabled
+	// triggers are closed until they are enabled again
+	DisableTrigger(string) error
+
 	// Dispatch dispatches an event to one of the function nodes and returns
-	// the ID of the selected node, the result and any error encountered
-	Dispatch(event sigma.Event) (string, []byte, error)
+	// the ID of the selected node, the result and any error encountered.
+	// If ctx is done before the node returns a result, the node is marked
+	// as unhealthy and replaced so the execution is stopped
+	Dispatch(ctx context.Context, event sigma.Event) (string, []byte, error)
+
+	// DispatchStream works like Dispatch but calls the FrameFunc for each
+	// partial result the selected node reports before the final result.
+	// Chunks passed to the FrameFunc are neither part of the returned
+	// result nor of the result routed to outputs
+	DispatchStream(ctx context.Context, event sigma.Event, fn node.FrameFunc) (string, []byte, error)
 
 	// AttachControlLoopHook attaches a new control loop hook to be executed
 	// on each interation of the function controller control loop
@@ -101,10 +161,17 @@ type controller struct {
 	spec sigma.FunctionSpec
 
 	event          event.Dispatcher
+	bus            *events.Bus
 	deployer       node.Deployer
 	triggerBuilder trigger.Builder
 
-	triggers map[string]trigger.Trigger
+	triggerLock    sync.Mutex
+	triggers       map[string]*triggerHandle
+	triggerBackoff trigger.Backoff
+	compiled       map[string]compiledTrigger
+
+	outputs       []output
+	outputHandler OutputHandler
 
 	// registered controllers
 	rw          sync.RWMutex
@@ -115,6 +182,7 @@ type controller struct {
 	wg   sync.WaitGroup
 
 	controlLoopInterval time.Duration
+	drainTimeout        time.Duration
 	autoScaler          autoscale.AutoScaler
 	metrics             *metrics.Metrics
 
@@ -139,21 +207,20 @@ func (ctrl *controller) Start() error {
 	ctrl.stop = make(chan struct{})
 
 	if ctrl.triggerBuilder != nil {
+		ctrl.triggerLock.Lock()
+		defer ctrl.triggerLock.Unlock()
+
 		for _, spec := range ctrl.spec.Triggers {
-			t, err := ctrl.triggerBuilder.Build(spec.Type, spec.Options)
-			if err != nil {
-				for _, t := range ctrl.triggers {
-					t.Close()
-				}
+			h := ctrl.newTriggerHandle(spec)
+			ctrl.triggers[spec.Name] = h
+
+			if err := ctrl.startTrigger(h); err != nil {
+				ctrl.closeTriggers()
+				ctrl.triggers = make(map[string]*triggerHandle)
+				ctrl.stop = nil
 				return err
 			}
-
-			ctrl.triggers[spec.Type] = t
-
-			ctrl.wg.Add(1)
-			go ctrl.handleTrigger(t, spec, ctrl.spec.Parameteres)
 		}
-
 	}
 
 	ctrl.wg.Add(1)
@@ -162,32 +229,6 @@ func (ctrl *controller) Start() error {
 	return nil
 }
 
-// TODO(homebot): add logging
-func (ctrl *controller) handleTrigger(t trigger.Trigger, tSpec sigma.TriggerSpec, values utils.ValueMap) {
-	defer ctrl.wg.Done()
-
-	for {
-		evt, err := t.Next()
-		if err != nil && err == io.EOF {
-			return
-		}
-
-		ok, err := trigger.Evaluate(tSpec.Condition, evt, values)
-		if ok && err == nil {
-			_, res, err := ctrl.Dispatch(evt)
-			if err != nil {
-				ctrl.l.Errorf("failed to dispatch trigger event %q: %s", evt.Type(), err)
-			} else {
-				ctrl.l.Infof("dispatched trigger event %q: %s", evt.Type(), string(res))
-			}
-		} else if err != nil {
-			ctrl.l.Errorf("trigger spec %q: failed to evaluate condition %q: %s", tSpec.Type, tSpec.Condition, err)
-		} else {
-			ctrl.l.Debugf("trigger spec %s: condition not satisfied for event %q", tSpec.Type, evt.Type())
-		}
-	}
-}
-
 // Stop stops the function controller control loop
 func (ctrl *controller) Stop() error {
 	ctrl.rw.Lock()
@@ -201,19 +242,15 @@ func (ctrl *controller) Stop() error {
 
 	close(stop)
 
-	ctrl.wg.Wait()
-
-	ctrl.rw.Lock()
-	defer ctrl.rw.Unlock()
+	// triggers must be closed before waiting for the trigger handlers
+	// to return
+	ctrl.triggerLock.Lock()
+	err := ctrl.closeTriggers()
+	ctrl.triggerLock.Unlock()
 
-	var first error
-	for _, t := range ctrl.triggers {
-		if err := t.Close(); err != nil && first == nil {
-			first = err
-		}
-	}
+	ctrl.wg.Wait()
 
-	return first
+	return err
 }
 
 // DestroyAll destroys all controllers
@@ -233,11 +270,67 @@ func (ctrl *controller) DestroyAll() error {
 			ctrl.l.Warnf("failed to destroy node %s: %s", key, err)
 		}
 		delete(ctrl.controllers, key)
+
+		ctrl.dispatchNodeDestroyed(key, "function destroyed")
 	}
 
 	return firstErr
 }
 
+// DrainError holds the errors of all nodes that failed to drain
+type DrainError []error
+
+func (d DrainError) Error() string {
+	msgs := make([]string, len(d))
+	for i, err := range d {
+		msgs[i] = err.Error()
+	}
+
+	return "failed to drain nodes: " + strings.Join(msgs, "; ")
+}
+
+// DrainAll drains all controllers in parallel. If any node fails to drain,
+// a DrainError with the errors of all of them is returned
+func (ctrl *controller) DrainAll(ctx context.Context) error {
+	ctrl.rw.RLock()
+	ids := make([]string, 0, len(ctrl.controllers))
+	for key := range ctrl.controllers {
+		ids = append(ids, key)
+	}
+	ctrl.rw.RUnlock()
+
+	ctrl.l.Infof("draining all nodes")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(ids))
+
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+
+			if err := ctrl.drainNode(ctx, id, "function destroyed"); err != nil {
+				ctrl.l.Warnf("failed to drain node %s: %s", id, err)
+				errs <- fmt.Errorf("%s: %s", id, err)
+			}
+		}(id)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	var drainErr DrainError
+	for err := range errs {
+		drainErr = append(drainErr, err)
+	}
+
+	if len(drainErr) > 0 {
+		return drainErr
+	}
+
+	return nil
+}
+
 // AddNodeController creates a new controller and appends it to the registry
 func (ctrl *controller) AddNodeController(n node.Controller) error {
 	ctrl.rw.Lock()
@@ -247,13 +340,60 @@ func (ctrl *controller) AddNodeController(n node.Controller) error {
 
 	ctrl.l.Infof("node %s attached to controller", n.URN())
 
-	//ctrl.dispatchEvent(urn.SigmaEventNodeCreated, n.URN().Resource(), nil)
+	ctrl.dispatchEvent(events.NodeCreated, n.URN(), nil)
 
 	return nil
 }
 
 // DestroyNode destroys the controller with `id`
 func (ctrl *controller) DestroyNode(u string) error {
+	return ctrl.destroyNode(u, "requested")
+}
+
+// DrainNode drains the controller with `id`
+func (ctrl *controller) DrainNode(ctx context.Context, u string) error {
+	return ctrl.drainNode(ctx, u, "requested")
+}
+
+// drainNode drains the controller with `id` and removes it once it has
+// been closed. The node stays registered while draining so it is reported
+// by Nodes() but never selected for dispatching
+func (ctrl *controller) drainNode(ctx context.Context, u string, reason string) error {
+	ctrl.rw.RLock()
+	n, ok := ctrl.controllers[u]
+	ctrl.rw.RUnlock()
+
+	if !ok {
+		return ErrUnknownController
+	}
+
+	ctrl.l.Infof("draining node %s", u)
+
+	timeout := ctrl.drainTimeout
+	if timeout == time.Duration(0) {
+		timeout = DefaultDrainTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := n.Drain(ctx)
+
+	ctrl.rw.Lock()
+	defer ctrl.rw.Unlock()
+
+	// the node may have been destroyed while we were waiting
+	if current, ok := ctrl.controllers[u]; ok && current == n {
+		delete(ctrl.controllers, u)
+		ctrl.dispatchNodeDestroyed(u, reason)
+	}
+
+	return err
+}
+
+// destroyNode destroys the controller with `id` and publishes a lifecycle
+// event with the given reason
+func (ctrl *controller) destroyNode(u string, reason string) error {
 	ctrl.rw.Lock()
 	defer ctrl.rw.Unlock()
 
@@ -266,7 +406,7 @@ func (ctrl *controller) DestroyNode(u string) error {
 
 	delete(ctrl.controllers, u)
 
-	//ctrl.dispatchEvent(urn.SigmaEventNodeDestroyed, u.Resource(), nil)
+	ctrl.dispatchNodeDestroyed(u, reason)
 
 	return node.Close()
 }
@@ -284,6 +424,20 @@ func (ctrl *controller) Nodes() map[string]node.State {
 	return m
 }
 
+// scalableNodes returns the state of all nodes that count towards the
+// current scale of the function. Draining nodes are already being removed
+// and would otherwise be scaled down again on the next iteration
+func (ctrl *controller) scalableNodes() map[string]node.State {
+	nodes := ctrl.Nodes()
+	for id, state := range nodes {
+		if state == node.StateDraining {
+			delete(nodes, id)
+		}
+	}
+
+	return nodes
+}
+
 // Stats returns statistics for each node part of this function controller
 func (ctrl *controller) Stats() map[string]node.Stats {
 	ctrl.rw.RLock()
@@ -303,17 +457,22 @@ func (ctrl *controller) FunctionSpec() sigma.FunctionSpec {
 }
 
 // Dispatch dispatches an event to a healthy and idle controller
-func (ctrl *controller) Dispatch(event sigma.Event) (selectedNode string, result []byte, err error) {
+func (ctrl *controller) Dispatch(ctx context.Context, event sigma.Event) (string, []byte, error) {
+	return ctrl.DispatchStream(ctx, event, nil)
+}
+
+// DispatchStream dispatches an event to a healthy and idle controller and
+// forwards partial results to fn
+func (ctrl *controller) DispatchStream(ctx context.Context, event sigma.Event, fn node.FrameFunc) (selectedNode string, result []byte, err error) {
 	defer func() {
 		if err != nil {
-			n := selectedNode
-			if n == "" {
-				n = ctrl.Name().String()
-			}
-			//ctrl.dispatchEvent(urn.SigmaEventFunctionFailed, n.Resource(), []byte(err.Error()))
-		} else {
-			//ctrl.dispatchEvent(urn.SigmaEventFunctionExecuted, selectedNode.Resource(), result)
+			blob, _ := json.Marshal(map[string]string{
+				"error": err.Error(),
+			})
+			ctrl.dispatchEvent(events.ExecutionFailed, selectedNode, blob)
 		}
+
+		ctrl.routeOutputs(result, err)
 	}()
 
 	ctrl.rw.RLock()
@@ -322,10 +481,10 @@ func (ctrl *controller) Dispatch(event sigma.Event) (selectedNode string, result
 	for id, node := range ctrl.controllers {
 		if node.State().CanSelect() {
 			selectedNode = id
-			result, err = node.Dispatch(context.Background(), &sigmaV1.DispatchEvent{
+			result, err = node.Stream(ctx, &sigmaV1.DispatchEvent{
 				Urn:     id,
 				Payload: event.Payload(),
-			})
+			}, fn)
 
 			if err == nil {
 				ctrl.l.Infof("dispatched event to %s", selectedNode)
@@ -383,11 +542,30 @@ func (ctrl *controller) DetachControlLoopHook(hook ControlLoopHook) error {
 
 // NewController creates a new node controller registry
 func NewController(spec sigma.FunctionSpec, opts ...ControllerOption) (Controller, error) {
+	triggers, err := nameTriggers(spec.Triggers)
+	if err != nil {
+		return nil, err
+	}
+	spec.Triggers = triggers
+
+	compiled, err := compileTriggers(spec.Triggers)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := compileOutputs(spec.Outputs)
+	if err != nil {
+		return nil, err
+	}
+
 	ctrl := &controller{
-		spec:        spec,
-		metrics:     metrics.GetMetrics(),
-		controllers: make(map[string]node.Controller),
-		triggers:    make(map[string]trigger.Trigger),
+		spec:           spec,
+		metrics:        metrics.GetMetrics(),
+		controllers:    make(map[string]node.Controller),
+		triggers:       make(map[string]*triggerHandle),
+		triggerBackoff: trigger.DefaultBackoff,
+		compiled:       compiled,
+		outputs:        outputs,
 	}
 
 	for _, opt := range opts {
@@ -469,37 +647,47 @@ func (ctrl *controller) deployNode(ch chan error) {
 	ch <- nil
 }
 
+// scaleDownOrder defines the order nodes are selected for removal during
+// scale-down. Nodes executing an event are only drained if there are not
+// enough idle ones
+var scaleDownOrder = []node.State{
+	node.StateUnhealthy,
+	node.StateDisabled,
+	node.StateActive,
+	node.StateRunning,
+}
+
 func (ctrl *controller) scaleDown(amount int) {
+	nodes := ctrl.Nodes()
 	removed := 0
-	retries := 0
-
-	for removed < amount {
-		nodes := ctrl.Nodes()
 
+	for _, want := range scaleDownOrder {
 		for id, state := range nodes {
-			switch state {
-			case node.StateActive, node.StateDisabled, node.StateUnhealthy:
-				removed++
-				if err := ctrl.DestroyNode(id); err != nil {
-					l, _ := logger.NewInsightLogger()
-					l.Warnf("failed to completely destroy %s: %s", id, err.Error())
-				}
-			default:
-			}
-
 			if removed >= amount {
 				return
 			}
-		}
 
-		// TODO(homebot) make maximum number of destroy-tries configurable
-		if retries > 10 {
-			return
-		}
+			if state != want {
+				continue
+			}
+			removed++
+
+			// unhealthy nodes will not finish their events anyway
+			if state == node.StateUnhealthy {
+				if err := ctrl.destroyNode(id, "scale-down"); err != nil {
+					ctrl.l.Warnf("failed to completely destroy %s: %s", id, err.Error())
+				}
+				continue
+			}
+
+			ctrl.wg.Add(1)
+			go func(id string) {
+				defer ctrl.wg.Done()
 
-		if removed < amount {
-			// Sleep 100ms before trying to find other nodes to kill
-			<-time.After(time.Millisecond * 100)
+				if err := ctrl.drainNode(context.Background(), id, "scale-down"); err != nil {
+					ctrl.l.Warnf("failed to drain %s: %s", id, err.Error())
+				}
+			}(id)
 		}
 	}
 }
@@ -517,7 +705,7 @@ func (ctrl *controller) controlLoop(stop chan struct{}) {
 		states := ctrl.Nodes()
 		for key, state := range states {
 			if !state.IsHealthy() {
-				if err := ctrl.DestroyNode(key); err != nil {
+				if err := ctrl.destroyNode(key, "unhealthy"); err != nil {
 					ctrl.l.Warnf("failed to destroy unhealthy node %s: %s", key, err)
 				}
 			}
@@ -530,7 +718,7 @@ func (ctrl *controller) controlLoop(stop chan struct{}) {
 
 		// Now, run the auto-scaler (if we have one)
 		if ctrl.autoScaler != nil {
-			selected, direction, amount := ctrl.autoScaler.Check(metrics, ctrl.Nodes())
+			selected, direction, amount := ctrl.autoScaler.Check(metrics, ctrl.scalableNodes())
 
 			if direction != autoscale.ScaleNop {
 				what := "create"
@@ -564,5 +752,25 @@ func (ctrl *controller) controlLoop(stop chan struct{}) {
 	}
 }
 
-func (ctrl *controller) dispatchEvent(typ string, id string, payload []byte) {
+// dispatchEvent publishes a lifecycle event for the function on the event
+// bus. node may be empty for events not related to a specific node
+func (ctrl *controller) dispatchEvent(typ string, node string, payload []byte) {
+	if ctrl.bus == nil {
+		return
+	}
+
+	ctrl.bus.Publish(events.Event{
+		Type:     typ,
+		Function: ctrl.spec.ID,
+		Node:     node,
+		Data:     payload,
+	})
+}
+
+func (ctrl *controller) dispatchNodeDestroyed(node string, reason string) {
+	blob, _ := json.Marshal(map[string]string{
+		"reason": reason,
+	})
+
+	ctrl.dispatchEvent(events.NodeDestroyed, node, blob)
 }
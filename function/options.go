@@ -9,6 +9,7 @@ import (
 
 	"github.com/homebot/core/event"
 	"github.com/homebot/sigma/autoscale"
+	"github.com/homebot/sigma/events"
 )
 
 // ControllerOption defines some configuration options for a function
@@ -24,6 +25,15 @@ func WithEventDispatcher(disp event.Dispatcher) ControllerOption {
 	}
 }
 
+// WithEventBus configures the bus the function controller publishes it's
+// lifecycle events on
+func WithEventBus(bus *events.Bus) ControllerOption {
+	return func(c *controller) error {
+		c.bus = bus
+		return nil
+	}
+}
+
 // WithControlLoopInterval configures the interval for the function controllers
 // control loop
 func WithControlLoopInterval(duration time.Duration) ControllerOption {
@@ -33,6 +43,19 @@ func WithControlLoopInterval(duration time.Duration) ControllerOption {
 	}
 }
 
+// WithDrainTimeout configures how long nodes may take to finish in-flight
+// events when they are drained before they are stopped anyway
+func WithDrainTimeout(d time.Duration) ControllerOption {
+	return func(c *controller) error {
+		if d <= 0 {
+			return errors.New("invalid drain timeout")
+		}
+
+		c.drainTimeout = d
+		return nil
+	}
+}
+
 // WithAutoScaler sets the auto-scaler to use for the function controller
 // if another auto-scaler is already attached, an error is returned
 func WithAutoScaler(scaler autoscale.AutoScaler) ControllerOption {
@@ -113,3 +136,21 @@ func WithTriggerBuilder(b trigger.Builder) ControllerOption {
 		return nil
 	}
 }
+
+// WithTriggerBackoff configures the backoff used when restarting failed
+// triggers
+func WithTriggerBackoff(b trigger.Backoff) ControllerOption {
+	return func(c *controller) error {
+		c.triggerBackoff = b
+		return nil
+	}
+}
+
+// WithOutputHandler sets the handler used to route function results to the
+// output functions of the spec
+func WithOutputHandler(h OutputHandler) ControllerOption {
+	return func(c *controller) error {
+		c.outputHandler = h
+		return nil
+	}
+}
@@ -0,0 +1,99 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/trigger"
+)
+
+const (
+	// EventFunctionResult is the type of events routed to output functions
+	// on success. The payload of the event is the result of the function
+	EventFunctionResult = "sigma.function.result"
+
+	// EventFunctionError is the type of events routed to output functions
+	// when the execution failed
+	EventFunctionError = "sigma.function.error"
+)
+
+// OutputHandler is invoked for each event that should be routed to an
+// output function
+type OutputHandler func(function string, event sigma.Event)
+
+// output is a compiled output route
+type output struct {
+	spec      sigma.OutputSpec
+	condition *trigger.Condition
+}
+
+// compileOutputs validates the output routes and compiles their conditions
+func compileOutputs(specs []sigma.OutputSpec) ([]output, error) {
+	var res []output
+
+	for idx, spec := range specs {
+		if spec.Function == "" {
+			return nil, fmt.Errorf("%s: output %d: missing function", ErrInvalidOutput, idx)
+		}
+
+		switch spec.On {
+		case "":
+			spec.On = sigma.OutputOnResult
+		case sigma.OutputOnResult, sigma.OutputOnError:
+		default:
+			return nil, fmt.Errorf("%s: output %d: unsupported value for `on`: %q", ErrInvalidOutput, idx, spec.On)
+		}
+
+		c, err := trigger.CompileCondition(spec.Condition)
+		if err != nil {
+			return nil, fmt.Errorf("%s: output %d: %s", ErrInvalidOutput, idx, err)
+		}
+
+		res = append(res, output{
+			spec:      spec,
+			condition: c,
+		})
+	}
+
+	return res, nil
+}
+
+// routeOutputs routes the result or error of an execution to all matching
+// output functions
+func (ctrl *controller) routeOutputs(result []byte, execErr error) {
+	if ctrl.outputHandler == nil || len(ctrl.outputs) == 0 {
+		return
+	}
+
+	on := sigma.OutputOnResult
+	evt := sigma.NewSimpleEvent(EventFunctionResult, result)
+
+	if execErr != nil {
+		blob, _ := json.Marshal(map[string]string{
+			"function": ctrl.spec.ID,
+			"error":    execErr.Error(),
+		})
+
+		on = sigma.OutputOnError
+		evt = sigma.NewSimpleEvent(EventFunctionError, blob)
+	}
+
+	for _, o := range ctrl.outputs {
+		if o.spec.On != on {
+			continue
+		}
+
+		ok, err := o.condition.Evaluate(evt, ctrl.spec.Parameteres)
+		if err != nil {
+			ctrl.l.Errorf("output %q: failed to evaluate condition %q: %s", o.spec.Function, o.spec.Condition, err)
+			continue
+		}
+
+		if !ok {
+			continue
+		}
+
+		ctrl.outputHandler(o.spec.Function, evt)
+	}
+}
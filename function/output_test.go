@@ -0,0 +1,67 @@
+package function
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/homebot/sigma"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileOutputs(t *testing.T) {
+	assert := assert.New(t)
+
+	outputs, err := compileOutputs([]sigma.OutputSpec{
+		{Function: "a"},
+		{Function: "b", On: sigma.OutputOnError},
+	})
+	assert.NoError(err)
+	assert.Equal(sigma.OutputOnResult, outputs[0].spec.On)
+	assert.Equal(sigma.OutputOnError, outputs[1].spec.On)
+
+	for _, spec := range []sigma.OutputSpec{
+		{},
+		{Function: "a", On: "always"},
+		{Function: "a", Condition: "payload =="},
+	} {
+		_, err := compileOutputs([]sigma.OutputSpec{spec})
+		assert.Error(err)
+	}
+}
+
+func TestController_RouteOutputs(t *testing.T) {
+	assert := assert.New(t)
+
+	ctrl := newTestController(nil)
+
+	var err error
+	ctrl.outputs, err = compileOutputs([]sigma.OutputSpec{
+		{Function: "all"},
+		{Function: "hot", Condition: "json(payload).temp > 30"},
+		{Function: "errors", On: sigma.OutputOnError},
+	})
+	if !assert.NoError(err) {
+		return
+	}
+
+	routed := make(map[string]sigma.Event)
+	ctrl.outputHandler = func(fn string, evt sigma.Event) {
+		routed[fn] = evt
+	}
+
+	ctrl.routeOutputs([]byte(`{"temp": 21}`), nil)
+	assert.Len(routed, 1)
+	assert.Equal(EventFunctionResult, routed["all"].Type())
+	assert.Equal(`{"temp": 21}`, string(routed["all"].Payload()))
+
+	routed = make(map[string]sigma.Event)
+	ctrl.routeOutputs([]byte(`{"temp": 35}`), nil)
+	assert.Len(routed, 2)
+	assert.NotNil(routed["hot"])
+
+	routed = make(map[string]sigma.Event)
+	ctrl.routeOutputs(nil, errors.New("failed"))
+	assert.Len(routed, 1)
+	assert.Equal(EventFunctionError, routed["errors"].Type())
+	assert.JSONEq(`{"function": "test", "error": "failed"}`, string(routed["errors"].Payload()))
+}
@@ -0,0 +1,156 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/node"
+	"github.com/stretchr/testify/assert"
+)
+
+type nodeMock struct {
+	urn   string
+	state node.State
+
+	// block makes Stream wait until the context is done
+	block bool
+
+	// drainErr is returned from Drain
+	drainErr error
+
+	mu      sync.Mutex
+	drained bool
+	closed  bool
+}
+
+func (n *nodeMock) URN() string       { return n.urn }
+func (n *nodeMock) State() node.State { return n.state }
+func (n *nodeMock) Stats() node.Stats { return node.Stats{} }
+
+func (n *nodeMock) Dispatch(ctx context.Context, e *sigmaV1.DispatchEvent) ([]byte, error) {
+	return nil, nil
+}
+
+func (n *nodeMock) Stream(ctx context.Context, e *sigmaV1.DispatchEvent, fn node.FrameFunc) ([]byte, error) {
+	if n.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return nil, nil
+}
+
+func (n *nodeMock) OnDestroy(func(node.Controller)) {}
+
+func (n *nodeMock) Drain(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.drained = true
+	return n.drainErr
+}
+
+func (n *nodeMock) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.closed = true
+	return nil
+}
+
+func TestController_ScaleDownPrefersIdle(t *testing.T) {
+	assert := assert.New(t)
+
+	ctrl := newTestController(nil)
+
+	running := &nodeMock{urn: "running", state: node.StateRunning}
+	active := &nodeMock{urn: "active", state: node.StateActive}
+	unhealthy := &nodeMock{urn: "unhealthy", state: node.StateUnhealthy}
+
+	for _, n := range []*nodeMock{running, active, unhealthy} {
+		assert.NoError(ctrl.AddNodeController(n))
+	}
+
+	ctrl.scaleDown(2)
+	ctrl.wg.Wait()
+
+	assert.True(unhealthy.closed)
+	assert.False(unhealthy.drained)
+	assert.True(active.drained)
+	assert.False(running.drained)
+	assert.False(running.closed)
+
+	assert.Equal(map[string]node.State{"running": node.StateRunning}, ctrl.Nodes())
+}
+
+func TestController_DrainAll(t *testing.T) {
+	assert := assert.New(t)
+
+	ctrl := newTestController(nil)
+
+	running := &nodeMock{urn: "running", state: node.StateRunning}
+	active := &nodeMock{urn: "active", state: node.StateActive}
+
+	for _, n := range []*nodeMock{running, active} {
+		assert.NoError(ctrl.AddNodeController(n))
+	}
+
+	assert.NoError(ctrl.DrainAll(context.Background()))
+	assert.True(running.drained)
+	assert.True(active.drained)
+	assert.Empty(ctrl.Nodes())
+}
+
+func TestController_DrainAllErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	ctrl := newTestController(nil)
+
+	for _, n := range []*nodeMock{
+		{urn: "a", state: node.StateActive, drainErr: errors.New("timeout")},
+		{urn: "b", state: node.StateActive, drainErr: errors.New("timeout")},
+		{urn: "c", state: node.StateActive},
+	} {
+		assert.NoError(ctrl.AddNodeController(n))
+	}
+
+	err := ctrl.DrainAll(context.Background())
+	if assert.IsType(DrainError{}, err) {
+		assert.Len(err, 2)
+	}
+	assert.Empty(ctrl.Nodes())
+}
+
+func TestController_ScalableNodes(t *testing.T) {
+	assert := assert.New(t)
+
+	ctrl := newTestController(nil)
+
+	for _, n := range []*nodeMock{
+		{urn: "active", state: node.StateActive},
+		{urn: "draining", state: node.StateDraining},
+	} {
+		assert.NoError(ctrl.AddNodeController(n))
+	}
+
+	// draining nodes must not be scaled down again
+	assert.Equal(map[string]node.State{"active": node.StateActive}, ctrl.scalableNodes())
+}
+
+func TestController_DispatchCancel(t *testing.T) {
+	assert := assert.New(t)
+
+	ctrl := newTestController(nil)
+	assert.NoError(ctrl.AddNodeController(&nodeMock{urn: "blocking", state: node.StateActive, block: true}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	selected, _, err := ctrl.Dispatch(ctx, sigma.NewSimpleEvent("test", nil))
+	assert.Equal("blocking", selected)
+	assert.Equal(context.DeadlineExceeded, err)
+}
@@ -0,0 +1,664 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/trigger"
+)
+
+const (
+	// EventTriggerHealth is dispatched when the health of a trigger changes
+	EventTriggerHealth = "sigma.trigger.health"
+)
+
+// TriggerState describes the current state of a function trigger
+type TriggerState struct {
+	// Name is the name of the trigger
+	Name string
+
+	// Type is the type of the trigger
+	Type string
+
+	// Enabled is true if the trigger is currently enabled
+	Enabled bool
+
+	// Health is the current health of the trigger
+	Health trigger.Health
+
+	// Error holds the last error encountered by the trigger
+	Error string
+
+	// Restarts holds the number of times the trigger has been restarted
+	Restarts int
+
+	// Events holds the number of events received from the trigger
+	Events int
+
+	// Dropped holds the number of events dropped due to rate limiting or
+	// throttling
+	Dropped int
+
+	// Coalesced holds the number of events replaced by a later event while
+	// debouncing
+	Coalesced int
+}
+
+// ToProtobuf converts the trigger state to it's protocol buffer representation
+func (t TriggerState) ToProtobuf() *sigmaV1.Trigger {
+	return &sigmaV1.Trigger{
+		Name:      t.Name,
+		Type:      t.Type,
+		Enabled:   t.Enabled,
+		Health:    string(t.Health),
+		Error:     t.Error,
+		Restarts:  int64(t.Restarts),
+		Events:    int64(t.Events),
+		Dropped:   int64(t.Dropped),
+		Coalesced: int64(t.Coalesced),
+	}
+}
+
+// triggerHandle holds a function trigger and it's current state
+type triggerHandle struct {
+	spec sigma.TriggerSpec
+	compiledTrigger
+
+	mu       sync.Mutex
+	enabled  bool
+	health   trigger.Health
+	lastErr  error
+	restarts int
+	events   int
+
+	// trigger is nil as long as the trigger is disabled or waiting to be
+	// restarted
+	trigger trigger.Trigger
+
+	// stop is closed when the trigger is disabled
+	stop chan struct{}
+
+	// limiter is set if the trigger has a rate limit, debounce or throttle
+	limiter *trigger.Limiter
+
+	// batcher is set if the trigger batches events
+	batcher *trigger.Batcher
+}
+
+// newTriggerHandle returns a new trigger handle for spec
+func (ctrl *controller) newTriggerHandle(spec sigma.TriggerSpec) *triggerHandle {
+	h := &triggerHandle{
+		spec:            spec,
+		compiledTrigger: ctrl.compiled[spec.Name],
+	}
+
+	if h.batch != nil {
+		h.batcher = trigger.NewBatcher(*h.batch, func(evt sigma.Event) {
+			ctrl.dispatchTriggerEvent(h, evt)
+		})
+	}
+
+	if h.limit != nil {
+		h.limiter = trigger.NewLimiter(*h.limit, func(evt sigma.Event) {
+			ctrl.emitTriggerEvent(h, evt)
+		})
+	}
+
+	return h
+}
+
+func (h *triggerHandle) state() TriggerState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := TriggerState{
+		Name:     h.spec.Name,
+		Type:     h.spec.Type,
+		Enabled:  h.enabled,
+		Health:   h.health,
+		Restarts: h.restarts,
+		Events:   h.events,
+	}
+
+	if h.lastErr != nil {
+		s.Error = h.lastErr.Error()
+	}
+
+	if h.limiter != nil {
+		stats := h.limiter.Stats()
+		s.Dropped = stats.Dropped
+		s.Coalesced = stats.Coalesced
+	}
+
+	return s
+}
+
+// replace replaces the trigger instance old with new. It returns false if
+// the trigger has been disabled in the meantime
+func (h *triggerHandle) replace(old, new trigger.Trigger) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.enabled || h.trigger != old {
+		return false
+	}
+
+	h.trigger = new
+
+	return true
+}
+
+// triggerOptions returns the options of spec including the ID of the function
+// as trigger.OptionFunction
+func (ctrl *controller) triggerOptions(spec sigma.TriggerSpec) map[string]string {
+	opts := make(map[string]string, len(spec.Options)+1)
+	for k, v := range spec.Options {
+		opts[k] = v
+	}
+	opts[trigger.OptionFunction] = ctrl.spec.ID
+
+	return opts
+}
+
+// startTrigger builds the trigger and starts supervising it. The caller must
+// hold the trigger lock
+func (ctrl *controller) startTrigger(h *triggerHandle) error {
+	t, err := ctrl.triggerBuilder.Build(h.spec.Type, ctrl.triggerOptions(h.spec))
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+
+	h.mu.Lock()
+	h.enabled = true
+	h.trigger = t
+	h.stop = stop
+	h.lastErr = nil
+	h.restarts = 0
+	h.mu.Unlock()
+
+	ctrl.setTriggerHealth(h, trigger.HealthRunning, nil)
+
+	ctrl.wg.Add(1)
+	go ctrl.superviseTrigger(h, t, stop)
+
+	return nil
+}
+
+// stopTrigger disables and closes the trigger
+func (ctrl *controller) stopTrigger(h *triggerHandle) error {
+	h.mu.Lock()
+	if !h.enabled {
+		h.mu.Unlock()
+		return nil
+	}
+
+	h.enabled = false
+	close(h.stop)
+
+	t := h.trigger
+	h.trigger = nil
+	h.mu.Unlock()
+
+	ctrl.setTriggerHealth(h, trigger.HealthStopped, nil)
+
+	var err error
+	if t != nil {
+		err = t.Close()
+	}
+
+	// dispatch events that are still debounced or waiting for their batch
+	// to complete
+	if h.limiter != nil {
+		h.limiter.Flush()
+	}
+	if h.batcher != nil {
+		h.batcher.Flush()
+	}
+
+	return err
+}
+
+// closeTriggers closes all running triggers and returns the first error
+// encountered. The caller must hold the trigger lock
+func (ctrl *controller) closeTriggers() error {
+	var first error
+
+	for _, h := range ctrl.triggers {
+		if err := ctrl.stopTrigger(h); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// Triggers returns the state of all function triggers
+func (ctrl *controller) Triggers() []TriggerState {
+	ctrl.triggerLock.Lock()
+	defer ctrl.triggerLock.Unlock()
+
+	var res []TriggerState
+
+	for _, spec := range ctrl.spec.Triggers {
+		if h, ok := ctrl.triggers[spec.Name]; ok {
+			res = append(res, h.state())
+			continue
+		}
+
+		res = append(res, TriggerState{
+			Name:   spec.Name,
+			Type:   spec.Type,
+			Health: trigger.HealthStopped,
+		})
+	}
+
+	return res
+}
+
+// EnableTrigger enables the trigger with the given name
+func (ctrl *controller) EnableTrigger(name string) error {
+	ctrl.rw.RLock()
+	running := ctrl.stop != nil
+	ctrl.rw.RUnlock()
+
+	if !running {
+		return ErrNotRunning
+	}
+
+	ctrl.triggerLock.Lock()
+	defer ctrl.triggerLock.Unlock()
+
+	h, ok := ctrl.triggers[name]
+	if !ok {
+		return ErrUnknownTrigger
+	}
+
+	h.mu.Lock()
+	enabled := h.enabled
+	h.mu.Unlock()
+
+	if enabled {
+		return nil
+	}
+
+	if err := ctrl.startTrigger(h); err != nil {
+		return err
+	}
+
+	ctrl.l.Infof("trigger %q enabled", name)
+
+	return nil
+}
+
+// DisableTrigger disables and closes the trigger with the given name
+func (ctrl *controller) DisableTrigger(name string) error {
+	ctrl.triggerLock.Lock()
+	defer ctrl.triggerLock.Unlock()
+
+	h, ok := ctrl.triggers[name]
+	if !ok {
+		return ErrUnknownTrigger
+	}
+
+	ctrl.l.Infof("trigger %q disabled", name)
+
+	return ctrl.stopTrigger(h)
+}
+
+// superviseTrigger handles all events of the trigger and restarts the trigger
+// if it fails
+func (ctrl *controller) superviseTrigger(h *triggerHandle, t trigger.Trigger, stop chan struct{}) {
+	defer ctrl.wg.Done()
+
+	failures := 0
+
+	for {
+		evt, err := t.Next()
+		if err == nil {
+			if failures > 0 {
+				failures = 0
+				ctrl.setTriggerHealth(h, trigger.HealthRunning, nil)
+			}
+
+			ctrl.handleTriggerEvent(h, evt)
+			continue
+		}
+
+		select {
+		case <-stop:
+			// the trigger has been disabled or the function has been stopped
+			return
+		default:
+		}
+
+		if err == io.EOF {
+			// the trigger finished on it's own
+			ctrl.l.Infof("trigger %q finished", h.spec.Name)
+
+			if h.replace(t, nil) {
+				t.Close()
+			}
+			ctrl.setTriggerHealth(h, trigger.HealthStopped, nil)
+			return
+		}
+
+		t, failures = ctrl.recoverTrigger(h, t, stop, err, failures)
+		if t == nil {
+			return
+		}
+	}
+}
+
+// recoverTrigger waits for the backoff delay and either retries the trigger
+// for temporary errors or rebuilds it using the trigger builder. It returns
+// nil if the trigger failed permanently or has been stopped
+func (ctrl *controller) recoverTrigger(h *triggerHandle, t trigger.Trigger, stop chan struct{}, cause error, failures int) (trigger.Trigger, int) {
+	for {
+		failures++
+
+		if trigger.IsPermanent(cause) || (ctrl.triggerBackoff.MaxRetries > 0 && failures > ctrl.triggerBackoff.MaxRetries) {
+			ctrl.l.Errorf("trigger %q failed permanently: %s", h.spec.Name, cause)
+
+			if t != nil && h.replace(t, nil) {
+				t.Close()
+			}
+			ctrl.setTriggerHealth(h, trigger.HealthFailed, cause)
+			return nil, failures
+		}
+
+		delay := ctrl.triggerBackoff.Delay(failures)
+
+		ctrl.l.Warnf("trigger %q failed: %s (retrying in %s)", h.spec.Name, cause, delay)
+		ctrl.setTriggerHealth(h, trigger.HealthBackingOff, cause)
+
+		select {
+		case <-stop:
+			return nil, failures
+		case <-time.After(delay):
+		}
+
+		// temporary errors are retried using the same trigger instance
+		if t != nil && trigger.IsTemporary(cause) {
+			return t, failures
+		}
+
+		if t != nil {
+			if !h.replace(t, nil) {
+				return nil, failures
+			}
+			t.Close()
+		}
+
+		newTrigger, err := ctrl.triggerBuilder.Build(h.spec.Type, ctrl.triggerOptions(h.spec))
+		if err != nil {
+			cause = err
+			t = nil
+			continue
+		}
+
+		if !h.replace(nil, newTrigger) {
+			newTrigger.Close()
+			return nil, failures
+		}
+
+		h.mu.Lock()
+		h.restarts++
+		h.mu.Unlock()
+
+		ctrl.l.Infof("trigger %q restarted", h.spec.Name)
+		ctrl.setTriggerHealth(h, trigger.HealthRunning, nil)
+
+		return newTrigger, failures
+	}
+}
+
+// setTriggerHealth updates the health of the trigger and dispatches a
+// lifecycle event if it changed
+func (ctrl *controller) setTriggerHealth(h *triggerHandle, health trigger.Health, err error) {
+	h.mu.Lock()
+	changed := h.health != health
+	h.health = health
+	if err != nil {
+		h.lastErr = err
+	}
+	h.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	payload := map[string]string{
+		"trigger": h.spec.Name,
+		"health":  string(health),
+	}
+
+	if err != nil {
+		payload["error"] = err.Error()
+	}
+
+	blob, _ := json.Marshal(payload)
+	ctrl.dispatchEvent(EventTriggerHealth, "", blob)
+}
+
+// handleTriggerEvent evaluates the trigger condition on evt, applies the
+// transform and passes it through the limiter and batcher to the function if
+// the condition is satisfied. Events implementing trigger.Replier that are
+// dropped on the way are replied with the reason
+func (ctrl *controller) handleTriggerEvent(h *triggerHandle, evt sigma.Event) {
+	tSpec := h.spec
+
+	h.mu.Lock()
+	h.events++
+	h.mu.Unlock()
+
+	ok, err := h.condition.Evaluate(evt, ctrl.spec.Parameteres)
+	if ok && err == nil {
+		replier, isReplier := evt.(trigger.Replier)
+
+		transformed, err := h.transform.Apply(evt, ctrl.spec.Parameteres)
+		if err != nil {
+			ctrl.l.Errorf("trigger %q: failed to apply transform %q: %s", tSpec.Name, tSpec.Transform, err)
+			ctrl.reply(h, evt, nil, err)
+			return
+		}
+		evt = transformed
+
+		if isReplier {
+			evt = trigger.WithReplier(evt, replier)
+		}
+
+		if h.limiter != nil {
+			h.limiter.Add(evt)
+			return
+		}
+
+		ctrl.emitTriggerEvent(h, evt)
+	} else if err != nil {
+		ctrl.l.Errorf("trigger %q: failed to evaluate condition %q: %s", tSpec.Name, tSpec.Condition, err)
+		ctrl.reply(h, evt, nil, err)
+	} else {
+		ctrl.l.Debugf("trigger %q: condition not satisfied for event %q", tSpec.Name, evt.Type())
+		ctrl.reply(h, evt, nil, trigger.ErrEventFiltered)
+	}
+}
+
+// emitTriggerEvent adds evt to the current batch or dispatches it directly if
+// the trigger does not batch events
+func (ctrl *controller) emitTriggerEvent(h *triggerHandle, evt sigma.Event) {
+	if h.batcher != nil {
+		h.batcher.Add(evt)
+		return
+	}
+
+	ctrl.dispatchTriggerEvent(h, evt)
+}
+
+// dispatchTriggerEvent dispatches a trigger event or batch to the function
+// and passes the result to the event if it implements trigger.Replier
+func (ctrl *controller) dispatchTriggerEvent(h *triggerHandle, evt sigma.Event) {
+	_, res, err := ctrl.Dispatch(context.Background(), evt)
+	if err != nil {
+		ctrl.l.Errorf("trigger %q: failed to dispatch event %q: %s", h.spec.Name, evt.Type(), err)
+	} else {
+		ctrl.l.Infof("trigger %q: dispatched event %q: %s", h.spec.Name, evt.Type(), string(res))
+	}
+
+	ctrl.reply(h, evt, res, err)
+}
+
+// reply passes the result of evt to the event if it implements
+// trigger.Replier. Events that are never dispatched are replied with the
+// error that caused them to be dropped
+func (ctrl *controller) reply(h *triggerHandle, evt sigma.Event, res []byte, err error) {
+	if r, ok := evt.(trigger.Replier); ok {
+		if err := r.Reply(res, err); err != nil {
+			ctrl.l.Errorf("trigger %q: failed to reply to event %q: %s", h.spec.Name, evt.Type(), err)
+		}
+	}
+}
+
+// compiledTrigger holds the compiled condition, transform, limit and batch
+// options of a trigger
+type compiledTrigger struct {
+	condition *trigger.Condition
+	transform *trigger.Transform
+	limit     *trigger.LimitOptions
+	batch     *trigger.BatchOptions
+}
+
+// compileTriggers compiles the conditions and transforms of all triggers and
+// returns them by trigger name
+func compileTriggers(specs []sigma.TriggerSpec) (map[string]compiledTrigger, error) {
+	res := make(map[string]compiledTrigger)
+
+	for _, spec := range specs {
+		c, err := trigger.CompileCondition(spec.Condition)
+		if err != nil {
+			return nil, fmt.Errorf("%s: trigger %q: %s", ErrInvalidCondition, spec.Name, err)
+		}
+
+		t, err := trigger.CompileTransform(spec.Transform)
+		if err != nil {
+			return nil, fmt.Errorf("%s: trigger %q: %s", ErrInvalidTransform, spec.Name, err)
+		}
+
+		ct := compiledTrigger{
+			condition: c,
+			transform: t,
+		}
+
+		if ct.limit, err = compileLimit(spec); err != nil {
+			return nil, fmt.Errorf("%s: trigger %q: %s", ErrInvalidLimit, spec.Name, err)
+		}
+
+		if spec.Batch != nil {
+			opts := trigger.BatchOptions{
+				MaxEvents: spec.Batch.MaxEvents,
+				MaxBytes:  spec.Batch.MaxBytes,
+			}
+
+			if spec.Batch.MaxWait != "" {
+				if opts.MaxWait, err = time.ParseDuration(spec.Batch.MaxWait); err != nil {
+					return nil, fmt.Errorf("%s: trigger %q: %s", ErrInvalidBatch, spec.Name, err)
+				}
+			}
+
+			if err := opts.Valid(); err != nil {
+				return nil, fmt.Errorf("%s: trigger %q: %s", ErrInvalidBatch, spec.Name, err)
+			}
+
+			ct.batch = &opts
+		}
+
+		res[spec.Name] = ct
+	}
+
+	return res, nil
+}
+
+// compileLimit parses the rate limit, debounce and throttle options of spec.
+// It returns nil if none of them is set
+func compileLimit(spec sigma.TriggerSpec) (*trigger.LimitOptions, error) {
+	var (
+		opts trigger.LimitOptions
+		err  error
+	)
+
+	if spec.RateLimit != "" {
+		if opts.Rate, err = trigger.ParseRate(spec.RateLimit); err != nil {
+			return nil, err
+		}
+	}
+
+	if spec.Debounce != "" {
+		if opts.Debounce, err = time.ParseDuration(spec.Debounce); err != nil {
+			return nil, err
+		}
+	}
+
+	if spec.Throttle != "" {
+		if opts.Throttle, err = time.ParseDuration(spec.Throttle); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := opts.Valid(); err != nil {
+		return nil, err
+	}
+
+	if opts.IsZero() {
+		return nil, nil
+	}
+
+	return &opts, nil
+}
+
+// nameTriggers returns a copy of specs where each unnamed trigger has a name
+// derived from it's type. It returns ErrDuplicateTrigger if two triggers share
+// the same name
+func nameTriggers(specs []sigma.TriggerSpec) ([]sigma.TriggerSpec, error) {
+	res := make([]sigma.TriggerSpec, len(specs))
+	names := make(map[string]bool)
+
+	// explicitly named triggers take precedence over generated names
+	for idx, spec := range specs {
+		res[idx] = spec
+
+		if spec.Name == "" {
+			continue
+		}
+
+		if names[spec.Name] {
+			return nil, fmt.Errorf("%s: %q", ErrDuplicateTrigger, spec.Name)
+		}
+		names[spec.Name] = true
+	}
+
+	for idx, spec := range res {
+		if spec.Name != "" {
+			continue
+		}
+
+		name := spec.Type
+		if names[name] {
+			name = fmt.Sprintf("%s-%d", spec.Type, idx)
+		}
+
+		if names[name] {
+			return nil, fmt.Errorf("%s: %q", ErrDuplicateTrigger, name)
+		}
+
+		names[name] = true
+		res[idx].Name = name
+	}
+
+	return res, nil
+}
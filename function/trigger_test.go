@@ -0,0 +1,405 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/homebot/insight/logger"
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/events"
+	"github.com/homebot/sigma/metrics"
+	"github.com/homebot/sigma/node"
+	"github.com/homebot/sigma/trigger"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTrigger returns the configured errors from Next() before
+// blocking until closed
+type fakeTrigger struct {
+	errs   []error
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (f *fakeTrigger) URN() string { return "fake" }
+
+func (f *fakeTrigger) Next() (sigma.Event, error) {
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return nil, err
+	}
+
+	<-f.closed
+	return nil, io.EOF
+}
+
+func (f *fakeTrigger) Close() error {
+	f.once.Do(func() { close(f.closed) })
+	return nil
+}
+
+type fakeBuilder struct {
+	mu     sync.Mutex
+	builds int
+	errs   []error
+	opts   map[string]string
+
+	// buildErr is returned when the trigger is rebuilt
+	buildErr error
+}
+
+func (b *fakeBuilder) Build(typ string, opts map[string]string) (trigger.Trigger, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.builds++
+	b.opts = opts
+
+	if b.builds > 1 && b.buildErr != nil {
+		return nil, b.buildErr
+	}
+
+	t := &fakeTrigger{closed: make(chan struct{})}
+	if b.builds == 1 {
+		t.errs = b.errs
+	}
+
+	return t, nil
+}
+
+func newTestController(b trigger.Builder) *controller {
+	return &controller{
+		spec: sigma.FunctionSpec{
+			ID: "test",
+			Triggers: []sigma.TriggerSpec{
+				{Name: "fake", Type: "fake"},
+			},
+		},
+		metrics:        metrics.GetMetrics(),
+		controllers:    make(map[string]node.Controller),
+		triggers:       make(map[string]*triggerHandle),
+		triggerBuilder: b,
+		triggerBackoff: trigger.Backoff{
+			Initial:    time.Millisecond,
+			Max:        5 * time.Millisecond,
+			MaxRetries: 3,
+		},
+		l: logger.NopLogger{},
+	}
+}
+
+func waitForState(ctrl *controller, fn func(TriggerState) bool) TriggerState {
+	deadline := time.Now().Add(time.Second)
+
+	for {
+		state := ctrl.Triggers()[0]
+		if fn(state) || time.Now().After(deadline) {
+			return state
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTriggerSupervisor_Restart(t *testing.T) {
+	assert := assert.New(t)
+
+	b := &fakeBuilder{errs: []error{errors.New("broken")}}
+	ctrl := newTestController(b)
+
+	assert.NoError(ctrl.Start())
+
+	state := waitForState(ctrl, func(s TriggerState) bool {
+		return s.Health == trigger.HealthRunning && s.Restarts > 0
+	})
+
+	assert.Equal(trigger.HealthRunning, state.Health)
+	assert.Equal(1, state.Restarts)
+	assert.Equal("broken", state.Error)
+	assert.Equal(2, b.builds)
+
+	assert.NoError(ctrl.Stop())
+	assert.Equal(trigger.HealthStopped, ctrl.Triggers()[0].Health)
+}
+
+func TestTriggerSupervisor_Permanent(t *testing.T) {
+	assert := assert.New(t)
+
+	b := &fakeBuilder{errs: []error{trigger.Permanent(errors.New("broken"))}}
+	ctrl := newTestController(b)
+
+	assert.NoError(ctrl.Start())
+
+	state := waitForState(ctrl, func(s TriggerState) bool {
+		return s.Health == trigger.HealthFailed
+	})
+	assert.Equal(trigger.HealthFailed, state.Health)
+	assert.Equal(0, state.Restarts)
+	assert.Equal(1, b.builds)
+
+	assert.NoError(ctrl.Stop())
+}
+
+func TestTriggerSupervisor_MaxRetries(t *testing.T) {
+	assert := assert.New(t)
+
+	b := &fakeBuilder{
+		errs:     []error{errors.New("broken")},
+		buildErr: errors.New("cannot build"),
+	}
+	ctrl := newTestController(b)
+
+	assert.NoError(ctrl.Start())
+
+	state := waitForState(ctrl, func(s TriggerState) bool {
+		return s.Health == trigger.HealthFailed
+	})
+	assert.Equal(trigger.HealthFailed, state.Health)
+	assert.Equal("cannot build", state.Error)
+	assert.Equal(0, state.Restarts)
+
+	// the initial build and one rebuild per retry
+	b.mu.Lock()
+	assert.Equal(4, b.builds)
+	b.mu.Unlock()
+
+	assert.NoError(ctrl.Stop())
+}
+
+func TestController_EnableDisableTrigger(t *testing.T) {
+	assert := assert.New(t)
+
+	b := &fakeBuilder{}
+	ctrl := newTestController(b)
+
+	assert.Equal(ErrNotRunning, ctrl.EnableTrigger("fake"))
+
+	assert.NoError(ctrl.Start())
+	assert.True(ctrl.Triggers()[0].Enabled)
+
+	assert.NoError(ctrl.DisableTrigger("fake"))
+	assert.False(ctrl.Triggers()[0].Enabled)
+	assert.NoError(ctrl.DisableTrigger("fake"))
+	assert.Equal(ErrUnknownTrigger, ctrl.DisableTrigger("unknown"))
+
+	assert.NoError(ctrl.EnableTrigger("fake"))
+	assert.True(ctrl.Triggers()[0].Enabled)
+	assert.Equal(ErrUnknownTrigger, ctrl.EnableTrigger("unknown"))
+	assert.Equal(2, b.builds)
+	assert.Equal("test", b.opts[trigger.OptionFunction])
+
+	assert.NoError(ctrl.Stop())
+	assert.False(ctrl.Triggers()[0].Enabled)
+}
+
+func TestNameTriggers(t *testing.T) {
+	assert := assert.New(t)
+
+	res, err := nameTriggers([]sigma.TriggerSpec{
+		{Type: "timer"},
+		{Type: "timer"},
+		{Type: "fswatch", Name: "timer-1-custom"},
+	})
+	assert.NoError(err)
+	assert.Equal("timer", res[0].Name)
+	assert.Equal("timer-1", res[1].Name)
+	assert.Equal("timer-1-custom", res[2].Name)
+
+	// explicit names take precedence over generated ones
+	res, err = nameTriggers([]sigma.TriggerSpec{
+		{Type: "timer"},
+		{Type: "nats", Name: "timer"},
+	})
+	assert.NoError(err)
+	assert.Equal("timer-0", res[0].Name)
+	assert.Equal("timer", res[1].Name)
+
+	_, err = nameTriggers([]sigma.TriggerSpec{
+		{Type: "timer", Name: "a"},
+		{Type: "timer", Name: "a"},
+	})
+	assert.Error(err)
+}
+
+func TestNewController_InvalidCondition(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewController(sigma.FunctionSpec{
+		ID: "test",
+		Triggers: []sigma.TriggerSpec{
+			{Type: "timer", Condition: "type =="},
+		},
+	})
+	assert.Error(err)
+}
+
+func TestNewController_InvalidTransform(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewController(sigma.FunctionSpec{
+		ID: "test",
+		Triggers: []sigma.TriggerSpec{
+			{Type: "timer", Transform: "{{ .Event"},
+		},
+	})
+	assert.Error(err)
+}
+
+func TestNewController_InvalidBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, b := range []*sigma.BatchSpec{
+		{},
+		{MaxWait: "soon"},
+		{MaxEvents: -1},
+	} {
+		_, err := NewController(sigma.FunctionSpec{
+			ID: "test",
+			Triggers: []sigma.TriggerSpec{
+				{Type: "timer", Batch: b},
+			},
+		})
+		assert.Error(err, "%+v", b)
+	}
+}
+
+func TestNewController_InvalidLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, spec := range []sigma.TriggerSpec{
+		{Type: "timer", RateLimit: "fast"},
+		{Type: "timer", Debounce: "soon"},
+		{Type: "timer", Throttle: "-1s"},
+	} {
+		_, err := NewController(sigma.FunctionSpec{
+			ID:       "test",
+			Triggers: []sigma.TriggerSpec{spec},
+		})
+		assert.Error(err, "%+v", spec)
+	}
+}
+
+func TestController_LifecycleEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	bus := events.NewBus()
+	sub := bus.Subscribe(10)
+	defer sub.Close()
+
+	ctrl := newTestController(&fakeBuilder{})
+	ctrl.bus = bus
+
+	_, _, err := ctrl.Dispatch(context.Background(), sigma.NewSimpleEvent("test", nil))
+	assert.Equal(ErrNoSelectableNodes, err)
+
+	evt := <-sub.C
+	assert.Equal(events.ExecutionFailed, evt.Type)
+	assert.Equal("test", evt.Function)
+	assert.JSONEq(`{"error": "no selectable nodes"}`, string(evt.Data))
+
+	assert.NoError(ctrl.Start())
+	defer ctrl.Stop()
+
+	evt = <-sub.C
+	assert.Equal(EventTriggerHealth, evt.Type)
+	assert.JSONEq(`{"trigger": "fake", "health": "running"}`, string(evt.Data))
+}
+
+// replyRecorder is a request event recording the reply it receives
+type replyRecorder struct {
+	sigma.Event
+
+	mu      sync.Mutex
+	replied bool
+	err     error
+}
+
+func newReplyRecorder(payload string) *replyRecorder {
+	return &replyRecorder{Event: sigma.NewSimpleEvent("request", []byte(payload))}
+}
+
+func (r *replyRecorder) Reply(result []byte, err error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.replied = true
+	r.err = err
+	return nil
+}
+
+func (r *replyRecorder) reply() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.replied, r.err
+}
+
+func TestController_ReplyDroppedEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	specs := []sigma.TriggerSpec{
+		{Name: "filtered", Condition: `payload == "pass"`},
+		{Name: "invalid-condition", Condition: "type"},
+		{Name: "invalid-transform", Transform: `{{ fromJson "invalid" }}`},
+		{Name: "throttled", Throttle: "1h"},
+		{Name: "debounced", Debounce: "1h"},
+		{Name: "rate", RateLimit: "1/h"},
+		{Name: "batched", Batch: &sigma.BatchSpec{MaxEvents: 2}},
+	}
+
+	compiled, err := compileTriggers(specs)
+	if !assert.NoError(err) {
+		return
+	}
+
+	ctrl := newTestController(nil)
+	ctrl.compiled = compiled
+	assert.NoError(ctrl.AddNodeController(&nodeMock{urn: "node", state: node.StateActive}))
+
+	handles := make(map[string]*triggerHandle)
+	for _, spec := range specs {
+		handles[spec.Name] = ctrl.newTriggerHandle(spec)
+	}
+
+	send := func(name, payload string) *replyRecorder {
+		evt := newReplyRecorder(payload)
+		ctrl.handleTriggerEvent(handles[name], evt)
+		return evt
+	}
+
+	replied := func(evt *replyRecorder) error {
+		ok, err := evt.reply()
+		assert.True(ok, "event has not been replied")
+		return err
+	}
+
+	assert.NoError(replied(send("filtered", "pass")))
+	assert.Equal(trigger.ErrEventFiltered, replied(send("filtered", "drop")))
+
+	assert.Error(replied(send("invalid-condition", "")))
+	assert.Error(replied(send("invalid-transform", "")))
+
+	assert.NoError(replied(send("throttled", "")))
+	assert.Equal(trigger.ErrEventDropped, replied(send("throttled", "")))
+
+	first := send("debounced", "")
+	second := send("debounced", "")
+	assert.Equal(trigger.ErrEventCoalesced, replied(first))
+	handles["debounced"].limiter.Flush()
+	assert.NoError(replied(second))
+
+	assert.NoError(replied(send("rate", "")))
+	assert.Equal(trigger.ErrEventDropped, replied(send("rate", "")))
+
+	// all events of a batch receive the result of the batch
+	first = send("batched", "")
+	ok, _ := first.reply()
+	assert.False(ok)
+	second = send("batched", "")
+	assert.NoError(replied(first))
+	assert.NoError(replied(second))
+}
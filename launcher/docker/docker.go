@@ -4,10 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/homebot/sigma/launcher"
 	"github.com/moby/moby/client"
 )
@@ -91,12 +93,41 @@ func (l *Launcher) Create(ctx context.Context, typ string, config launcher.Confi
 	}
 	log.Printf("[docker] container started successfully: %s\n", res.ID)
 
+	if config.Stdout != nil || config.Stderr != nil {
+		go l.captureLogs(res.ID, config)
+	}
+
 	return &Instance{
 		id:       res.ID,
 		launcher: l,
 	}, nil
 }
 
+// captureLogs copies the output of the container to the writers of config
+// until the container stops
+func (l *Launcher) captureLogs(id string, config launcher.Config) {
+	out, err := l.cli.ContainerLogs(context.Background(), id, types.ContainerLogsOptions{
+		ShowStdout: config.Stdout != nil,
+		ShowStderr: config.Stderr != nil,
+		Follow:     true,
+	})
+	if err != nil {
+		log.Printf("[docker] failed to capture logs of %s: %s\n", id, err)
+		return
+	}
+	defer out.Close()
+
+	stdout, stderr := config.Stdout, config.Stderr
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+
+	stdcopy.StdCopy(stdout, stderr, out)
+}
+
 // Instance represents a sigma function node instance
 // running in a docker container. It implements the
 // github.com/homebot/sigma/launcher.Instance interface
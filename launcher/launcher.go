@@ -3,6 +3,7 @@ package launcher
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 )
 
@@ -21,15 +22,49 @@ type Config struct {
 	Address string
 	Secret  string
 	URN     string
+
+	// Type holds the function type the instance has been launched for. The
+	// node must register itself using this type
+	Type string
+
+	// Stdout and Stderr receive the output of the instance. If nil, the
+	// output is written to the launchers own stdout and stderr
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// TLS holds the credentials the instance uses to connect to the node
+	// handler server. If nil, the connection is not encrypted
+	TLS *TLSConfig
+}
+
+// TLSConfig holds PEM encoded TLS credentials for a node instance
+type TLSConfig struct {
+	// CA holds the certificate authority used to verify the node handler
+	// server
+	CA []byte
+
+	// Cert and Key hold the client certificate of the node. They are empty
+	// if the node handler server does not require client certificates
+	Cert []byte
+	Key  []byte
 }
 
 // EnvVars returns the current configuration as a map[string]string
 func (c Config) EnvVars() map[string]string {
-	return map[string]string{
+	env := map[string]string{
 		"SIGMA_HANDLER_ADDRESS": c.Address,
 		"SIGMA_ACCESS_SECRET":   c.Secret,
 		"SIGMA_INSTANCE_URN":    c.URN,
+		"SIGMA_NODE_TYPE":       c.Type,
 	}
+
+	if c.TLS != nil {
+		env["SIGMA_TLS_CA"] = string(c.TLS.CA)
+		env["SIGMA_TLS_CERT"] = string(c.TLS.Cert)
+		env["SIGMA_TLS_KEY"] = string(c.TLS.Key)
+	}
+
+	return env
 }
 
 // Env returns a slice of strings containing environment variables
@@ -49,6 +84,16 @@ func ConfigFromEnv() Config {
 	c.Secret = os.Getenv("SIGMA_ACCESS_SECRET")
 	c.URN = os.Getenv("SIGMA_INSTANCE_URN")
 	c.Address = os.Getenv("SIGMA_HANDLER_ADDRESS")
+	c.Type = os.Getenv("SIGMA_NODE_TYPE")
+
+	ca, cert, key := os.Getenv("SIGMA_TLS_CA"), os.Getenv("SIGMA_TLS_CERT"), os.Getenv("SIGMA_TLS_KEY")
+	if ca != "" || cert != "" {
+		c.TLS = &TLSConfig{
+			CA:   []byte(ca),
+			Cert: []byte(cert),
+			Key:  []byte(key),
+		}
+	}
 
 	return c
 }
@@ -85,8 +85,18 @@ func (l *Launcher) Create(ctx context.Context, typ string, c launcher.Config) (l
 		return nil, err
 	}
 
-	go io.Copy(os.Stdout, stdout)
-	go io.Copy(os.Stderr, stderr)
+	var stdoutWriter io.Writer = os.Stdout
+	if c.Stdout != nil {
+		stdoutWriter = c.Stdout
+	}
+
+	var stderrWriter io.Writer = os.Stderr
+	if c.Stderr != nil {
+		stderrWriter = c.Stderr
+	}
+
+	go io.Copy(stdoutWriter, stdout)
+	go io.Copy(stderrWriter, stderr)
 
 	cmd.Env = c.Env()
 
@@ -0,0 +1,211 @@
+package logs
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// Stdout is the stream name of a node's standard output
+	Stdout = "stdout"
+
+	// Stderr is the stream name of a node's standard error
+	Stderr = "stderr"
+)
+
+const (
+	// DefaultSize is the number of entries kept by the default store
+	DefaultSize = 10000
+
+	// DefaultBufferSize is the number of entries buffered for each follower
+	DefaultBufferSize = 256
+)
+
+// Entry is a single line of output of a function node
+type Entry struct {
+	// Time is the time the line has been written
+	Time time.Time `json:"time"`
+
+	// Function is the ID of the function the node belongs to
+	Function string `json:"function"`
+
+	// Node is the URN of the node that wrote the line
+	Node string `json:"node"`
+
+	// Invocation is the ID of the event the node executed while writing the
+	// line. It is empty if the node was idle
+	Invocation string `json:"invocation,omitempty"`
+
+	// Stream is the name of the stream the line has been written to
+	Stream string `json:"stream"`
+
+	// Line holds the line without the trailing newline
+	Line string `json:"line"`
+}
+
+// Filter selects log entries. Empty fields match all entries
+type Filter struct {
+	// Function selects entries of the given function
+	Function string
+
+	// Node selects entries of the given node
+	Node string
+
+	// Since selects entries written after the given time
+	Since time.Time
+}
+
+// Match returns true if e is selected by the filter
+func (f Filter) Match(e Entry) bool {
+	if f.Function != "" && f.Function != e.Function {
+		return false
+	}
+
+	if f.Node != "" && f.Node != e.Node {
+		return false
+	}
+
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+
+	return true
+}
+
+// Store keeps the latest log entries of all nodes in a ring buffer.
+// Appending never blocks; entries are dropped for followers that do not
+// keep up
+type Store struct {
+	mu      sync.RWMutex
+	entries []Entry
+	next    int
+	full    bool
+	subs    map[*Subscription]struct{}
+}
+
+// DefaultStore is the store used by the node server and the sigma server
+var DefaultStore = NewStore(DefaultSize)
+
+// NewStore returns a new store keeping up to size entries
+func NewStore(size int) *Store {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Store{
+		entries: make([]Entry, size),
+		subs:    make(map[*Subscription]struct{}),
+	}
+}
+
+// Append adds an entry to the store and passes it to all matching
+// followers. If e.Time is zero, it is set to the current time
+func (s *Store) Append(e Entry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[s.next] = e
+	s.next = (s.next + 1) % len(s.entries)
+	if s.next == 0 {
+		s.full = true
+	}
+
+	for sub := range s.subs {
+		if !sub.filter.Match(e) {
+			continue
+		}
+
+		select {
+		case sub.ch <- e:
+		default:
+			sub.mu.Lock()
+			sub.dropped++
+			sub.mu.Unlock()
+		}
+	}
+}
+
+// Query returns all entries matching f, oldest first
+func (s *Store) Query(f Filter) []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.query(f)
+}
+
+// Follow returns all entries matching f, oldest first, and a subscription
+// receiving matching entries appended afterwards
+func (s *Store) Follow(f Filter, size int) ([]Entry, *Subscription) {
+	sub := &Subscription{
+		store:  s,
+		filter: f,
+		ch:     make(chan Entry, size),
+	}
+	sub.C = sub.ch
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.subs[sub] = struct{}{}
+
+	return s.query(f), sub
+}
+
+// query returns all entries matching f. The caller must hold the store
+// lock
+func (s *Store) query(f Filter) []Entry {
+	var res []Entry
+
+	start, count := 0, s.next
+	if s.full {
+		start, count = s.next, len(s.entries)
+	}
+
+	for i := 0; i < count; i++ {
+		e := s.entries[(start+i)%len(s.entries)]
+		if f.Match(e) {
+			res = append(res, e)
+		}
+	}
+
+	return res
+}
+
+// Subscription receives entries appended to a store
+type Subscription struct {
+	// C receives all matching entries and is closed when the subscription
+	// is closed
+	C <-chan Entry
+
+	store  *Store
+	filter Filter
+	ch     chan Entry
+	once   sync.Once
+
+	mu      sync.Mutex
+	dropped int
+}
+
+// Dropped returns the number of entries dropped because the follower did
+// not keep up
+func (s *Subscription) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.dropped
+}
+
+// Close removes the subscription from the store and closes C
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.store.mu.Lock()
+		delete(s.store.subs, s)
+		s.store.mu.Unlock()
+
+		close(s.ch)
+	})
+}
@@ -0,0 +1,93 @@
+package logs
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func lines(entries []Entry) []string {
+	var res []string
+	for _, e := range entries {
+		res = append(res, e.Line)
+	}
+	return res
+}
+
+func TestStore_Ring(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewStore(3)
+	assert.Empty(s.Query(Filter{}))
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		s.Append(Entry{
+			Time:     now.Add(time.Duration(i) * time.Second),
+			Function: "fn",
+			Node:     fmt.Sprintf("node-%d", i%2),
+			Line:     fmt.Sprintf("%d", i),
+		})
+	}
+
+	assert.Equal([]string{"2", "3", "4"}, lines(s.Query(Filter{})))
+	assert.Equal([]string{"2", "4"}, lines(s.Query(Filter{Node: "node-0"})))
+	assert.Equal([]string{"3", "4"}, lines(s.Query(Filter{Since: now.Add(3 * time.Second)})))
+	assert.Empty(s.Query(Filter{Function: "other"}))
+}
+
+func TestStore_Follow(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewStore(10)
+	s.Append(Entry{Function: "fn", Line: "old"})
+	s.Append(Entry{Function: "other", Line: "ignored"})
+
+	backlog, sub := s.Follow(Filter{Function: "fn"}, 1)
+	assert.Equal([]string{"old"}, lines(backlog))
+
+	s.Append(Entry{Function: "other", Line: "ignored"})
+	s.Append(Entry{Function: "fn", Line: "new"})
+	s.Append(Entry{Function: "fn", Line: "dropped"})
+
+	e := <-sub.C
+	assert.Equal("new", e.Line)
+	assert.False(e.Time.IsZero())
+	assert.Equal(1, sub.Dropped())
+
+	sub.Close()
+	sub.Close()
+
+	_, ok := <-sub.C
+	assert.False(ok)
+}
+
+func TestSource_Writer(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewStore(10)
+	src := NewSource(s, "fn", "node")
+	w := src.Writer(Stderr)
+
+	w.Write([]byte("first\r\nsec"))
+	src.SetInvocation("evt-1")
+	w.Write([]byte("ond\n"))
+	src.ClearInvocation("evt-2")
+	w.Write([]byte("third\n"))
+	src.ClearInvocation("evt-1")
+	w.Write([]byte("idle\npartial"))
+
+	entries := s.Query(Filter{})
+	assert.Equal([]string{"first", "second", "third", "idle"}, lines(entries))
+
+	var invocations []string
+	for _, e := range entries {
+		invocations = append(invocations, e.Invocation)
+		assert.Equal("fn", e.Function)
+		assert.Equal("node", e.Node)
+		assert.Equal(Stderr, e.Stream)
+	}
+	assert.Equal([]string{"", "evt-1", "evt-1", ""}, invocations)
+}
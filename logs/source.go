@@ -0,0 +1,114 @@
+package logs
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxLineLength is the maximum length of a line. Longer lines are split
+const maxLineLength = 64 * 1024
+
+// Source tags the output of a single node with the function, node and
+// current invocation and appends it to a store
+type Source struct {
+	store    *Store
+	function string
+	node     string
+
+	mu         sync.Mutex
+	invocation string
+}
+
+// NewSource returns a new source for the output of node
+func NewSource(store *Store, function, node string) *Source {
+	return &Source{
+		store:    store,
+		function: function,
+		node:     node,
+	}
+}
+
+// SetInvocation sets the ID of the event the node is currently executing.
+// An empty id marks the node as idle
+func (s *Source) SetInvocation(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.invocation = id
+}
+
+// ClearInvocation marks the node as idle if id is the current invocation
+func (s *Source) ClearInvocation(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.invocation == id {
+		s.invocation = ""
+	}
+}
+
+// Append appends a line written to stream. If invocation is empty, the
+// current invocation is used
+func (s *Source) Append(stream, invocation, line string, t time.Time) {
+	if invocation == "" {
+		s.mu.Lock()
+		invocation = s.invocation
+		s.mu.Unlock()
+	}
+
+	s.store.Append(Entry{
+		Time:       t,
+		Function:   s.function,
+		Node:       s.node,
+		Invocation: invocation,
+		Stream:     stream,
+		Line:       line,
+	})
+}
+
+// Writer returns a writer that appends each line written to it as an entry
+// for stream
+func (s *Source) Writer(stream string) io.Writer {
+	return &lineWriter{
+		source: s,
+		stream: stream,
+	}
+}
+
+// lineWriter splits writes into lines
+type lineWriter struct {
+	source *Source
+	stream string
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+		if idx < 0 {
+			if len(w.buf) < maxLineLength {
+				break
+			}
+			idx = maxLineLength
+		}
+
+		line := bytes.TrimSuffix(w.buf[:idx], []byte("\r"))
+		w.source.Append(w.stream, "", string(line), time.Now())
+
+		if idx < len(w.buf) && w.buf[idx] == '\n' {
+			idx++
+		}
+		w.buf = w.buf[idx:]
+	}
+
+	return len(p), nil
+}
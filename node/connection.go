@@ -4,19 +4,34 @@ import (
 	"errors"
 	"io"
 	"sync"
+	"time"
 
 	"golang.org/x/net/context"
 
 	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
 	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/logs"
+)
+
+// DefaultGracePeriod is the default duration a node may stay disconnected
+// before it is marked as unhealthy
+const DefaultGracePeriod = 30 * time.Second
+
+var (
+	// ErrNodeDisconnected is reported as the execution error of events
+	// that have been sent to a node but not acknowledged before it's stream
+	// broke
+	ErrNodeDisconnected = errors.New("node disconnected")
 )
 
 // Conn is the connection to a node instance
 type Conn interface {
-	// Send sends a dispatch event
+	// Send sends a dispatch event. If the node is currently disconnected
+	// the event is queued until the node resumes it's session
 	Send(*sigmaV1.DispatchEvent) error
 
-	// Receive receives an execution result
+	// Receive receives an execution result. It blocks until a result is
+	// available, the connection is closed or the context is cancelled
 	Receive(context.Context) (*sigmaV1.ExecutionResult, error)
 
 	// Connected returns true if the node is currently connected
@@ -26,13 +41,28 @@ type Conn interface {
 	// has been initialized
 	Registered() bool
 
+	// Healthy returns an error if the node missed too many heartbeats or
+	// did not resume it's subscription stream within the grace period
+	Healthy() error
+
+	// Liveness returns the round-trip time of the last heartbeat answered
+	// by the node and the time the node has been seen the last time
+	Liveness() (time.Duration, time.Time)
+
+	// Logs returns the log source capturing the output of the node
+	Logs() *logs.Source
+
+	// Capabilities returns the capabilities negotiated during registration
+	Capabilities() Capabilities
+
 	// Close closes the connection
 	Close() error
 }
 
-type nodeChannel struct {
-	request  chan *sigmaV1.DispatchEvent
-	response chan *sigmaV1.ExecutionResult
+// session is a single subscription stream of a node
+type session struct {
+	// done is closed when the session has been replaced by a new one
+	done chan struct{}
 }
 
 type nodeConn struct {
@@ -40,19 +70,42 @@ type nodeConn struct {
 	URN    string
 	spec   sigma.FunctionSpec
 
-	closed chan struct{}
+	// grace is the duration a node may stay disconnected before it is
+	// reported as unhealthy
+	grace time.Duration
+
+	// redeliver is set if unacknowledged events should be sent again
+	// when a node resumes it's session. Otherwise they are failed as soon
+	// as the stream breaks
+	redeliver bool
+
+	// logs captures the output of the node and tracks the current
+	// invocation
+	logs *logs.Source
+
+	closed   chan struct{}
+	request  chan *sigmaV1.DispatchEvent
+	response chan *sigmaV1.ExecutionResult
 
-	rw         sync.Mutex
-	channel    *nodeChannel
-	registered bool
+	rw           sync.Mutex
+	registered   bool
+	caps         Capabilities
+	session      *session
+	disconnected time.Time
+	inflight     map[string]*sigmaV1.DispatchEvent
+
+	live liveness
 }
 
 func newNodeConn(urn string, secret string, spec sigma.FunctionSpec) *nodeConn {
 	return &nodeConn{
-		secret: secret,
-		URN:    urn,
-		closed: make(chan struct{}),
-		spec:   spec,
+		secret:   secret,
+		URN:      urn,
+		closed:   make(chan struct{}),
+		request:  make(chan *sigmaV1.DispatchEvent, 100),
+		response: make(chan *sigmaV1.ExecutionResult, 100),
+		inflight: make(map[string]*sigmaV1.DispatchEvent),
+		spec:     spec,
 	}
 }
 
@@ -60,7 +113,7 @@ func (n *nodeConn) Connected() bool {
 	n.rw.Lock()
 	defer n.rw.Unlock()
 
-	return n.channel != nil
+	return n.session != nil
 }
 
 func (n *nodeConn) Close() error {
@@ -79,13 +132,12 @@ func (n *nodeConn) Close() error {
 }
 
 func (n *nodeConn) Send(in *sigmaV1.DispatchEvent) error {
-	req, _, err := n.getChannels()
-	if err != nil {
-		return err
+	if !n.Registered() {
+		return errors.New("not yet registered")
 	}
 
 	select {
-	case req <- in:
+	case n.request <- in:
 	case <-n.closed:
 		return io.EOF
 	}
@@ -93,13 +145,12 @@ func (n *nodeConn) Send(in *sigmaV1.DispatchEvent) error {
 }
 
 func (n *nodeConn) Receive(ctx context.Context) (*sigmaV1.ExecutionResult, error) {
-	_, res, err := n.getChannels()
-	if err != nil {
-		return nil, err
+	if !n.Registered() {
+		return nil, errors.New("not yet registered")
 	}
 
 	select {
-	case out := <-res:
+	case out := <-n.response:
 		return out, nil
 	case <-n.closed:
 		return nil, io.EOF
@@ -115,26 +166,43 @@ func (n *nodeConn) Registered() bool {
 	return n.registered
 }
 
-func (n *nodeConn) setRegistered(b bool) {
+func (n *nodeConn) Healthy() error {
+	if err := n.live.healthy(); err != nil {
+		return err
+	}
+
 	n.rw.Lock()
 	defer n.rw.Unlock()
 
-	n.registered = b
+	if n.session == nil && !n.disconnected.IsZero() && time.Since(n.disconnected) > n.grace {
+		return ErrStreamClosed
+	}
+
+	return nil
+}
+
+func (n *nodeConn) Liveness() (time.Duration, time.Time) {
+	return n.live.stats()
 }
 
-func (n *nodeConn) getChannels() (chan *sigmaV1.DispatchEvent, chan *sigmaV1.ExecutionResult, error) {
+func (n *nodeConn) Logs() *logs.Source {
+	return n.logs
+}
+
+func (n *nodeConn) Capabilities() Capabilities {
 	n.rw.Lock()
 	defer n.rw.Unlock()
 
-	if !n.registered {
-		return nil, nil, errors.New("not yet registered")
-	}
+	return n.caps
+}
 
-	if n.channel != nil {
-		return n.channel.request, n.channel.response, nil
-	}
+// register marks the node as registered using the negotiated capabilities
+func (n *nodeConn) register(caps Capabilities) {
+	n.rw.Lock()
+	defer n.rw.Unlock()
 
-	return nil, nil, errors.New("not connected")
+	n.caps = caps
+	n.registered = true
 }
 
 func (n *nodeConn) isClosed() bool {
@@ -146,9 +214,87 @@ func (n *nodeConn) isClosed() bool {
 	}
 }
 
-func (n *nodeConn) setConnected(channel *nodeChannel) {
+// connect starts a new session and replaces the current one, if any. It
+// returns the events that must be re-delivered on the new session
+func (n *nodeConn) connect() (*session, []*sigmaV1.DispatchEvent) {
 	n.rw.Lock()
 	defer n.rw.Unlock()
 
-	n.channel = channel
+	if n.session != nil {
+		close(n.session.done)
+	}
+
+	s := &session{done: make(chan struct{})}
+	n.session = s
+	n.disconnected = time.Time{}
+	n.live.reset()
+
+	var redeliver []*sigmaV1.DispatchEvent
+	for _, evt := range n.inflight {
+		redeliver = append(redeliver, evt)
+	}
+
+	return s, redeliver
+}
+
+// disconnect ends the session s. If s is still the current session, the
+// grace period starts and, unless events are re-delivered, all
+// unacknowledged events are failed
+func (n *nodeConn) disconnect(s *session) {
+	n.rw.Lock()
+
+	if n.session != s {
+		// the session has already been replaced
+		n.rw.Unlock()
+		return
+	}
+
+	n.session = nil
+	n.disconnected = time.Now()
+
+	var failed []string
+	if !n.redeliver {
+		for id := range n.inflight {
+			failed = append(failed, id)
+		}
+		n.inflight = make(map[string]*sigmaV1.DispatchEvent)
+	}
+
+	n.rw.Unlock()
+
+	for _, id := range failed {
+		n.deliver(&sigmaV1.ExecutionResult{
+			Id: id,
+			ExecutionResult: &sigmaV1.ExecutionResult_Error{
+				Error: ErrNodeDisconnected.Error(),
+			},
+		})
+	}
+}
+
+// track marks an event as sent to the node
+func (n *nodeConn) track(evt *sigmaV1.DispatchEvent) {
+	n.rw.Lock()
+	defer n.rw.Unlock()
+
+	n.inflight[evt.GetId()] = evt
+	n.logs.SetInvocation(evt.GetId())
+}
+
+// ack removes the event with the given ID from the set of unacknowledged
+// events
+func (n *nodeConn) ack(id string) {
+	n.rw.Lock()
+	defer n.rw.Unlock()
+
+	delete(n.inflight, id)
+	n.logs.ClearInvocation(id)
+}
+
+// deliver passes an execution result to the receiver of the connection
+func (n *nodeConn) deliver(res *sigmaV1.ExecutionResult) {
+	select {
+	case n.response <- res:
+	case <-n.closed:
+	}
 }
@@ -33,6 +33,13 @@ type Stats struct {
 
 	// MeanExecTime is the mean execution time of the node
 	MeanExecTime time.Duration
+
+	// RTT is the round-trip time of the last heartbeat answered by the node
+	RTT time.Duration
+
+	// LastSeen holds the time the last message has been received from
+	// the node
+	LastSeen time.Time
 }
 
 // ToProtobuf creates the protocol buffer representation of the node state
@@ -41,6 +48,8 @@ func (s Stats) ToProtobuf() *sigmaV1.NodeStatistics {
 	lastInvocation, _ := ptypes.TimestampProto(s.LastInvocation)
 	total := ptypes.DurationProto(s.TotalExecTime)
 	mean := ptypes.DurationProto(s.MeanExecTime)
+	rtt := ptypes.DurationProto(s.RTT)
+	lastSeen, _ := ptypes.TimestampProto(s.LastSeen)
 
 	return &sigmaV1.NodeStatistics{
 		CreatedTime:    created,
@@ -48,6 +57,8 @@ func (s Stats) ToProtobuf() *sigmaV1.NodeStatistics {
 		Invocations:    s.Invocations,
 		TotalExecTime:  total,
 		MeanExecTime:   mean,
+		Rtt:            rtt,
+		LastSeen:       lastSeen,
 	}
 }
 
@@ -56,11 +67,15 @@ func StatsFromProtobuf(s *sigmaV1.NodeStatistics) Stats {
 	last, _ := ptypes.Timestamp(s.GetLastInvocation())
 	total, _ := ptypes.Duration(s.GetTotalExecTime())
 	mean, _ := ptypes.Duration(s.GetMeanExecTime())
+	rtt, _ := ptypes.Duration(s.GetRtt())
+	lastSeen, _ := ptypes.Timestamp(s.GetLastSeen())
 	return Stats{
 		LastInvocation: last,
 		Invocations:    s.GetInvocations(),
 		TotalExecTime:  total,
 		MeanExecTime:   mean,
+		RTT:            rtt,
+		LastSeen:       lastSeen,
 	}
 }
 
@@ -94,6 +109,8 @@ func StateFromProtobuf(s sigmaV1.Node_State) State {
 		return StateRunning
 	case sigmaV1.Node_UNHEALTHY:
 		return StateUnhealthy
+	case sigmaV1.Node_DRAINING:
+		return StateDraining
 	default:
 		return State(strings.ToLower(s.String()))
 	}
@@ -113,8 +130,15 @@ const (
 
 	// StateRunning is set when the node is currently executing
 	StateRunning = State("running")
+
+	// StateDraining is set when the node does not accept new events and
+	// is stopped as soon as all in-flight events have been executed
+	StateDraining = State("draining")
 )
 
+// ErrDraining is returned when an event is dispatched to a draining node
+var ErrDraining = errors.New("node is draining")
+
 // Controller manages a given function node
 type Controller interface {
 	URN() string
@@ -128,9 +152,19 @@ type Controller interface {
 	// Dispatch dispatches an event to the node
 	Dispatch(context.Context, *sigmaV1.DispatchEvent) ([]byte, error)
 
+	// Stream dispatches an event to the node and calls the FrameFunc for
+	// each partial result received before the final result. Chunks passed
+	// to the FrameFunc are not included in the returned result
+	Stream(context.Context, *sigmaV1.DispatchEvent, FrameFunc) ([]byte, error)
+
 	// OnDestroy registers an on-destroy handler
 	OnDestroy(func(Controller))
 
+	// Drain stops accepting new events and waits for in-flight events to
+	// finish before closing the node. If ctx is done before, the node is
+	// closed anyway and the context error is returned
+	Drain(context.Context) error
+
 	// Close closes the connection to the node and stops the instance
 	Close() error
 }
@@ -140,12 +174,20 @@ type controller struct {
 	urn string
 
 	router   Router
+	conn     Conn
 	instance launcher.Instance
 
 	rw        sync.RWMutex
 	state     State
 	stats     Stats
 	onDestroy []func(Controller)
+
+	// inflight is the number of events currently dispatched to the node.
+	// idle is closed once inflight drops to zero while draining
+	inflight int
+	idle     chan struct{}
+	draining bool
+	closed   bool
 }
 
 func (ctrl *controller) OnDestroy(f func(Controller)) {
@@ -164,6 +206,20 @@ func (ctrl *controller) State() State {
 		return StateUnhealthy
 	}
 
+	if err := ctrl.conn.Healthy(); err != nil {
+		return StateUnhealthy
+	}
+
+	if ctrl.draining {
+		return StateDraining
+	}
+
+	// the node is busy once it executes as many events as it can handle
+	// concurrently
+	if ctrl.state == StateActive && ctrl.inflight >= ctrl.conn.Capabilities().Concurrency {
+		return StateRunning
+	}
+
 	return ctrl.state
 }
 
@@ -174,16 +230,31 @@ func (ctrl *controller) URN() string {
 // Dispatch dispatches the given event to the node and returns
 // the execution result
 func (ctrl *controller) Dispatch(ctx context.Context, event *sigmaV1.DispatchEvent) ([]byte, error) {
-	start := time.Now()
+	return ctrl.Stream(ctx, event, nil)
+}
+
+// Stream dispatches the given event to the node, forwards partial results
+// to fn and returns the rest of the execution result
+func (ctrl *controller) Stream(ctx context.Context, event *sigmaV1.DispatchEvent, fn FrameFunc) ([]byte, error) {
+	if limit := ctrl.conn.Capabilities().MaxPayloadSize; limit > 0 && len(event.GetPayload()) > limit {
+		return nil, ErrPayloadTooLarge
+	}
+
+	if err := ctrl.acquire(); err != nil {
+		return nil, err
+	}
+	defer ctrl.release()
 
-	ctrl.setState(StateRunning)
+	start := time.Now()
 
-	res, err := ctrl.router.Dispatch(ctx, event)
+	// a node that did not return a result before ctx is done is still
+	// executing the event. Marking it as unhealthy makes the function
+	// controller replace the node which stops the execution
+	res, err := ctrl.router.Stream(ctx, event, fn)
 	if err != nil {
 		ctrl.setState(StateUnhealthy)
 		return nil, err
 	}
-	ctrl.setState(StateActive)
 
 	execTime := time.Now().Sub(start)
 
@@ -211,7 +282,67 @@ func (ctrl *controller) Stats() Stats {
 	ctrl.rw.RLock()
 	defer ctrl.rw.RUnlock()
 
-	return ctrl.stats
+	stats := ctrl.stats
+	stats.RTT, stats.LastSeen = ctrl.conn.Liveness()
+
+	return stats
+}
+
+// Drain marks the node as draining, waits for all in-flight events to
+// finish and closes the node afterwards
+func (ctrl *controller) Drain(ctx context.Context) error {
+	ctrl.rw.Lock()
+	ctrl.draining = true
+
+	var idle chan struct{}
+	if ctrl.inflight > 0 {
+		if ctrl.idle == nil {
+			ctrl.idle = make(chan struct{})
+		}
+		idle = ctrl.idle
+	}
+	ctrl.rw.Unlock()
+
+	var err error
+	if idle != nil {
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	if closeErr := ctrl.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// acquire registers a new in-flight event. It fails if the node is draining
+func (ctrl *controller) acquire() error {
+	ctrl.rw.Lock()
+	defer ctrl.rw.Unlock()
+
+	if ctrl.draining || ctrl.closed {
+		return ErrDraining
+	}
+
+	ctrl.inflight++
+	return nil
+}
+
+// release removes an in-flight event and wakes up Drain once the node
+// is idle
+func (ctrl *controller) release() {
+	ctrl.rw.Lock()
+	defer ctrl.rw.Unlock()
+
+	ctrl.inflight--
+	if ctrl.inflight == 0 && ctrl.idle != nil {
+		close(ctrl.idle)
+		ctrl.idle = nil
+	}
 }
 
 // Close closes the connection to the node and removes the node instance
@@ -219,6 +350,11 @@ func (ctrl *controller) Close() error {
 	ctrl.rw.Lock()
 	defer ctrl.rw.Unlock()
 
+	if ctrl.closed {
+		return nil
+	}
+	ctrl.closed = true
+
 	for _, fn := range ctrl.onDestroy {
 		fn(ctrl)
 	}
@@ -239,6 +375,7 @@ func CreateController(u string, instance launcher.Instance, conn Conn) Controlle
 	return &controller{
 		urn:      u,
 		router:   NewRouter(conn),
+		conn:     conn,
 		instance: instance,
 		state:    StateActive,
 	}
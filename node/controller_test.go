@@ -0,0 +1,146 @@
+package node
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+type instanceMock struct {
+	mu      sync.Mutex
+	stopped int
+}
+
+func (i *instanceMock) Healthy() error { return nil }
+
+func (i *instanceMock) Stop() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.stopped++
+	return nil
+}
+
+func (i *instanceMock) Stopped() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.stopped
+}
+
+func waitState(t *testing.T, ctrl Controller, state State) {
+	deadline := time.Now().Add(time.Second)
+	for ctrl.State() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("node did not reach state %s", state)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestController_Drain(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := NewNodeServer(WithHeartbeatInterval(0))
+	c := prepareNode(t, srv)
+
+	stream := newStreamMock("urn:node", "secret")
+	defer close(stream.recv)
+	subscribe(srv, stream)
+
+	instance := &instanceMock{}
+	ctrl := CreateController("urn:node", instance, c)
+
+	result := make(chan []byte, 1)
+	go func() {
+		res, _ := ctrl.Dispatch(context.Background(), &sigmaV1.DispatchEvent{})
+		result <- res
+	}()
+
+	event := <-stream.sent
+	waitState(t, ctrl, StateRunning)
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- ctrl.Drain(context.Background())
+	}()
+
+	waitState(t, ctrl, StateDraining)
+	assert.False(ctrl.State().CanSelect())
+
+	_, err := ctrl.Dispatch(context.Background(), &sigmaV1.DispatchEvent{})
+	assert.Equal(ErrDraining, err)
+	assert.Equal(0, instance.Stopped())
+
+	stream.recv <- &sigmaV1.ExecutionResult{
+		Id: event.GetId(),
+		ExecutionResult: &sigmaV1.ExecutionResult_Result{
+			Result: []byte("done"),
+		},
+	}
+
+	assert.Equal([]byte("done"), <-result)
+	assert.NoError(<-drained)
+	assert.Equal(1, instance.Stopped())
+
+	// closing a drained node is a no-op
+	assert.NoError(ctrl.Close())
+	assert.Equal(1, instance.Stopped())
+}
+
+func TestController_DrainTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := NewNodeServer(WithHeartbeatInterval(0))
+	c := prepareNode(t, srv)
+
+	stream := newStreamMock("urn:node", "secret")
+	defer close(stream.recv)
+	subscribe(srv, stream)
+
+	instance := &instanceMock{}
+	ctrl := CreateController("urn:node", instance, c)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := ctrl.Dispatch(context.Background(), &sigmaV1.DispatchEvent{})
+		result <- err
+	}()
+
+	<-stream.sent
+	waitState(t, ctrl, StateRunning)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// the node never answers so it is stopped once the timeout elapsed
+	assert.Equal(context.DeadlineExceeded, ctrl.Drain(ctx))
+	assert.Equal(1, instance.Stopped())
+	assert.Error(<-result)
+}
+
+func TestController_DispatchTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := NewNodeServer(WithHeartbeatInterval(0))
+	c := prepareNode(t, srv)
+
+	stream := newStreamMock("urn:node", "secret")
+	defer close(stream.recv)
+	subscribe(srv, stream)
+
+	ctrl := CreateController("urn:node", &instanceMock{}, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// the node never answers so it must be replaced to stop the execution
+	_, err := ctrl.Dispatch(ctx, &sigmaV1.DispatchEvent{})
+	assert.Equal(context.DeadlineExceeded, err)
+	assert.Equal(StateUnhealthy, ctrl.State())
+}
@@ -4,7 +4,9 @@ import (
 	"time"
 
 	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/certs"
 	"github.com/homebot/sigma/launcher"
+	"github.com/homebot/sigma/logs"
 	uuid "github.com/satori/go.uuid"
 	"golang.org/x/net/context"
 )
@@ -29,12 +31,15 @@ type deployer struct {
 	service          NodeServer
 	launcher         launcher.Launcher
 	advertiseAddress string
+
+	tlsCA     []byte
+	authority *certs.Authority
 }
 
 // NewDeployer creates a new node deployer. The new deployer will
 // setup `svc` to accept the new node and use `launcher` to create
 // a new instance. See `Deploy()` for more information
-func NewDeployer(svc NodeServer, launcher launcher.Launcher, handlerAddress string) Deployer {
+func NewDeployer(svc NodeServer, launcher launcher.Launcher, handlerAddress string, opts ...DeployerOption) Deployer {
 	if svc == nil {
 		panic("NewDeployer(): NodeServer parameter is mandatory")
 	}
@@ -43,11 +48,17 @@ func NewDeployer(svc NodeServer, launcher launcher.Launcher, handlerAddress stri
 		panic("NewDeployer(): Launcher parameter is mandatory")
 	}
 
-	return &deployer{
+	d := &deployer{
 		service:          svc,
 		launcher:         launcher,
 		advertiseAddress: handlerAddress,
 	}
+
+	for _, fn := range opts {
+		fn(d)
+	}
+
+	return d
 }
 
 // Deploy deploys a new node
@@ -61,11 +72,21 @@ func (d *deployer) Deploy(ctx context.Context, u string, spec sigma.FunctionSpec
 		return nil, err
 	}
 
+	tlsConfig, err := d.nodeTLS(u)
+	if err != nil {
+		d.service.Remove(u)
+		return nil, err
+	}
+
 	// Next, instruct the launcher to deploy a new instance
 	instance, err := d.launcher.Create(ctx, spec.Type, launcher.Config{
 		URN:     u,
 		Secret:  secret,
 		Address: d.advertiseAddress,
+		Type:    spec.Type,
+		Stdout:  conn.Logs().Writer(logs.Stdout),
+		Stderr:  conn.Logs().Writer(logs.Stderr),
+		TLS:     tlsConfig,
 	})
 	if err != nil {
 		d.service.Remove(u)
@@ -97,3 +118,30 @@ func (d *deployer) Deploy(ctx context.Context, u string, spec sigma.FunctionSpec
 
 	return ctrl, nil
 }
+
+// nodeTLS returns the TLS credentials for the node with the given URN
+func (d *deployer) nodeTLS(u string) (*launcher.TLSConfig, error) {
+	if d.tlsCA == nil && d.authority == nil {
+		return nil, nil
+	}
+
+	cfg := &launcher.TLSConfig{
+		CA: d.tlsCA,
+	}
+
+	// certificates are not rotated while the node is running so they are
+	// issued for the lifetime of the authority. They are only accepted
+	// together with the URN and secret of a deployed node and become
+	// useless once the node is removed
+	if d.authority != nil {
+		cert, key, err := d.authority.Issue(u, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.Cert = cert
+		cfg.Key = key
+	}
+
+	return cfg, nil
+}
@@ -3,15 +3,23 @@ package node
 import (
 	"errors"
 	"sync"
+	"time"
 
 	"google.golang.org/grpc/metadata"
 
 	"github.com/golang/glog"
+	"github.com/golang/protobuf/ptypes"
 	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
 	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/certs"
+	"github.com/homebot/sigma/logs"
 	"golang.org/x/net/context"
 )
 
+// ErrPeerCertificate is returned if peer verification is enabled and a node
+// does not present a client certificate issued for it's URN
+var ErrPeerCertificate = errors.New("missing or invalid client certificate")
+
 // NodeServer handles communication with function nodes
 // TODO(ppacher): find a better name
 type NodeServer interface {
@@ -26,13 +34,33 @@ type NodeServer interface {
 type nodeServer struct {
 	rw    sync.RWMutex
 	conns map[string]*nodeConn
+
+	heartbeatInterval  time.Duration
+	heartbeatThreshold int
+
+	gracePeriod time.Duration
+	redeliver   bool
+
+	logs *logs.Store
+
+	verifyPeer bool
 }
 
 // NewNodeServer returns a new handler service
-func NewNodeServer() NodeServer {
-	return &nodeServer{
-		conns: make(map[string]*nodeConn),
+func NewNodeServer(opts ...ServerOption) NodeServer {
+	h := &nodeServer{
+		conns:              make(map[string]*nodeConn),
+		heartbeatInterval:  DefaultHeartbeatInterval,
+		heartbeatThreshold: DefaultHeartbeatThreshold,
+		gracePeriod:        DefaultGracePeriod,
+		logs:               logs.DefaultStore,
+	}
+
+	for _, fn := range opts {
+		fn(h)
 	}
+
+	return h
 }
 
 // Register implements sigma.NodeHandlerServer
@@ -42,11 +70,20 @@ func (h *nodeServer) Register(ctx context.Context, in *sigmaV1.NodeRegistrationR
 		return nil, err
 	}
 
+	if err := h.checkPeer(ctx, urn); err != nil {
+		return nil, err
+	}
+
 	typ := in.GetNodeType()
 	if typ == "" {
 		return nil, errors.New("missing node type")
 	}
 
+	caps, err := h.negotiate(in.GetProtocolVersion(), in.GetCapabilities())
+	if err != nil {
+		return nil, err
+	}
+
 	conn, err := h.getConnection(urn, secret)
 	if err != nil {
 		return nil, err
@@ -60,12 +97,26 @@ func (h *nodeServer) Register(ctx context.Context, in *sigmaV1.NodeRegistrationR
 		return nil, errors.New("node marked for shutdown")
 	}
 
-	conn.setRegistered(true)
+	if conn.spec.Type != "" && conn.spec.Type != typ {
+		return nil, ErrNodeTypeMismatch
+	}
+
+	conn.register(caps)
+
+	// nodes that do not answer heartbeats should not expect them
+	interval := h.heartbeatInterval
+	if !caps.Heartbeats {
+		interval = 0
+	}
 
 	return &sigmaV1.NodeRegistrationResponse{
-		Urn:        in.GetUrn(),
-		Content:    []byte(conn.spec.Content),
-		Parameters: conn.spec.Parameteres.ToProto(),
+		Urn:                in.GetUrn(),
+		Content:            []byte(conn.spec.Content),
+		Parameters:         conn.spec.Parameteres.ToProto(),
+		HeartbeatInterval:  ptypes.DurationProto(interval),
+		HeartbeatThreshold: int32(h.heartbeatThreshold),
+		ProtocolVersion:    ProtocolVersion,
+		Capabilities:       caps.ToProtobuf(),
 	}, nil
 }
 
@@ -76,6 +127,10 @@ func (h *nodeServer) Subscribe(stream sigmaV1.NodeHandler_SubscribeServer) error
 		return err
 	}
 
+	if err := h.checkPeer(stream.Context(), urn); err != nil {
+		return err
+	}
+
 	conn, err := h.getConnection(urn, secret)
 	if err != nil {
 		return err
@@ -85,17 +140,16 @@ func (h *nodeServer) Subscribe(stream sigmaV1.NodeHandler_SubscribeServer) error
 		return errors.New("connection not registered")
 	}
 
-	if conn.Connected() {
-		return errors.New("connection already established")
+	if conn.isClosed() {
+		return errors.New("node marked for shutdown")
 	}
 
-	channel := &nodeChannel{
-		request:  make(chan *sigmaV1.DispatchEvent, 100),
-		response: make(chan *sigmaV1.ExecutionResult, 100),
-	}
+	// a node may resume it's session at any time. If the previous stream
+	// is still considered established it is replaced by this one
+	sess, redeliver := conn.connect()
+	defer conn.disconnect(sess)
 
-	conn.setConnected(channel)
-	defer conn.setConnected(nil)
+	caps := conn.Capabilities()
 
 	ch := make(chan struct{})
 
@@ -112,23 +166,87 @@ func (h *nodeServer) Subscribe(stream sigmaV1.NodeHandler_SubscribeServer) error
 				return
 			}
 
-			channel.response <- msg
+			conn.live.seen(msg.GetId(), time.Now())
+
+			if IsHeartbeat(msg.GetId()) {
+				continue
+			}
+
+			// log records are tagged with the invocation they belong to
+			// and never passed to the router
+			if rec, ok := msg.GetExecutionResult().(*sigmaV1.ExecutionResult_Log); ok {
+				t, err := ptypes.Timestamp(rec.Log.GetTime())
+				if err != nil {
+					t = time.Now()
+				}
+
+				conn.logs.Append(rec.Log.GetStream(), msg.GetId(), rec.Log.GetLine(), t)
+				continue
+			}
+
+			// partial results are only accepted from nodes that announced
+			// streaming support
+			if !IsFinal(msg) && !caps.Streaming {
+				glog.Warning(urn, " sent a partial result without streaming support")
+				continue
+			}
+
+			// an event is acknowledged by it's final result frame
+			if IsFinal(msg) {
+				conn.ack(msg.GetId())
+			}
+			conn.deliver(msg)
 		}
 	}()
 
-	for {
+	for _, req := range redeliver {
+		if err := stream.Send(req); err != nil {
+			glog.Error(urn, " connection failed ", err)
+			return err
+		}
+	}
+
+	// heartbeats are disabled if no ticker is started. Receiving from a nil
+	// channel blocks forever
+	var heartbeat <-chan time.Time
+	if h.heartbeatInterval > 0 && caps.Heartbeats {
+		ticker := time.NewTicker(h.heartbeatInterval)
+		defer ticker.Stop()
+
+		heartbeat = ticker.C
+	}
+
+	for seq := 1; ; {
 		select {
-		case req, ok := <-channel.request:
-			if !ok {
-				return errors.New("request channel terminated")
-			}
+		case req := <-conn.request:
+			conn.track(req)
 
 			if err := stream.Send(req); err != nil {
 				glog.Error(urn, " connection failed ", err)
 				return err
 			}
+		case now := <-heartbeat:
+			id := heartbeatID(seq)
+			seq++
+
+			if missed := conn.live.ping(id, now); missed >= h.heartbeatThreshold {
+				glog.Error(urn, " missed ", missed, " heartbeats")
+				conn.live.fail(ErrHeartbeatTimeout)
+				return ErrHeartbeatTimeout
+			}
+
+			if err := stream.Send(&sigmaV1.DispatchEvent{
+				Id:   id,
+				Type: HeartbeatEventType,
+				Urn:  urn,
+			}); err != nil {
+				glog.Error(urn, " connection failed ", err)
+				return err
+			}
 		case <-ch:
 			return errors.New("internal server error")
+		case <-sess.done:
+			return errors.New("session resumed on another stream")
 		case <-conn.closed:
 			return errors.New("closed")
 		}
@@ -137,6 +255,9 @@ func (h *nodeServer) Subscribe(stream sigmaV1.NodeHandler_SubscribeServer) error
 
 func (h *nodeServer) Prepare(urn string, secret string, spec sigma.FunctionSpec) (Conn, error) {
 	node := newNodeConn(urn, secret, spec)
+	node.grace = h.gracePeriod
+	node.redeliver = h.redeliver
+	node.logs = logs.NewSource(h.logs, spec.ID, urn)
 
 	return node, h.addPendingConn(node)
 }
@@ -187,6 +308,20 @@ func (h *nodeServer) getConnection(urn string, secret string) (*nodeConn, error)
 	return c, nil
 }
 
+// checkPeer ensures the node authenticated with a client certificate issued
+// for urn if peer verification is enabled
+func (h *nodeServer) checkPeer(ctx context.Context, urn string) error {
+	if !h.verifyPeer {
+		return nil
+	}
+
+	if cn, ok := certs.PeerCommonName(ctx); !ok || cn != urn {
+		return ErrPeerCertificate
+	}
+
+	return nil
+}
+
 func getAuth(ctx context.Context) (string, string, error) {
 	md, ok := metadata.FromIncomingContext(ctx)
 
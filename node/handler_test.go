@@ -0,0 +1,256 @@
+package node
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/certs"
+	"github.com/homebot/sigma/logs"
+	"github.com/stretchr/testify/assert"
+)
+
+// subscribe starts a new subscription for the node and returns a channel
+// receiving the result of Subscribe
+func subscribe(srv NodeServer, stream *streamMock) chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Subscribe(stream)
+	}()
+	return done
+}
+
+func prepareNode(t *testing.T, srv NodeServer) Conn {
+	c, err := srv.Prepare("urn:node", "secret", sigma.FunctionSpec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := newStreamMock("urn:node", "secret").Context()
+	if _, err := srv.Register(ctx, &sigmaV1.NodeRegistrationRequest{NodeType: "test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func waitDisconnected(t *testing.T, c Conn) {
+	deadline := time.Now().Add(time.Second)
+	for c.Connected() {
+		if time.Now().After(deadline) {
+			t.Fatal("node still connected")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestNodeServer_ResumeFailsUnacknowledged(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := NewNodeServer(WithHeartbeatInterval(0))
+	c := prepareNode(t, srv)
+
+	first := newStreamMock("urn:node", "secret")
+	done := subscribe(srv, first)
+
+	assert.NoError(c.Send(&sigmaV1.DispatchEvent{Id: "1"}))
+	assert.Equal("1", (<-first.sent).GetId())
+
+	// the stream breaks before the node answered
+	close(first.recv)
+	assert.Error(<-done)
+	waitDisconnected(t, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	res, err := c.Receive(ctx)
+	assert.NoError(err)
+	assert.Equal("1", res.GetId())
+	assert.Equal(ErrNodeDisconnected.Error(), res.GetError())
+
+	// events sent while disconnected are queued for the next session
+	assert.NoError(c.Healthy())
+	assert.NoError(c.Send(&sigmaV1.DispatchEvent{Id: "2"}))
+
+	second := newStreamMock("urn:node", "secret")
+	defer close(second.recv)
+	subscribe(srv, second)
+
+	assert.Equal("2", (<-second.sent).GetId())
+	second.recv <- &sigmaV1.ExecutionResult{Id: "2"}
+
+	res, err = c.Receive(ctx)
+	assert.NoError(err)
+	assert.Equal("2", res.GetId())
+	assert.True(c.Connected())
+}
+
+func TestNodeServer_ResumeRedelivers(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := NewNodeServer(WithHeartbeatInterval(0), WithRedelivery(true))
+	c := prepareNode(t, srv)
+
+	first := newStreamMock("urn:node", "secret")
+	done := subscribe(srv, first)
+
+	assert.NoError(c.Send(&sigmaV1.DispatchEvent{Id: "1"}))
+	assert.Equal("1", (<-first.sent).GetId())
+
+	// a new stream replaces the current session
+	second := newStreamMock("urn:node", "secret")
+	defer close(second.recv)
+	subscribe(srv, second)
+
+	select {
+	case err := <-done:
+		assert.Error(err)
+	case <-time.After(time.Second):
+		t.Fatal("first session has not been replaced")
+	}
+	close(first.recv)
+
+	assert.Equal("1", (<-second.sent).GetId())
+	second.recv <- &sigmaV1.ExecutionResult{Id: "1"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	res, err := c.Receive(ctx)
+	assert.NoError(err)
+	assert.Equal("1", res.GetId())
+	assert.Empty(res.GetError())
+}
+
+func TestNodeServer_GracePeriod(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := NewNodeServer(WithHeartbeatInterval(0), WithGracePeriod(10*time.Millisecond))
+	c := prepareNode(t, srv)
+
+	stream := newStreamMock("urn:node", "secret")
+	done := subscribe(srv, stream)
+
+	close(stream.recv)
+	<-done
+	waitDisconnected(t, c)
+
+	assert.NoError(c.Healthy())
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(ErrStreamClosed, c.Healthy())
+}
+
+func TestNodeServer_Logs(t *testing.T) {
+	assert := assert.New(t)
+
+	store := logs.NewStore(10)
+	srv := NewNodeServer(WithHeartbeatInterval(0), WithLogStore(store))
+
+	c, err := srv.Prepare("urn:node", "secret", sigma.FunctionSpec{ID: "fn"})
+	if !assert.NoError(err) {
+		return
+	}
+
+	stream := newStreamMock("urn:node", "secret")
+	defer close(stream.recv)
+
+	if _, err := srv.Register(stream.Context(), &sigmaV1.NodeRegistrationRequest{NodeType: "test"}); !assert.NoError(err) {
+		return
+	}
+	subscribe(srv, stream)
+
+	assert.NoError(c.Send(&sigmaV1.DispatchEvent{Id: "evt-1"}))
+	<-stream.sent
+
+	// output captured by the launcher is tagged with the current invocation
+	c.Logs().Writer(logs.Stdout).Write([]byte("captured\n"))
+
+	stream.recv <- &sigmaV1.ExecutionResult{
+		Id: "evt-1",
+		ExecutionResult: &sigmaV1.ExecutionResult_Log{
+			Log: &sigmaV1.LogRecord{Stream: logs.Stderr, Line: "forwarded"},
+		},
+	}
+	stream.recv <- &sigmaV1.ExecutionResult{
+		Id:              "evt-1",
+		ExecutionResult: &sigmaV1.ExecutionResult_Result{Result: []byte("ok")},
+	}
+
+	// log records are never passed to the receiver
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	res, err := c.Receive(ctx)
+	assert.NoError(err)
+	assert.Equal("ok", string(res.GetResult()))
+
+	c.Logs().Writer(logs.Stdout).Write([]byte("idle\n"))
+
+	entries := store.Query(logs.Filter{Function: "fn", Node: "urn:node"})
+	if assert.Len(entries, 3) {
+		assert.Equal("captured", entries[0].Line)
+		assert.Equal("evt-1", entries[0].Invocation)
+		assert.Equal("forwarded", entries[1].Line)
+		assert.Equal(logs.Stderr, entries[1].Stream)
+		assert.Equal("evt-1", entries[1].Invocation)
+		assert.Equal("", entries[2].Invocation)
+	}
+}
+
+// withPeerCertificate returns a context carrying a verified TLS client
+// certificate issued for commonName
+func withPeerCertificate(t *testing.T, ctx context.Context, commonName string) context.Context {
+	ca, err := certs.NewSelfSignedAuthority("sigma", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM, _, err := ca.Issue(commonName, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return peer.NewContext(ctx, &peer.Peer{
+		AuthInfo: credentials.TLSInfo{
+			State: tls.ConnectionState{
+				VerifiedChains: [][]*x509.Certificate{{cert}},
+			},
+		},
+	})
+}
+
+func TestNodeServer_PeerVerification(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := NewNodeServer(WithHeartbeatInterval(0), WithPeerVerification(true))
+	_, err := srv.Prepare("urn:node", "secret", sigma.FunctionSpec{})
+	assert.NoError(err)
+
+	req := &sigmaV1.NodeRegistrationRequest{NodeType: "test"}
+	ctx := newStreamMock("urn:node", "secret").Context()
+
+	// the secret alone is not sufficient
+	_, err = srv.Register(ctx, req)
+	assert.Equal(ErrPeerCertificate, err)
+
+	// the certificate must be issued for the node
+	_, err = srv.Register(withPeerCertificate(t, ctx, "urn:other"), req)
+	assert.Equal(ErrPeerCertificate, err)
+
+	_, err = srv.Register(withPeerCertificate(t, ctx, "urn:node"), req)
+	assert.NoError(err)
+}
@@ -0,0 +1,129 @@
+package node
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// HeartbeatEventType is the type of dispatch events sent as heartbeats
+	// to a node. Nodes must answer a heartbeat with an execution result
+	// carrying the same ID
+	HeartbeatEventType = "sigma.heartbeat"
+
+	// HeartbeatPrefix is the prefix of all heartbeat IDs. Execution results
+	// with this prefix are never routed to a dispatcher and nodes may use it
+	// to send heartbeats on their own
+	HeartbeatPrefix = "heartbeat:"
+
+	// DefaultHeartbeatInterval is the default interval heartbeats are sent
+	// to nodes
+	DefaultHeartbeatInterval = 10 * time.Second
+
+	// DefaultHeartbeatThreshold is the default number of consecutive
+	// heartbeats a node may miss before it is marked as unhealthy
+	DefaultHeartbeatThreshold = 3
+)
+
+var (
+	// ErrHeartbeatTimeout is returned from Conn.Healthy() if the node missed
+	// too many heartbeats
+	ErrHeartbeatTimeout = errors.New("node missed heartbeats")
+
+	// ErrStreamClosed is returned from Conn.Healthy() if the node did not
+	// resume it's subscription stream within the grace period
+	ErrStreamClosed = errors.New("node stream closed")
+)
+
+// IsHeartbeat returns true if id is the ID of a heartbeat
+func IsHeartbeat(id string) bool {
+	return strings.HasPrefix(id, HeartbeatPrefix)
+}
+
+func heartbeatID(seq int) string {
+	return HeartbeatPrefix + strconv.Itoa(seq)
+}
+
+// liveness tracks heartbeats of a single node connection
+type liveness struct {
+	mu sync.Mutex
+
+	// pending is the ID of the last heartbeat sent and sent the time it has
+	// been sent at. pending is cleared as soon as the heartbeat is answered
+	pending string
+	sent    time.Time
+
+	lastSeen time.Time
+	rtt      time.Duration
+	missed   int
+	err      error
+}
+
+// ping records a new heartbeat and returns the number of consecutive
+// heartbeats the node missed so far. A heartbeat counts as missed if
+// nothing has been received from the node since it has been sent
+func (l *liveness) ping(id string, now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.pending != "" && l.lastSeen.Before(l.sent) {
+		l.missed++
+	} else {
+		l.missed = 0
+	}
+
+	l.pending = id
+	l.sent = now
+
+	return l.missed
+}
+
+// seen records a message received from the node. If id answers the
+// pending heartbeat the round-trip time is updated
+func (l *liveness) seen(id string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lastSeen = now
+
+	if id != "" && id == l.pending {
+		l.rtt = now.Sub(l.sent)
+		l.pending = ""
+	}
+}
+
+// reset resets heartbeat tracking for a new session
+func (l *liveness) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pending = ""
+	l.missed = 0
+}
+
+// fail marks the connection as failed. Only the first error is kept
+func (l *liveness) fail(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err == nil {
+		l.err = err
+	}
+}
+
+func (l *liveness) healthy() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.err
+}
+
+func (l *liveness) stats() (time.Duration, time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.rtt, l.lastSeen
+}
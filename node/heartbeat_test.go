@@ -0,0 +1,140 @@
+package node
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
+	"github.com/homebot/sigma"
+	"github.com/stretchr/testify/assert"
+)
+
+type streamMock struct {
+	grpc.ServerStream
+
+	ctx  context.Context
+	sent chan *sigmaV1.DispatchEvent
+	recv chan *sigmaV1.ExecutionResult
+}
+
+func newStreamMock(urn, secret string) *streamMock {
+	md := metadata.Pairs("node-urn", urn, "node-secret", secret)
+
+	return &streamMock{
+		ctx:  metadata.NewIncomingContext(context.Background(), md),
+		sent: make(chan *sigmaV1.DispatchEvent, 100),
+		recv: make(chan *sigmaV1.ExecutionResult),
+	}
+}
+
+func (s *streamMock) Context() context.Context { return s.ctx }
+
+func (s *streamMock) Send(in *sigmaV1.DispatchEvent) error {
+	s.sent <- in
+	return nil
+}
+
+func (s *streamMock) Recv() (*sigmaV1.ExecutionResult, error) {
+	msg, ok := <-s.recv
+	if !ok {
+		return nil, io.EOF
+	}
+	return msg, nil
+}
+
+func TestLiveness(t *testing.T) {
+	assert := assert.New(t)
+
+	var l liveness
+	now := time.Now()
+
+	assert.Equal(0, l.ping("heartbeat:1", now))
+
+	l.seen("heartbeat:1", now.Add(5*time.Millisecond))
+	rtt, last := l.stats()
+	assert.Equal(5*time.Millisecond, rtt)
+	assert.Equal(now.Add(5*time.Millisecond), last)
+
+	assert.Equal(0, l.ping("heartbeat:2", now.Add(time.Second)))
+	assert.Equal(1, l.ping("heartbeat:3", now.Add(2*time.Second)))
+
+	// any message proves the node is alive but only the pending heartbeat
+	// updates the round-trip time
+	l.seen("result", now.Add(2*time.Second+time.Millisecond))
+	assert.Equal(0, l.ping("heartbeat:4", now.Add(3*time.Second)))
+	rtt, _ = l.stats()
+	assert.Equal(5*time.Millisecond, rtt)
+
+	assert.NoError(l.healthy())
+	l.fail(ErrHeartbeatTimeout)
+	l.fail(ErrStreamClosed)
+	assert.Equal(ErrHeartbeatTimeout, l.healthy())
+}
+
+func TestNodeServer_Heartbeat(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := NewNodeServer(
+		WithHeartbeatInterval(5*time.Millisecond),
+		WithHeartbeatThreshold(2),
+	).(*nodeServer)
+
+	c, err := srv.Prepare("urn:node", "secret", sigma.FunctionSpec{})
+	if !assert.NoError(err) {
+		return
+	}
+
+	stream := newStreamMock("urn:node", "secret")
+	defer close(stream.recv)
+
+	res, err := srv.Register(stream.Context(), &sigmaV1.NodeRegistrationRequest{
+		NodeType:        "test",
+		ProtocolVersion: ProtocolVersion,
+		Capabilities:    Capabilities{Heartbeats: true}.ToProtobuf(),
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(int32(2), res.GetHeartbeatThreshold())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Subscribe(stream)
+	}()
+
+	// answer a few heartbeats
+	for i := 0; i < 3; i++ {
+		msg := <-stream.sent
+		assert.Equal(HeartbeatEventType, msg.GetType())
+		assert.True(IsHeartbeat(msg.GetId()))
+
+		stream.recv <- &sigmaV1.ExecutionResult{Id: msg.GetId()}
+	}
+
+	// heartbeats are never passed to the router
+	stream.recv <- &sigmaV1.ExecutionResult{Id: "result"}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg, err := c.Receive(ctx)
+	assert.NoError(err)
+	assert.Equal("result", msg.GetId())
+
+	assert.NoError(c.Healthy())
+	_, last := c.Liveness()
+	assert.False(last.IsZero())
+
+	// stop answering heartbeats
+	select {
+	case err := <-done:
+		assert.Equal(ErrHeartbeatTimeout, err)
+	case <-time.After(time.Second):
+		t.Fatal("missed heartbeats have not been detected")
+	}
+
+	assert.Equal(ErrHeartbeatTimeout, c.Healthy())
+}
@@ -0,0 +1,78 @@
+package node
+
+import (
+	"time"
+
+	"github.com/homebot/sigma/certs"
+	"github.com/homebot/sigma/logs"
+)
+
+// ServerOption configures a node server
+type ServerOption func(*nodeServer)
+
+// WithHeartbeatInterval configures the interval heartbeats are sent to
+// connected nodes. An interval of zero disables heartbeats
+func WithHeartbeatInterval(d time.Duration) ServerOption {
+	return func(h *nodeServer) {
+		h.heartbeatInterval = d
+	}
+}
+
+// WithHeartbeatThreshold configures the number of consecutive heartbeats
+// a node may miss before it is marked as unhealthy
+func WithHeartbeatThreshold(n int) ServerOption {
+	return func(h *nodeServer) {
+		if n < 1 {
+			n = 1
+		}
+		h.heartbeatThreshold = n
+	}
+}
+
+// WithGracePeriod configures how long a node may stay disconnected before
+// it is marked as unhealthy. Events dispatched in the meantime are queued
+// until the node resumes it's session
+func WithGracePeriod(d time.Duration) ServerOption {
+	return func(h *nodeServer) {
+		h.gracePeriod = d
+	}
+}
+
+// WithRedelivery configures whether events that have not been acknowledged
+// before a stream broke are sent again when the node resumes it's session.
+// If disabled, those events fail with ErrNodeDisconnected as soon as the
+// stream breaks
+func WithRedelivery(enabled bool) ServerOption {
+	return func(h *nodeServer) {
+		h.redeliver = enabled
+	}
+}
+
+// WithLogStore configures the store the output of nodes is captured in.
+// Defaults to logs.DefaultStore
+func WithLogStore(store *logs.Store) ServerOption {
+	return func(h *nodeServer) {
+		h.logs = store
+	}
+}
+
+// WithPeerVerification configures whether nodes must authenticate with a
+// TLS client certificate issued for their URN in addition to their secret
+func WithPeerVerification(enabled bool) ServerOption {
+	return func(h *nodeServer) {
+		h.verifyPeer = enabled
+	}
+}
+
+// DeployerOption configures a node deployer
+type DeployerOption func(*deployer)
+
+// WithNodeTLS configures the credentials passed to launched nodes. ca holds
+// the PEM encoded authority used to verify the node handler server. If
+// authority is set, a client certificate is issued for each node
+func WithNodeTLS(ca []byte, authority *certs.Authority) DeployerOption {
+	return func(d *deployer) {
+		d.tlsCA = ca
+		d.authority = authority
+	}
+}
@@ -0,0 +1,100 @@
+package node
+
+import (
+	"errors"
+
+	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
+)
+
+// ProtocolVersion is the version of the node protocol spoken by the node
+// server. Nodes announcing a newer version are rejected
+const ProtocolVersion = 1
+
+var (
+	// ErrUnsupportedProtocol is returned from Register if a node announces
+	// a protocol version the server does not support
+	ErrUnsupportedProtocol = errors.New("unsupported node protocol version")
+
+	// ErrNodeTypeMismatch is returned from Register if the type of the
+	// node does not match the type of the function it has been launched for
+	ErrNodeTypeMismatch = errors.New("node type does not match function type")
+
+	// ErrPayloadTooLarge is returned when an event is dispatched to a node
+	// that does not accept payloads of that size
+	ErrPayloadTooLarge = errors.New("event payload exceeds the maximum payload size of the node")
+)
+
+// Capabilities describes the optional protocol features supported by
+// a node
+type Capabilities struct {
+	// Streaming is set if the node reports partial results. Partial results
+	// of other nodes are dropped
+	Streaming bool
+
+	// Concurrency is the number of events the node executes at the same
+	// time. The node stays selectable until that many events are in-flight
+	Concurrency int
+
+	// Heartbeats is set if the node answers heartbeats. No heartbeats are
+	// sent to other nodes
+	Heartbeats bool
+
+	// MaxPayloadSize is the maximum size of event payloads in bytes the node
+	// accepts. Zero means unlimited
+	MaxPayloadSize int
+}
+
+// LegacyCapabilities are assumed for nodes that do not announce a protocol
+// version. They match the behaviour of the server before capabilities
+// have been negotiated. Legacy nodes do not know heartbeats and would be
+// marked as unhealthy for not answering them
+var LegacyCapabilities = Capabilities{
+	Streaming:   true,
+	Concurrency: 1,
+	Heartbeats:  false,
+}
+
+// ToProtobuf converts the capabilities to their protocol buffer
+// representation
+func (c Capabilities) ToProtobuf() *sigmaV1.NodeCapabilities {
+	return &sigmaV1.NodeCapabilities{
+		Streaming:      c.Streaming,
+		Concurrency:    int32(c.Concurrency),
+		Heartbeats:     c.Heartbeats,
+		MaxPayloadSize: int64(c.MaxPayloadSize),
+	}
+}
+
+// CapabilitiesFromProtobuf creates Capabilities from their protocol buffer
+// representation
+func CapabilitiesFromProtobuf(c *sigmaV1.NodeCapabilities) Capabilities {
+	return Capabilities{
+		Streaming:      c.GetStreaming(),
+		Concurrency:    int(c.GetConcurrency()),
+		Heartbeats:     c.GetHeartbeats(),
+		MaxPayloadSize: int(c.GetMaxPayloadSize()),
+	}
+}
+
+// negotiate returns the capabilities used for a node announcing the given
+// protocol version and capabilities
+func (h *nodeServer) negotiate(version int32, caps *sigmaV1.NodeCapabilities) (Capabilities, error) {
+	if version < 0 || version > ProtocolVersion {
+		return Capabilities{}, ErrUnsupportedProtocol
+	}
+
+	c := LegacyCapabilities
+	if version > 0 {
+		c = CapabilitiesFromProtobuf(caps)
+	}
+
+	if c.Concurrency < 1 {
+		c.Concurrency = 1
+	}
+
+	if h.heartbeatInterval <= 0 {
+		c.Heartbeats = false
+	}
+
+	return c, nil
+}
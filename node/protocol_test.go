@@ -0,0 +1,125 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"golang.org/x/net/context"
+
+	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
+	"github.com/homebot/sigma"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeServer_RegisterRejects(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := NewNodeServer(WithHeartbeatInterval(0))
+	_, err := srv.Prepare("urn:node", "secret", sigma.FunctionSpec{Type: "js"})
+	assert.NoError(err)
+
+	ctx := newStreamMock("urn:node", "secret").Context()
+
+	_, err = srv.Register(ctx, &sigmaV1.NodeRegistrationRequest{
+		NodeType:        "js",
+		ProtocolVersion: ProtocolVersion + 1,
+	})
+	assert.Equal(ErrUnsupportedProtocol, err)
+
+	_, err = srv.Register(ctx, &sigmaV1.NodeRegistrationRequest{
+		NodeType:        "python",
+		ProtocolVersion: ProtocolVersion,
+	})
+	assert.Equal(ErrNodeTypeMismatch, err)
+
+	_, err = srv.Register(ctx, &sigmaV1.NodeRegistrationRequest{
+		NodeType:        "js",
+		ProtocolVersion: ProtocolVersion,
+	})
+	assert.NoError(err)
+}
+
+func TestNodeServer_Negotiate(t *testing.T) {
+	assert := assert.New(t)
+
+	// heartbeats are disabled on the server so they are not negotiated
+	srv := NewNodeServer(WithHeartbeatInterval(0))
+	c, err := srv.Prepare("urn:node", "secret", sigma.FunctionSpec{})
+	assert.NoError(err)
+
+	res, err := srv.Register(newStreamMock("urn:node", "secret").Context(), &sigmaV1.NodeRegistrationRequest{
+		NodeType:        "test",
+		ProtocolVersion: ProtocolVersion,
+		Capabilities: Capabilities{
+			Concurrency:    2,
+			Heartbeats:     true,
+			MaxPayloadSize: 4,
+		}.ToProtobuf(),
+	})
+	assert.NoError(err)
+
+	interval, _ := ptypes.Duration(res.GetHeartbeatInterval())
+	assert.Zero(interval)
+	assert.Equal(int32(ProtocolVersion), res.GetProtocolVersion())
+	assert.Equal(Capabilities{Concurrency: 2, MaxPayloadSize: 4}, CapabilitiesFromProtobuf(res.GetCapabilities()))
+	assert.Equal(Capabilities{Concurrency: 2, MaxPayloadSize: 4}, c.Capabilities())
+
+	stream := newStreamMock("urn:node", "secret")
+	defer close(stream.recv)
+	subscribe(srv, stream)
+
+	ctrl := CreateController("urn:node", &instanceMock{}, c)
+
+	_, err = ctrl.Dispatch(context.Background(), &sigmaV1.DispatchEvent{Payload: []byte("too large")})
+	assert.Equal(ErrPayloadTooLarge, err)
+
+	result := make(chan []byte, 1)
+	go func() {
+		res, _ := ctrl.Dispatch(context.Background(), &sigmaV1.DispatchEvent{})
+		result <- res
+	}()
+	first := <-stream.sent
+
+	// the node can still execute another event
+	assert.Equal(StateActive, ctrl.State())
+
+	go ctrl.Dispatch(context.Background(), &sigmaV1.DispatchEvent{})
+	second := <-stream.sent
+	waitState(t, ctrl, StateRunning)
+
+	// partial results are dropped as the node did not announce streaming
+	stream.recv <- &sigmaV1.ExecutionResult{
+		Id:              first.GetId(),
+		ExecutionResult: &sigmaV1.ExecutionResult_Chunk{Chunk: []byte("partial")},
+	}
+
+	for _, evt := range []*sigmaV1.DispatchEvent{first, second} {
+		stream.recv <- &sigmaV1.ExecutionResult{
+			Id:              evt.GetId(),
+			ExecutionResult: &sigmaV1.ExecutionResult_Result{Result: []byte("ok")},
+		}
+	}
+
+	assert.Equal([]byte("ok"), <-result)
+	waitState(t, ctrl, StateActive)
+}
+
+func TestNodeServer_LegacyNode(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := NewNodeServer(WithHeartbeatInterval(time.Millisecond), WithHeartbeatThreshold(2))
+	c := prepareNode(t, srv)
+
+	assert.Equal(LegacyCapabilities, c.Capabilities())
+	assert.False(c.Capabilities().Heartbeats)
+
+	stream := newStreamMock("urn:node", "secret")
+	defer close(stream.recv)
+	subscribe(srv, stream)
+
+	// legacy nodes do not answer heartbeats so none must be sent
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(c.Healthy())
+	assert.Len(stream.sent, 0)
+}
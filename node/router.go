@@ -2,7 +2,9 @@ package node
 
 import (
 	"errors"
+	"io"
 	"sync"
+	"time"
 
 	"github.com/satori/go.uuid"
 
@@ -10,12 +12,31 @@ import (
 	"golang.org/x/net/context"
 )
 
+// receiveRetryDelay is the time the router waits before receiving again
+// from a connection that returned an error
+const receiveRetryDelay = 100 * time.Millisecond
+
+// MaxResultSize is the maximum size in bytes of a result assembled from
+// chunks
+const MaxResultSize = 16 * 1024 * 1024
+
+// ErrResultTooLarge is returned if the chunks of a result exceed MaxResultSize
+var ErrResultTooLarge = errors.New("execution result too large")
+
 // Router wraps Conn and provides a RPC like interface for dispatching
 // events and receiving the processing result
 type Router interface {
-	// Dispatch dispatches an event and returns the result
+	// Dispatch dispatches an event and returns the result. Partial results
+	// are assembled into the final result
 	Dispatch(context.Context, *sigmaV1.DispatchEvent) (*sigmaV1.ExecutionResult, error)
 
+	// Stream dispatches an event and calls fn for each partial result
+	// frame received before returning the final frame. Chunks forwarded to
+	// fn are not part of the returned result. If fn is nil, chunks are
+	// assembled into the final result instead. If fn returns an error the
+	// dispatch is aborted
+	Stream(context.Context, *sigmaV1.DispatchEvent, FrameFunc) (*sigmaV1.ExecutionResult, error)
+
 	// Close closes the router and the underlying NodeConn
 	Close() error
 
@@ -27,10 +48,32 @@ type Router interface {
 	Registered() bool
 }
 
+// FrameFunc is called for each partial result frame of an execution
+type FrameFunc func(*sigmaV1.ExecutionResult) error
+
+// IsFinal returns true if res is the final frame of an execution. Chunks,
+// progress reports and log records are followed by more frames
+func IsFinal(res *sigmaV1.ExecutionResult) bool {
+	switch res.GetExecutionResult().(type) {
+	case *sigmaV1.ExecutionResult_Chunk, *sigmaV1.ExecutionResult_Progress, *sigmaV1.ExecutionResult_Log:
+		return false
+	default:
+		return true
+	}
+}
+
+// route receives all result frames of a single dispatch
+type route struct {
+	ch chan *sigmaV1.ExecutionResult
+
+	// done is closed when the dispatch stopped waiting for results
+	done chan struct{}
+}
+
 type router struct {
 	wg     sync.WaitGroup
 	mu     sync.Mutex
-	routes map[string]chan *sigmaV1.ExecutionResult
+	routes map[string]*route
 	close  chan struct{}
 
 	conn Conn
@@ -39,7 +82,7 @@ type router struct {
 // NewRouter returns a new router for the node connection
 func NewRouter(conn Conn) Router {
 	router := &router{
-		routes: make(map[string]chan *sigmaV1.ExecutionResult),
+		routes: make(map[string]*route),
 		close:  make(chan struct{}),
 		conn:   conn,
 	}
@@ -59,7 +102,14 @@ func (r *router) Connected() bool { return r.conn.Connected() }
 
 // Dispatch dispatches an event and returns the result
 func (r *router) Dispatch(ctx context.Context, in *sigmaV1.DispatchEvent) (*sigmaV1.ExecutionResult, error) {
-	res := make(chan *sigmaV1.ExecutionResult, 1)
+	return r.Stream(ctx, in, nil)
+}
+
+// Stream dispatches an event, forwards partial results to fn and returns
+// the final result. If fn is nil, chunks are prepended to the data of the
+// final result
+func (r *router) Stream(ctx context.Context, in *sigmaV1.DispatchEvent, fn FrameFunc) (*sigmaV1.ExecutionResult, error) {
+	res := make(chan *sigmaV1.ExecutionResult, 16)
 
 	id := uuid.NewV4().String()
 
@@ -71,13 +121,36 @@ func (r *router) Dispatch(ctx context.Context, in *sigmaV1.DispatchEvent) (*sigm
 		return nil, err
 	}
 
-	select {
-	case response := <-res:
-		return response, nil
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	case <-r.close:
-		return nil, errors.New("connection closed")
+	var chunks []byte
+
+	for {
+		select {
+		case response := <-res:
+			if IsFinal(response) {
+				if v, ok := response.GetExecutionResult().(*sigmaV1.ExecutionResult_Result); ok && len(chunks) > 0 {
+					response.ExecutionResult = &sigmaV1.ExecutionResult_Result{
+						Result: append(chunks, v.Result...),
+					}
+				}
+				return response, nil
+			}
+
+			if fn != nil {
+				if err := fn(response); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			if len(chunks)+len(response.GetChunk()) > MaxResultSize {
+				return nil, ErrResultTooLarge
+			}
+			chunks = append(chunks, response.GetChunk()...)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-r.close:
+			return nil, errors.New("connection closed")
+		}
 	}
 }
 
@@ -100,22 +173,28 @@ func (r *router) deleteRoute(id string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	delete(r.routes, id)
+	if rt, ok := r.routes[id]; ok {
+		close(rt.done)
+		delete(r.routes, id)
+	}
 }
 
 func (r *router) addRoute(id string, ch chan *sigmaV1.ExecutionResult) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.routes[id] = ch
+	r.routes[id] = &route{
+		ch:   ch,
+		done: make(chan struct{}),
+	}
 }
 
-func (r *router) getRoute(id string) (chan *sigmaV1.ExecutionResult, bool) {
+func (r *router) getRoute(id string) (*route, bool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	ch, ok := r.routes[id]
-	return ch, ok
+	rt, ok := r.routes[id]
+	return rt, ok
 }
 
 func (r *router) receive() {
@@ -134,18 +213,56 @@ func (r *router) receive() {
 
 	for {
 		msg, err := r.conn.Receive(ctx)
+		if err == io.EOF {
+			// the connection has been closed and will never deliver
+			// results again
+			r.failRoutes(err)
+			<-ctx.Done()
+			return
+		}
+
 		if err != nil {
+			// don't spin while the connection is not usable
 			select {
 			case <-ctx.Done():
 				return
-			default:
+			case <-time.After(receiveRetryDelay):
 				continue
 			}
 		}
 
-		route, ok := r.getRoute(msg.GetId())
-		if ok {
-			route <- msg
+		// frames for a dispatch that stopped waiting are dropped. This
+		// includes duplicate results of events re-delivered after a
+		// reconnect
+		if rt, ok := r.getRoute(msg.GetId()); ok {
+			select {
+			case rt.ch <- msg:
+			case <-rt.done:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// failRoutes reports err as the execution result of all pending dispatches
+func (r *router) failRoutes(err error) {
+	r.mu.Lock()
+	routes := make(map[string]*route, len(r.routes))
+	for id, rt := range r.routes {
+		routes[id] = rt
+	}
+	r.mu.Unlock()
+
+	for id, rt := range routes {
+		select {
+		case rt.ch <- &sigmaV1.ExecutionResult{
+			Id: id,
+			ExecutionResult: &sigmaV1.ExecutionResult_Error{
+				Error: err.Error(),
+			},
+		}:
+		case <-rt.done:
 		}
 	}
 }
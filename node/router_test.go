@@ -8,6 +8,7 @@ import (
 	"golang.org/x/net/context"
 
 	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
+	"github.com/homebot/sigma/logs"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -38,6 +39,23 @@ func (n *nodeConnMock) Registered() bool {
 	return n.Called().Bool(0)
 }
 
+func (n *nodeConnMock) Healthy() error {
+	return n.Called().Error(0)
+}
+
+func (n *nodeConnMock) Liveness() (time.Duration, time.Time) {
+	args := n.Called()
+	return args.Get(0).(time.Duration), args.Get(1).(time.Time)
+}
+
+func (n *nodeConnMock) Logs() *logs.Source {
+	return n.Called().Get(0).(*logs.Source)
+}
+
+func (n *nodeConnMock) Capabilities() Capabilities {
+	return n.Called().Get(0).(Capabilities)
+}
+
 func (n *nodeConnMock) Close() error {
 	return n.Called().Error(0)
 }
@@ -158,3 +176,143 @@ func TestRouter_DispatchCanceled(t *testing.T) {
 
 	<-ch
 }
+
+func TestRouter_Stream(t *testing.T) {
+	assert := assert.New(t)
+	conn := new(nodeConnMock)
+	conn.send = make(chan struct{})
+
+	in := &sigmaV1.DispatchEvent{}
+	conn.On("Send", in).Return(nil)
+	conn.On("Close").Return(nil)
+
+	frames := []*sigmaV1.ExecutionResult{
+		{ExecutionResult: &sigmaV1.ExecutionResult_Progress{Progress: &sigmaV1.Progress{Percent: 50}}},
+		{ExecutionResult: &sigmaV1.ExecutionResult_Chunk{Chunk: []byte("foo")}},
+		{ExecutionResult: &sigmaV1.ExecutionResult_Chunk{Chunk: []byte("bar")}},
+		{ExecutionResult: &sigmaV1.ExecutionResult_Result{Result: []byte("!")}},
+	}
+	for _, f := range frames {
+		conn.On("Receive").Return(f, nil).Once()
+	}
+	conn.On("Receive").Return(&sigmaV1.ExecutionResult{}, errors.New("closed"))
+
+	router := NewRouter(conn).(*router)
+	defer router.Close()
+
+	var forwarded []*sigmaV1.ExecutionResult
+	ch := make(chan *sigmaV1.ExecutionResult)
+
+	go func() {
+		res, err := router.Stream(context.Background(), in, func(f *sigmaV1.ExecutionResult) error {
+			forwarded = append(forwarded, f)
+			return nil
+		})
+		assert.NoError(err)
+		ch <- res
+	}()
+
+	// wait for the route of the dispatch
+	var id string
+	for id == "" {
+		router.mu.Lock()
+		for key := range router.routes {
+			id = key
+		}
+		router.mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, f := range frames {
+		f.Id = id
+		conn.send <- struct{}{}
+	}
+
+	// forwarded chunks are not buffered
+	res := <-ch
+	assert.Equal("!", string(res.GetResult()))
+	if assert.Len(forwarded, 3) {
+		assert.Equal(float32(50), forwarded[0].GetProgress().GetPercent())
+		assert.Equal("foo", string(forwarded[1].GetChunk()))
+	}
+
+	assert.True(IsFinal(res))
+	assert.False(IsFinal(forwarded[1]))
+}
+
+func TestRouter_Assemble(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		frames   []*sigmaV1.ExecutionResult
+		expected string
+		err      error
+	}{
+		{
+			frames: []*sigmaV1.ExecutionResult{
+				{ExecutionResult: &sigmaV1.ExecutionResult_Chunk{Chunk: []byte("foo")}},
+				{ExecutionResult: &sigmaV1.ExecutionResult_Chunk{Chunk: []byte("bar")}},
+				{ExecutionResult: &sigmaV1.ExecutionResult_Result{Result: []byte("!")}},
+			},
+			expected: "foobar!",
+		},
+		{
+			frames: []*sigmaV1.ExecutionResult{
+				{ExecutionResult: &sigmaV1.ExecutionResult_Chunk{Chunk: []byte("foo")}},
+				{ExecutionResult: &sigmaV1.ExecutionResult_Chunk{Chunk: make([]byte, MaxResultSize)}},
+			},
+			err: ErrResultTooLarge,
+		},
+	} {
+		conn := new(nodeConnMock)
+		conn.send = make(chan struct{})
+
+		in := &sigmaV1.DispatchEvent{}
+		conn.On("Send", in).Return(nil)
+		conn.On("Close").Return(nil)
+
+		for _, f := range tc.frames {
+			conn.On("Receive").Return(f, nil).Once()
+		}
+		conn.On("Receive").Return(&sigmaV1.ExecutionResult{}, errors.New("closed"))
+
+		router := NewRouter(conn).(*router)
+
+		type result struct {
+			res *sigmaV1.ExecutionResult
+			err error
+		}
+		ch := make(chan result, 1)
+
+		go func() {
+			res, err := router.Dispatch(context.Background(), in)
+			ch <- result{res, err}
+		}()
+
+		// wait for the route of the dispatch
+		var id string
+		for id == "" {
+			router.mu.Lock()
+			for key := range router.routes {
+				id = key
+			}
+			router.mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+		}
+
+		for _, f := range tc.frames {
+			f.Id = id
+			conn.send <- struct{}{}
+		}
+
+		r := <-ch
+		assert.Equal(tc.err, r.err)
+		if tc.err == nil {
+			assert.Equal(tc.expected, string(r.res.GetResult()))
+		}
+
+		router.Close()
+	}
+}
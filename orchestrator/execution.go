@@ -0,0 +1,150 @@
+package orchestrator
+
+import (
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
+)
+
+// State describes the state of a workflow execution or a single step
+type State string
+
+const (
+	// StateRunning is set while the execution or step is running
+	StateRunning = State("running")
+
+	// StateSucceeded is set when the execution or step finished successfully
+	StateSucceeded = State("succeeded")
+
+	// StateFailed is set when the execution or step failed
+	StateFailed = State("failed")
+)
+
+// Execution holds the state of a workflow execution
+type Execution struct {
+	// ID is the ID of the execution
+	ID string `json:"id"`
+
+	// Workflow is the ID of the workflow executed
+	Workflow string `json:"workflow"`
+
+	// State is the current state of the execution
+	State State `json:"state"`
+
+	// Input holds the input of the workflow
+	Input []byte `json:"input"`
+
+	// Output holds the output of the workflow once it succeeded
+	Output []byte `json:"output,omitempty"`
+
+	// Error holds the error of the workflow if it failed
+	Error string `json:"error,omitempty"`
+
+	// Started holds the time the execution has been started
+	Started time.Time `json:"started"`
+
+	// Finished holds the time the execution finished
+	Finished time.Time `json:"finished,omitempty"`
+
+	// Steps holds the state of all steps executed so far by their path
+	Steps map[string]*StepState `json:"steps"`
+}
+
+// StepState holds the state of a single step of a workflow execution
+type StepState struct {
+	// Path identifies the step within the workflow
+	Path string `json:"path"`
+
+	// State is the current state of the step
+	State State `json:"state"`
+
+	// Attempts holds the number of times the step has been attempted
+	Attempts int `json:"attempts"`
+
+	// Input holds the input of the step
+	Input []byte `json:"input"`
+
+	// Output holds the output of the step once it succeeded
+	Output []byte `json:"output,omitempty"`
+
+	// Error holds the last error of the step
+	Error string `json:"error,omitempty"`
+
+	// Started holds the time the step has been started
+	Started time.Time `json:"started"`
+
+	// Finished holds the time the step finished
+	Finished time.Time `json:"finished,omitempty"`
+}
+
+// Done returns true if the execution finished
+func (e Execution) Done() bool {
+	return e.State == StateSucceeded || e.State == StateFailed
+}
+
+// copy returns a deep copy of the execution
+func (e *Execution) copy() Execution {
+	c := *e
+	c.Steps = make(map[string]*StepState, len(e.Steps))
+
+	for path, s := range e.Steps {
+		sc := *s
+		c.Steps[path] = &sc
+	}
+
+	return c
+}
+
+// ToProtobuf converts the execution to it's protocol buffer representation.
+// Steps are sorted by their start time
+func (e Execution) ToProtobuf() *sigmaV1.WorkflowExecution {
+	res := &sigmaV1.WorkflowExecution{
+		Id:       e.ID,
+		Workflow: e.Workflow,
+		State:    string(e.State),
+		Error:    e.Error,
+		Input:    e.Input,
+		Output:   e.Output,
+		Started:  timestampProto(e.Started),
+		Finished: timestampProto(e.Finished),
+	}
+
+	var steps []*StepState
+	for _, s := range e.Steps {
+		steps = append(steps, s)
+	}
+
+	sort.Slice(steps, func(i, j int) bool {
+		if steps[i].Started.Equal(steps[j].Started) {
+			return steps[i].Path < steps[j].Path
+		}
+		return steps[i].Started.Before(steps[j].Started)
+	})
+
+	for _, s := range steps {
+		res.Steps = append(res.Steps, &sigmaV1.WorkflowStep{
+			Path:     s.Path,
+			State:    string(s.State),
+			Attempts: int64(s.Attempts),
+			Error:    s.Error,
+			Input:    s.Input,
+			Output:   s.Output,
+			Started:  timestampProto(s.Started),
+			Finished: timestampProto(s.Finished),
+		})
+	}
+
+	return res
+}
+
+func timestampProto(t time.Time) *timestamp.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+
+	ts, _ := ptypes.TimestampProto(t)
+	return ts
+}
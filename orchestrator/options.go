@@ -0,0 +1,36 @@
+package orchestrator
+
+import (
+	"github.com/homebot/insight/logger"
+	"github.com/homebot/sigma/events"
+)
+
+// Option configures the orchestrator
+type Option func(o *Orchestrator) error
+
+// WithStore configures the store used to persist workflows and executions
+func WithStore(s Store) Option {
+	return func(o *Orchestrator) error {
+		o.store = s
+		return nil
+	}
+}
+
+// WithLogger configures the logger for the orchestrator
+func WithLogger(l logger.Logger) Option {
+	return func(o *Orchestrator) error {
+		o.l = l
+		return nil
+	}
+}
+
+// WithFunctions makes Resume() wait with each execution until all functions
+// used by its workflow are deployed again. deployed reports whether a
+// function is currently deployed and bus is watched for function creations
+func WithFunctions(bus *events.Bus, deployed func(string) bool) Option {
+	return func(o *Orchestrator) error {
+		o.bus = bus
+		o.deployed = deployed
+		return nil
+	}
+}
@@ -0,0 +1,654 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/net/context"
+
+	"github.com/homebot/insight/logger"
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/events"
+	"github.com/homebot/sigma/trigger"
+)
+
+// EventWorkflowStep is the type of events dispatched to the functions of
+// workflow steps
+const EventWorkflowStep = "sigma.workflow.step"
+
+// maxRetryDelay is the maximum delay between two attempts of a step
+const maxRetryDelay = time.Minute
+
+// Dispatcher dispatches events to functions and is implemented by
+// scheduler.Scheduler
+type Dispatcher interface {
+	// Dispatch dispatches an event to a function and returns the result.
+	// The execution must be aborted once the context is done
+	Dispatch(context.Context, string, sigma.Event) (string, []byte, error)
+}
+
+// Orchestrator executes workflows by dispatching the input of each step to
+// the step's function. If a store is configured, workflows and the state of
+// all executions are persisted so executions can be resumed after a restart
+type Orchestrator struct {
+	dispatcher Dispatcher
+	store      Store
+	l          logger.Logger
+
+	// bus and deployed are used to delay resumed executions until their
+	// functions are deployed
+	bus      *events.Bus
+	deployed func(string) bool
+
+	rw         sync.RWMutex
+	workflows  map[string]*workflow
+	executions map[string]*run
+
+	// waiting holds resumed executions waiting for their functions
+	waiting []*run
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// workflow is a registered and compiled workflow
+type workflow struct {
+	spec  Workflow
+	steps []step
+}
+
+// New creates a new workflow orchestrator using d to dispatch events to
+// functions
+func New(d Dispatcher, opts ...Option) (*Orchestrator, error) {
+	o := &Orchestrator{
+		dispatcher: d,
+		workflows:  make(map[string]*workflow),
+		executions: make(map[string]*run),
+	}
+
+	for _, fn := range opts {
+		if err := fn(o); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.l == nil {
+		o.l = logger.NopLogger{}
+	}
+
+	o.ctx, o.cancel = context.WithCancel(context.Background())
+
+	return o, nil
+}
+
+// Resume loads all workflows and executions from the store and continues
+// all executions that have not yet finished. Steps that already succeeded
+// are not executed again while steps that were running are restarted.
+// If configured using WithFunctions, executions are continued once all
+// functions of their workflow are deployed
+func (o *Orchestrator) Resume() error {
+	if o.store == nil {
+		return nil
+	}
+
+	workflows, err := o.store.LoadWorkflows()
+	if err != nil {
+		return err
+	}
+
+	executions, err := o.store.LoadExecutions()
+	if err != nil {
+		return err
+	}
+
+	// subscribe before checking for deployed functions so no creation
+	// is missed
+	var sub *events.Subscription
+	if o.bus != nil && o.deployed != nil {
+		sub = o.bus.Subscribe(events.DefaultBufferSize)
+	}
+
+	o.rw.Lock()
+	defer o.rw.Unlock()
+
+	for _, spec := range workflows {
+		steps, err := compile(spec)
+		if err != nil {
+			o.l.Errorf("failed to load workflow %q: %s", spec.ID, err)
+			continue
+		}
+
+		o.workflows[spec.ID] = &workflow{
+			spec:  spec,
+			steps: steps,
+		}
+	}
+
+	for idx := range executions {
+		e := executions[idx]
+		if e.Steps == nil {
+			e.Steps = make(map[string]*StepState)
+		}
+
+		r := &run{
+			o:    o,
+			wf:   o.workflows[e.Workflow],
+			exec: &e,
+		}
+
+		o.executions[e.ID] = r
+
+		if e.Done() {
+			continue
+		}
+
+		if r.wf == nil {
+			r.finish(nil, ErrUnknownWorkflow)
+			continue
+		}
+
+		if missing := o.missingFunctions(r.wf); len(missing) > 0 {
+			o.l.Infof("execution %s of workflow %q waits for functions %s", e.ID, e.Workflow, strings.Join(missing, ", "))
+			o.waiting = append(o.waiting, r)
+			continue
+		}
+
+		o.l.Infof("resuming execution %s of workflow %q", e.ID, e.Workflow)
+
+		o.wg.Add(1)
+		go r.execute()
+	}
+
+	if sub != nil {
+		if len(o.waiting) == 0 {
+			sub.Close()
+		} else {
+			o.wg.Add(1)
+			go o.watchFunctions(sub)
+		}
+	}
+
+	return nil
+}
+
+// missingFunctions returns the functions used by wf that are not deployed
+func (o *Orchestrator) missingFunctions(wf *workflow) []string {
+	if o.deployed == nil {
+		return nil
+	}
+
+	var missing []string
+
+	for _, fn := range functions(wf.steps) {
+		if !o.deployed(fn) {
+			missing = append(missing, fn)
+		}
+	}
+
+	return missing
+}
+
+// watchFunctions resumes waiting executions when functions are created
+func (o *Orchestrator) watchFunctions(sub *events.Subscription) {
+	defer o.wg.Done()
+	defer sub.Close()
+
+	for {
+		select {
+		case evt, ok := <-sub.C:
+			if !ok {
+				return
+			}
+
+			if evt.Type != events.FunctionCreated {
+				continue
+			}
+
+			if o.resumeWaiting() == 0 {
+				return
+			}
+		case <-o.ctx.Done():
+			return
+		}
+	}
+}
+
+// resumeWaiting continues all waiting executions whose functions are
+// deployed and returns the number of executions that are still waiting
+func (o *Orchestrator) resumeWaiting() int {
+	o.rw.Lock()
+	defer o.rw.Unlock()
+
+	if o.ctx.Err() != nil {
+		return 0
+	}
+
+	var waiting []*run
+
+	for _, r := range o.waiting {
+		if len(o.missingFunctions(r.wf)) > 0 {
+			waiting = append(waiting, r)
+			continue
+		}
+
+		o.l.Infof("resuming execution %s of workflow %q", r.exec.ID, r.exec.Workflow)
+
+		o.wg.Add(1)
+		go r.execute()
+	}
+
+	o.waiting = waiting
+
+	return len(waiting)
+}
+
+// Register validates and registers the workflow. Existing workflows with the
+// same ID are replaced
+func (o *Orchestrator) Register(w Workflow) error {
+	steps, err := compile(w)
+	if err != nil {
+		return err
+	}
+
+	if o.store != nil {
+		if err := o.store.SaveWorkflow(w); err != nil {
+			return err
+		}
+	}
+
+	o.rw.Lock()
+	defer o.rw.Unlock()
+
+	o.workflows[w.ID] = &workflow{
+		spec:  w,
+		steps: steps,
+	}
+
+	return nil
+}
+
+// Remove removes the workflow. Running executions are not affected
+func (o *Orchestrator) Remove(id string) error {
+	o.rw.Lock()
+	_, ok := o.workflows[id]
+	delete(o.workflows, id)
+	o.rw.Unlock()
+
+	if !ok {
+		return ErrUnknownWorkflow
+	}
+
+	if o.store != nil {
+		return o.store.DeleteWorkflow(id)
+	}
+
+	return nil
+}
+
+// Workflows returns all registered workflows
+func (o *Orchestrator) Workflows() []Workflow {
+	o.rw.RLock()
+	defer o.rw.RUnlock()
+
+	var res []Workflow
+
+	for _, w := range o.workflows {
+		res = append(res, w.spec)
+	}
+
+	return res
+}
+
+// Run starts a new execution of the workflow and returns the execution ID
+func (o *Orchestrator) Run(id string, input []byte) (string, error) {
+	o.rw.Lock()
+
+	wf, ok := o.workflows[id]
+	if !ok {
+		o.rw.Unlock()
+		return "", ErrUnknownWorkflow
+	}
+
+	r := &run{
+		o:  o,
+		wf: wf,
+		exec: &Execution{
+			ID:       uuid.NewV4().String(),
+			Workflow: id,
+			State:    StateRunning,
+			Input:    input,
+			Started:  time.Now(),
+			Steps:    make(map[string]*StepState),
+		},
+	}
+
+	o.executions[r.exec.ID] = r
+	o.wg.Add(1)
+	o.rw.Unlock()
+
+	// the execution is persisted before any of its steps. The store is not
+	// accessed while holding the lock as it would block all other workflow
+	// operations
+	r.save()
+	go r.execute()
+
+	return r.exec.ID, nil
+}
+
+// Execution returns the current state of the execution
+func (o *Orchestrator) Execution(id string) (Execution, error) {
+	o.rw.RLock()
+	r, ok := o.executions[id]
+	o.rw.RUnlock()
+
+	if !ok {
+		return Execution{}, ErrUnknownExecution
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.exec.copy(), nil
+}
+
+// Close stops all running executions. Their state is kept in the store so
+// they can be resumed later
+func (o *Orchestrator) Close() error {
+	o.cancel()
+	o.wg.Wait()
+
+	return nil
+}
+
+// run is a single execution of a workflow
+type run struct {
+	o  *Orchestrator
+	wf *workflow
+
+	// mu protects exec
+	mu   sync.Mutex
+	exec *Execution
+
+	// saveLock ensures executions are persisted in order
+	saveLock sync.Mutex
+}
+
+func (r *run) execute() {
+	defer r.o.wg.Done()
+
+	out, err := r.runSteps(r.o.ctx, r.wf.steps, r.exec.Input)
+
+	if r.o.ctx.Err() != nil {
+		// the orchestrator has been closed, keep the execution running so
+		// it's resumed
+		return
+	}
+
+	r.finish(out, err)
+}
+
+func (r *run) finish(out []byte, err error) {
+	r.mu.Lock()
+	r.exec.Finished = time.Now()
+
+	if err != nil {
+		r.exec.State = StateFailed
+		r.exec.Error = err.Error()
+	} else {
+		r.exec.State = StateSucceeded
+		r.exec.Output = out
+	}
+	r.mu.Unlock()
+
+	if err != nil {
+		r.o.l.Warnf("execution %s of workflow %q failed: %s", r.exec.ID, r.exec.Workflow, err)
+	} else {
+		r.o.l.Infof("execution %s of workflow %q succeeded", r.exec.ID, r.exec.Workflow)
+	}
+
+	r.save()
+}
+
+// save persists the current state of the execution
+func (r *run) save() {
+	if r.o.store == nil {
+		return
+	}
+
+	r.saveLock.Lock()
+	defer r.saveLock.Unlock()
+
+	r.mu.Lock()
+	e := r.exec.copy()
+	r.mu.Unlock()
+
+	if err := r.o.store.SaveExecution(e); err != nil {
+		r.o.l.Errorf("failed to persist execution %s: %s", e.ID, err)
+	}
+}
+
+// update updates the state of the step at path and persists the execution
+func (r *run) update(path string, fn func(s *StepState)) {
+	r.mu.Lock()
+	s, ok := r.exec.Steps[path]
+	if !ok {
+		s = &StepState{Path: path}
+		r.exec.Steps[path] = s
+	}
+	fn(s)
+	r.mu.Unlock()
+
+	r.save()
+}
+
+// succeeded returns the output of the step at path if it already succeeded
+func (r *run) succeeded(path string) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.exec.Steps[path]
+	if !ok || s.State != StateSucceeded {
+		return nil, false
+	}
+
+	return s.Output, true
+}
+
+func (r *run) runSteps(ctx context.Context, steps []step, input []byte) ([]byte, error) {
+	for _, s := range steps {
+		out, err := r.runStep(ctx, s, input)
+		if err != nil {
+			return nil, err
+		}
+
+		input = out
+	}
+
+	return input, nil
+}
+
+func (r *run) runStep(ctx context.Context, s step, input []byte) ([]byte, error) {
+	if out, ok := r.succeeded(s.path); ok {
+		return out, nil
+	}
+
+	// build the input of the step using it's input template
+	evt, err := s.input.Apply(sigma.NewSimpleEvent(EventWorkflowStep, input), r.wf.spec.Parameters)
+	if err == nil {
+		input = evt.Payload()
+	}
+
+	r.update(s.path, func(st *StepState) {
+		st.State = StateRunning
+		st.Input = input
+		st.Error = ""
+		st.Started = time.Now()
+	})
+
+	var out []byte
+	if err == nil {
+		out, err = r.runStepKind(ctx, s, input)
+	}
+
+	if r.o.ctx.Err() != nil {
+		return nil, r.o.ctx.Err()
+	}
+
+	r.update(s.path, func(st *StepState) {
+		st.Finished = time.Now()
+
+		if err != nil {
+			st.State = StateFailed
+			st.Error = err.Error()
+		} else {
+			st.State = StateSucceeded
+			st.Output = out
+		}
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("step %q: %s", s.path, err)
+	}
+
+	return out, nil
+}
+
+func (r *run) runStepKind(ctx context.Context, s step, input []byte) ([]byte, error) {
+	if s.Function != "" {
+		return r.runFunction(ctx, s, input)
+	}
+
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	if len(s.parallel) > 0 {
+		return r.runParallel(ctx, s, input)
+	}
+
+	return r.runSwitch(ctx, s, input)
+}
+
+// runFunction dispatches input to the function of the step and retries
+// failed attempts
+func (r *run) runFunction(ctx context.Context, s step, input []byte) ([]byte, error) {
+	backoff := trigger.Backoff{
+		Initial: s.retryDelay,
+		Max:     maxRetryDelay,
+	}
+
+	for attempt := 1; ; attempt++ {
+		r.update(s.path, func(st *StepState) {
+			st.Attempts++
+		})
+
+		out, err := r.dispatch(ctx, s, input)
+		if err == nil {
+			return out, nil
+		}
+
+		if attempt > s.Retries || ctx.Err() != nil {
+			return nil, err
+		}
+
+		delay := backoff.Delay(attempt)
+
+		r.o.l.Warnf("execution %s: step %q failed: %s (retrying in %s)", r.exec.ID, s.path, err, delay)
+		r.update(s.path, func(st *StepState) {
+			st.Error = err.Error()
+		})
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// dispatch dispatches input to the function of the step. The attempt is
+// cancelled once the step timeout expires
+func (r *run) dispatch(ctx context.Context, s step, input []byte) ([]byte, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	_, out, err := r.o.dispatcher.Dispatch(ctx, s.Function, sigma.NewSimpleEvent(EventWorkflowStep, input))
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return out, err
+}
+
+// runParallel executes all branches concurrently and combines their output
+// into a JSON object
+func (r *run) runParallel(ctx context.Context, s step, input []byte) ([]byte, error) {
+	outputs := make([][]byte, len(s.parallel))
+	errs := make([]error, len(s.parallel))
+
+	var wg sync.WaitGroup
+
+	for idx, b := range s.parallel {
+		wg.Add(1)
+		go func(idx int, b branch) {
+			defer wg.Done()
+			outputs[idx], errs[idx] = r.runSteps(ctx, b.steps, input)
+		}(idx, b)
+	}
+
+	wg.Wait()
+
+	res := make(map[string]interface{})
+
+	for idx, b := range s.parallel {
+		if errs[idx] != nil {
+			return nil, fmt.Errorf("branch %q: %s", b.name, errs[idx])
+		}
+
+		res[b.name] = jsonValue(outputs[idx])
+	}
+
+	return json.Marshal(res)
+}
+
+// runSwitch executes the first branch with a satisfied condition
+func (r *run) runSwitch(ctx context.Context, s step, input []byte) ([]byte, error) {
+	evt := sigma.NewSimpleEvent(EventWorkflowStep, input)
+
+	for _, b := range s.cases {
+		ok, err := b.condition.Evaluate(evt, r.wf.spec.Parameters)
+		if err != nil {
+			return nil, fmt.Errorf("branch %q: %s", b.name, err)
+		}
+
+		if ok {
+			return r.runSteps(ctx, b.steps, input)
+		}
+	}
+
+	return input, nil
+}
+
+// jsonValue returns blob as raw JSON if it's valid JSON and as a string
+// otherwise
+func jsonValue(blob []byte) interface{} {
+	if len(blob) == 0 {
+		return nil
+	}
+
+	if json.Valid(blob) {
+		return json.RawMessage(blob)
+	}
+
+	return string(blob)
+}
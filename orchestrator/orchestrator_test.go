@@ -0,0 +1,497 @@
+package orchestrator
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/homebot/core/resource"
+	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/events"
+	"github.com/homebot/sigma/node"
+	"github.com/homebot/sigma/scheduler"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDispatcher calls the handler registered for each function
+type fakeDispatcher struct {
+	mu       sync.Mutex
+	calls    map[string]int
+	handlers map[string]func(ctx context.Context, payload []byte) ([]byte, error)
+}
+
+func newFakeDispatcher() *fakeDispatcher {
+	return &fakeDispatcher{
+		calls:    make(map[string]int),
+		handlers: make(map[string]func(context.Context, []byte) ([]byte, error)),
+	}
+}
+
+func (f *fakeDispatcher) Dispatch(ctx context.Context, fn string, evt sigma.Event) (string, []byte, error) {
+	f.mu.Lock()
+	f.calls[fn]++
+	h, ok := f.handlers[fn]
+	f.mu.Unlock()
+
+	if !ok {
+		return "", nil, errors.New("unknown function")
+	}
+
+	res, err := h(ctx, evt.Payload())
+	return "node", res, err
+}
+
+func (f *fakeDispatcher) called(fn string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.calls[fn]
+}
+
+func wait(t *testing.T, o *Orchestrator, id string) Execution {
+	deadline := time.Now().Add(2 * time.Second)
+
+	for {
+		e, err := o.Execution(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e.Done() {
+			return e
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("execution %s did not finish", id)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func echo(prefix string) func(context.Context, []byte) ([]byte, error) {
+	return func(_ context.Context, payload []byte) ([]byte, error) {
+		return []byte(prefix + string(payload)), nil
+	}
+}
+
+func TestCompile_Invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, w := range []Workflow{
+		{},
+		{ID: "w"},
+		{ID: "w", Steps: []Step{{}}},
+		{ID: "w", Steps: []Step{{Function: "a", Switch: []Branch{{Steps: []Step{{Function: "b"}}}}}}},
+		{ID: "w", Steps: []Step{{Function: "a"}, {Function: "a"}}},
+		{ID: "w", Steps: []Step{{Function: "a", Timeout: "soon"}}},
+		{ID: "w", Steps: []Step{{Function: "a", Input: "{{ .Event"}}},
+		{ID: "w", Steps: []Step{{Switch: []Branch{{Condition: "payload ==", Steps: []Step{{Function: "b"}}}}}}},
+		{ID: "w", Steps: []Step{{Parallel: []Branch{{Name: "x"}}}}},
+	} {
+		_, err := compile(w)
+		assert.Error(err, "%+v", w)
+	}
+}
+
+func TestOrchestrator_Run(t *testing.T) {
+	assert := assert.New(t)
+
+	d := newFakeDispatcher()
+	d.handlers["fetch"] = echo("")
+	d.handlers["a"] = echo("a:")
+	d.handlers["b"] = echo("b:")
+	d.handlers["hot"] = echo("hot")
+	d.handlers["cold"] = echo("cold")
+
+	o, err := New(d)
+	if !assert.NoError(err) {
+		return
+	}
+	defer o.Close()
+
+	assert.NoError(o.Register(Workflow{
+		ID: "weather",
+		Steps: []Step{
+			{Function: "fetch"},
+			{
+				Name: "rules",
+				Switch: []Branch{
+					{Name: "hot", Condition: "json(payload).temp > 30", Steps: []Step{{Function: "hot", Input: "{{ .Event.temp }}"}}},
+					{Name: "cold", Steps: []Step{{Function: "cold"}}},
+				},
+			},
+			{
+				Name: "notify",
+				Parallel: []Branch{
+					{Name: "a", Steps: []Step{{Function: "a"}}},
+					{Name: "b", Steps: []Step{{Function: "b"}}},
+				},
+			},
+		},
+	}))
+
+	id, err := o.Run("weather", []byte(`{"temp": 35}`))
+	assert.NoError(err)
+
+	e := wait(t, o, id)
+	assert.Equal(StateSucceeded, e.State)
+	assert.JSONEq(`{"a": "a:hot35", "b": "b:hot35"}`, string(e.Output))
+
+	assert.Equal(StateSucceeded, e.Steps["fetch"].State)
+	assert.Equal(StateSucceeded, e.Steps["rules/hot/hot"].State)
+	assert.Equal("35", string(e.Steps["rules/hot/hot"].Input))
+	assert.Equal("a:hot35", string(e.Steps["notify/a/a"].Output))
+	assert.Nil(e.Steps["rules/cold/cold"])
+
+	_, err = o.Run("unknown", nil)
+	assert.Equal(ErrUnknownWorkflow, err)
+}
+
+// blockingStore blocks saving executions until release is closed
+type blockingStore struct {
+	Store
+	saving  chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingStore) SaveExecution(e Execution) error {
+	select {
+	case b.saving <- struct{}{}:
+	default:
+	}
+
+	<-b.release
+	return b.Store.SaveExecution(e)
+}
+
+func TestOrchestrator_RunDoesNotBlockOnStore(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "orchestrator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs, err := NewFileStore(dir)
+	if !assert.NoError(err) {
+		return
+	}
+
+	store := &blockingStore{
+		Store:   fs,
+		saving:  make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+
+	d := newFakeDispatcher()
+	d.handlers["a"] = echo("a:")
+
+	o, err := New(d, WithStore(store))
+	if !assert.NoError(err) {
+		return
+	}
+	defer o.Close()
+
+	assert.NoError(o.Register(Workflow{ID: "w", Steps: []Step{{Function: "a"}}}))
+
+	done := make(chan string)
+	go func() {
+		id, err := o.Run("w", nil)
+		assert.NoError(err)
+		done <- id
+	}()
+
+	<-store.saving
+
+	// other workflow operations are not blocked while the execution is
+	// persisted
+	listed := make(chan int)
+	go func() { listed <- len(o.Workflows()) }()
+
+	select {
+	case n := <-listed:
+		assert.Equal(1, n)
+	case <-time.After(time.Second):
+		t.Error("workflows blocked by saving an execution")
+	}
+
+	close(store.release)
+
+	e := wait(t, o, <-done)
+	assert.Equal(StateSucceeded, e.State)
+}
+
+func TestOrchestrator_RetryAndTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	d := newFakeDispatcher()
+
+	failures := 2
+	d.handlers["flaky"] = func(context.Context, []byte) ([]byte, error) {
+		if failures > 0 {
+			failures--
+			return nil, errors.New("flaky")
+		}
+		return []byte("ok"), nil
+	}
+
+	d.handlers["slow"] = func(ctx context.Context, _ []byte) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	o, err := New(d)
+	if !assert.NoError(err) {
+		return
+	}
+	defer o.Close()
+
+	assert.NoError(o.Register(Workflow{
+		ID:    "flaky",
+		Steps: []Step{{Function: "flaky", Retries: 2, RetryDelay: "1ms"}},
+	}))
+
+	assert.NoError(o.Register(Workflow{
+		ID:    "slow",
+		Steps: []Step{{Function: "slow", Retries: 1, RetryDelay: "1ms", Timeout: "10ms"}},
+	}))
+
+	id, _ := o.Run("flaky", nil)
+	e := wait(t, o, id)
+	assert.Equal(StateSucceeded, e.State)
+	assert.Equal(3, e.Steps["flaky"].Attempts)
+	assert.Equal("ok", string(e.Output))
+
+	id, _ = o.Run("slow", nil)
+	e = wait(t, o, id)
+	assert.Equal(StateFailed, e.State)
+	assert.Equal(2, e.Steps["slow"].Attempts)
+	assert.Equal(StateFailed, e.Steps["slow"].State)
+	assert.True(strings.Contains(e.Error, "deadline"), e.Error)
+}
+
+func TestOrchestrator_Resume(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "sigma-orchestrator")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStore(dir)
+	if !assert.NoError(err) {
+		return
+	}
+
+	w := Workflow{
+		ID: "acct/workflows/resume",
+		Steps: []Step{
+			{Function: "first"},
+			{Function: "second"},
+		},
+	}
+
+	assert.NoError(store.SaveWorkflow(w))
+
+	// an execution interrupted while running the second step
+	assert.NoError(store.SaveExecution(Execution{
+		ID:       "exec-1",
+		Workflow: w.ID,
+		State:    StateRunning,
+		Input:    []byte("in"),
+		Started:  time.Now(),
+		Steps: map[string]*StepState{
+			"first":  {Path: "first", State: StateSucceeded, Attempts: 1, Output: []byte("first-out")},
+			"second": {Path: "second", State: StateRunning, Attempts: 1, Input: []byte("first-out")},
+		},
+	}))
+
+	d := newFakeDispatcher()
+	d.handlers["first"] = echo("unexpected")
+	d.handlers["second"] = echo("second:")
+
+	o, err := New(d, WithStore(store))
+	if !assert.NoError(err) {
+		return
+	}
+	defer o.Close()
+
+	assert.NoError(o.Resume())
+	assert.Len(o.Workflows(), 1)
+
+	e := wait(t, o, "exec-1")
+	assert.Equal(StateSucceeded, e.State)
+	assert.Equal("second:first-out", string(e.Output))
+	assert.Equal(0, d.called("first"))
+	assert.Equal(2, e.Steps["second"].Attempts)
+
+	// the final state is persisted. The execution is finished in memory
+	// before it is saved so wait for the store to catch up
+	var executions []Execution
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		executions, err = store.LoadExecutions()
+		if err != nil || (len(executions) == 1 && executions[0].Done()) {
+			break
+		}
+	}
+	assert.NoError(err)
+	if assert.Len(executions, 1) {
+		assert.Equal(StateSucceeded, executions[0].State)
+		assert.Equal("second:first-out", string(executions[0].Output))
+	}
+}
+
+// echoNode is a node returning its payload prefixed with the function ID.
+// If block is set, it only answers once block is closed
+type echoNode struct {
+	urn    string
+	prefix string
+	block  chan struct{}
+}
+
+func (n *echoNode) URN() string                     { return n.urn }
+func (n *echoNode) State() node.State               { return node.StateActive }
+func (n *echoNode) Stats() node.Stats               { return node.Stats{} }
+func (n *echoNode) OnDestroy(func(node.Controller)) {}
+func (n *echoNode) Drain(context.Context) error     { return nil }
+func (n *echoNode) Close() error                    { return nil }
+
+func (n *echoNode) Dispatch(ctx context.Context, e *sigmaV1.DispatchEvent) ([]byte, error) {
+	return n.Stream(ctx, e, nil)
+}
+
+func (n *echoNode) Stream(ctx context.Context, e *sigmaV1.DispatchEvent, fn node.FrameFunc) ([]byte, error) {
+	if n.block != nil {
+		select {
+		case <-n.block:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return []byte(n.prefix + string(e.GetPayload())), nil
+}
+
+func TestOrchestrator_ResumeWaitsForFunctions(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "sigma-orchestrator")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// the second function blocks until the server is restarted
+	block := make(chan struct{})
+
+	deployer := node.DeployFunc(func(ctx context.Context, u string, spec sigma.FunctionSpec) (node.Controller, error) {
+		n := &echoNode{urn: u, prefix: spec.ID + ":"}
+		if spec.ID == "second" {
+			n.block = block
+		}
+		return n, nil
+	})
+
+	start := func() (scheduler.Scheduler, *Orchestrator) {
+		bus := events.NewBus()
+
+		s, err := scheduler.NewScheduler(deployer, scheduler.WithEventBus(bus))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		store, err := NewFileStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		deployed := func(id string) bool {
+			_, err := s.Inspect(context.Background(), resource.Name(id))
+			return err == nil
+		}
+
+		o, err := New(s, WithStore(store), WithFunctions(bus, deployed))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return s, o
+	}
+
+	create := func(s scheduler.Scheduler) {
+		for _, id := range []string{"first", "second"} {
+			_, err := s.Create(context.Background(), sigma.FunctionSpec{ID: id, Type: "test"})
+			assert.NoError(err)
+		}
+	}
+
+	destroy := func(s scheduler.Scheduler) {
+		for _, id := range []string{"first", "second"} {
+			s.ForceDestroy(context.Background(), id)
+		}
+	}
+
+	s, o := start()
+	create(s)
+
+	// nodes are deployed asynchronously so steps are retried until they
+	// are available
+	assert.NoError(o.Register(Workflow{
+		ID: "acct/workflows/restart",
+		Steps: []Step{
+			{Function: "first", Retries: 10, RetryDelay: "5ms"},
+			{Function: "second", Retries: 10, RetryDelay: "5ms"},
+		},
+	}))
+
+	id, err := o.Run("acct/workflows/restart", []byte("in"))
+	assert.NoError(err)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		e, _ := o.Execution(id)
+		if st, ok := e.Steps["second"]; ok && st.State == StateRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("second step did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// stop the first server while the second step is running
+	assert.NoError(o.Close())
+	destroy(s)
+	close(block)
+
+	// functions are not persisted so the execution must wait until they
+	// are created again
+	s, o = start()
+	defer o.Close()
+	defer destroy(s)
+
+	assert.NoError(o.Resume())
+
+	time.Sleep(50 * time.Millisecond)
+
+	e, err := o.Execution(id)
+	assert.NoError(err)
+	assert.Equal(StateRunning, e.State)
+	assert.Empty(e.Error)
+
+	create(s)
+
+	e = wait(t, o, id)
+	assert.Equal(StateSucceeded, e.State)
+	assert.Equal("second:first:in", string(e.Output))
+}
@@ -0,0 +1,134 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Store persists workflows and the state of their executions
+type Store interface {
+	// SaveWorkflow stores the workflow
+	SaveWorkflow(Workflow) error
+
+	// DeleteWorkflow removes the workflow with the given ID
+	DeleteWorkflow(string) error
+
+	// LoadWorkflows returns all stored workflows
+	LoadWorkflows() ([]Workflow, error)
+
+	// SaveExecution stores the state of the execution
+	SaveExecution(Execution) error
+
+	// LoadExecutions returns all stored executions
+	LoadExecutions() ([]Execution, error)
+}
+
+// fileStore is a Store that keeps each workflow and execution in a separate
+// JSON file
+type fileStore struct {
+	dir string
+}
+
+// NewFileStore returns a Store that persists workflows and executions as
+// JSON files within dir
+func NewFileStore(dir string) (Store, error) {
+	for _, sub := range []string{"workflows", "executions"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	return &fileStore{dir: dir}, nil
+}
+
+func (f *fileStore) path(kind, id string) string {
+	return filepath.Join(f.dir, kind, url.PathEscape(id)+".json")
+}
+
+func (f *fileStore) SaveWorkflow(w Workflow) error {
+	return f.write(f.path("workflows", w.ID), w)
+}
+
+func (f *fileStore) DeleteWorkflow(id string) error {
+	return os.Remove(f.path("workflows", id))
+}
+
+func (f *fileStore) LoadWorkflows() ([]Workflow, error) {
+	var res []Workflow
+
+	err := f.readAll("workflows", func(blob []byte) error {
+		var w Workflow
+		if err := json.Unmarshal(blob, &w); err != nil {
+			return err
+		}
+
+		res = append(res, w)
+		return nil
+	})
+
+	return res, err
+}
+
+func (f *fileStore) SaveExecution(e Execution) error {
+	return f.write(f.path("executions", e.ID), e)
+}
+
+func (f *fileStore) LoadExecutions() ([]Execution, error) {
+	var res []Execution
+
+	err := f.readAll("executions", func(blob []byte) error {
+		var e Execution
+		if err := json.Unmarshal(blob, &e); err != nil {
+			return err
+		}
+
+		res = append(res, e)
+		return nil
+	})
+
+	return res, err
+}
+
+// write atomically writes the JSON encoded value to path
+func (f *fileStore) write(path string, v interface{}) error {
+	blob, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, blob, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (f *fileStore) readAll(kind string, fn func([]byte) error) error {
+	files, err := ioutil.ReadDir(filepath.Join(f.dir, kind))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		blob, err := ioutil.ReadFile(filepath.Join(f.dir, kind, file.Name()))
+		if err != nil {
+			return err
+		}
+
+		if err := fn(blob); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
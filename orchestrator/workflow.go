@@ -0,0 +1,323 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/trigger"
+)
+
+var (
+	// ErrInvalidWorkflow is returned when a workflow specification is invalid
+	ErrInvalidWorkflow = errors.New("invalid workflow")
+
+	// ErrUnknownWorkflow is returned when the workflow in question does not
+	// exist
+	ErrUnknownWorkflow = errors.New("unknown workflow")
+
+	// ErrUnknownExecution is returned when the workflow execution in question
+	// does not exist
+	ErrUnknownExecution = errors.New("unknown workflow execution")
+)
+
+// Workflow describes a multi-step workflow. Each step receives the output
+// of the previous step as it's input. The output of the last step is the
+// output of the workflow
+type Workflow struct {
+	// ID holds the ID of the workflow
+	ID string `json:"id" yaml:"id"`
+
+	// Steps holds the steps of the workflow which are executed in order
+	Steps []Step `json:"steps" yaml:"steps"`
+
+	// Parameters may hold optional parameters available to step conditions
+	// and input templates
+	Parameters sigma.ValueMap `json:"parameters" yaml:"parameters"`
+}
+
+// Step describes a single workflow step. Exactly one of Function, Parallel
+// or Switch must be set
+type Step struct {
+	// Name is the name of the step and must be unique within it's sequence.
+	// Defaults to the function name
+	Name string `json:"name" yaml:"name"`
+
+	// Function is the ID of the function to dispatch the input to
+	Function string `json:"function" yaml:"function"`
+
+	// Parallel holds branches that are executed concurrently. The output of
+	// the step is a JSON object holding the output of each branch by name
+	Parallel []Branch `json:"parallel" yaml:"parallel"`
+
+	// Switch holds branches of which the first one with a satisfied
+	// condition is executed. If no branch matches, the input is passed on
+	Switch []Branch `json:"switch" yaml:"switch"`
+
+	// Input holds an optional template used to build the input of the step.
+	// See trigger.Transform for more information
+	Input string `json:"input" yaml:"input"`
+
+	// Retries holds the number of times a failed function step is retried
+	Retries int `json:"retries" yaml:"retries"`
+
+	// RetryDelay is the delay before the first retry (e.g. "1s"). It's
+	// doubled on each consecutive retry
+	RetryDelay string `json:"retryDelay" yaml:"retryDelay"`
+
+	// Timeout holds the maximum duration (e.g. "30s") of a single attempt
+	// of a function step or of the complete parallel or switch step
+	Timeout string `json:"timeout" yaml:"timeout"`
+}
+
+// Branch is a sequence of steps within a parallel or switch step
+type Branch struct {
+	// Name is the name of the branch. Defaults to `branch-<index>`
+	Name string `json:"name" yaml:"name"`
+
+	// Condition holds a govaluate expression evaluated against the step
+	// input. Only used for switch steps. An empty condition always matches
+	Condition string `json:"when" yaml:"when"`
+
+	// Steps holds the steps of the branch
+	Steps []Step `json:"steps" yaml:"steps"`
+}
+
+// step is a validated and compiled workflow step
+type step struct {
+	Step
+
+	// path identifies the step within the workflow, e.g. `fetch` or
+	// `notify/email/send`
+	path string
+
+	input      *trigger.Transform
+	timeout    time.Duration
+	retryDelay time.Duration
+	parallel   []branch
+	cases      []branch
+}
+
+// branch is a validated and compiled branch
+type branch struct {
+	name      string
+	condition *trigger.Condition
+	steps     []step
+}
+
+// compile validates the workflow and compiles all step conditions and
+// input templates
+func compile(w Workflow) ([]step, error) {
+	if w.ID == "" {
+		return nil, fmt.Errorf("%s: missing ID", ErrInvalidWorkflow)
+	}
+
+	return compileSteps("", w.Steps)
+}
+
+// functions returns the IDs of all functions used by steps, including the
+// steps of parallel and switch branches
+func functions(steps []step) []string {
+	var res []string
+	seen := make(map[string]bool)
+
+	var visit func([]step)
+	visit = func(steps []step) {
+		for _, s := range steps {
+			if s.Function != "" && !seen[s.Function] {
+				seen[s.Function] = true
+				res = append(res, s.Function)
+			}
+
+			for _, b := range s.parallel {
+				visit(b.steps)
+			}
+
+			for _, b := range s.cases {
+				visit(b.steps)
+			}
+		}
+	}
+
+	visit(steps)
+
+	return res
+}
+
+func joinPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+
+	return parent + "/" + name
+}
+
+func compileSteps(parent string, specs []Step) ([]step, error) {
+	if len(specs) == 0 {
+		if parent == "" {
+			return nil, fmt.Errorf("%s: no steps defined", ErrInvalidWorkflow)
+		}
+		return nil, fmt.Errorf("%s: %s: no steps defined", ErrInvalidWorkflow, parent)
+	}
+
+	var res []step
+	names := make(map[string]bool)
+
+	for idx, spec := range specs {
+		if spec.Name == "" {
+			if spec.Function != "" {
+				spec.Name = path.Base(spec.Function)
+			} else {
+				spec.Name = fmt.Sprintf("step-%d", idx)
+			}
+		}
+
+		where := joinPath(parent, spec.Name)
+
+		if names[spec.Name] {
+			return nil, fmt.Errorf("%s: %s: duplicate step name", ErrInvalidWorkflow, where)
+		}
+		names[spec.Name] = true
+
+		s, err := compileStep(where, spec)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, s)
+	}
+
+	return res, nil
+}
+
+func compileStep(where string, spec Step) (step, error) {
+	s := step{
+		Step: spec,
+		path: where,
+	}
+
+	kinds := 0
+	if spec.Function != "" {
+		kinds++
+	}
+	if len(spec.Parallel) > 0 {
+		kinds++
+	}
+	if len(spec.Switch) > 0 {
+		kinds++
+	}
+
+	if kinds != 1 {
+		return s, fmt.Errorf("%s: %s: exactly one of function, parallel or switch must be set", ErrInvalidWorkflow, where)
+	}
+
+	if spec.Retries < 0 {
+		return s, fmt.Errorf("%s: %s: retries must not be negative", ErrInvalidWorkflow, where)
+	}
+
+	var err error
+
+	if spec.Timeout != "" {
+		if s.timeout, err = time.ParseDuration(spec.Timeout); err != nil {
+			return s, fmt.Errorf("%s: %s: invalid timeout: %s", ErrInvalidWorkflow, where, err)
+		}
+	}
+
+	if spec.RetryDelay != "" {
+		if s.retryDelay, err = time.ParseDuration(spec.RetryDelay); err != nil {
+			return s, fmt.Errorf("%s: %s: invalid retry delay: %s", ErrInvalidWorkflow, where, err)
+		}
+	}
+
+	if s.input, err = trigger.CompileTransform(spec.Input); err != nil {
+		return s, fmt.Errorf("%s: %s: invalid input template: %s", ErrInvalidWorkflow, where, err)
+	}
+
+	if s.parallel, err = compileBranches(where, spec.Parallel); err != nil {
+		return s, err
+	}
+
+	if s.cases, err = compileBranches(where, spec.Switch); err != nil {
+		return s, err
+	}
+
+	return s, nil
+}
+
+func compileBranches(where string, specs []Branch) ([]branch, error) {
+	var res []branch
+	names := make(map[string]bool)
+
+	for idx, spec := range specs {
+		name := spec.Name
+		if name == "" {
+			name = fmt.Sprintf("branch-%d", idx)
+		}
+
+		if names[name] {
+			return nil, fmt.Errorf("%s: %s/%s: duplicate branch name", ErrInvalidWorkflow, where, name)
+		}
+		names[name] = true
+
+		cond, err := trigger.CompileCondition(spec.Condition)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s/%s: invalid condition: %s", ErrInvalidWorkflow, where, name, err)
+		}
+
+		steps, err := compileSteps(joinPath(where, name), spec.Steps)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, branch{
+			name:      name,
+			condition: cond,
+			steps:     steps,
+		})
+	}
+
+	return res, nil
+}
+
+// MapFunctions returns a copy of the workflow where each function ID has been
+// replaced by the result of fn
+func (w Workflow) MapFunctions(fn func(string) string) Workflow {
+	var mapSteps func(steps []Step) []Step
+	mapBranches := func(branches []Branch) []Branch {
+		if branches == nil {
+			return nil
+		}
+
+		res := make([]Branch, len(branches))
+		for idx, b := range branches {
+			res[idx] = b
+			res[idx].Steps = mapSteps(b.Steps)
+		}
+
+		return res
+	}
+
+	mapSteps = func(steps []Step) []Step {
+		if steps == nil {
+			return nil
+		}
+
+		res := make([]Step, len(steps))
+		for idx, s := range steps {
+			res[idx] = s
+			if s.Function != "" {
+				res[idx].Function = fn(s.Function)
+			}
+			res[idx].Parallel = mapBranches(s.Parallel)
+			res[idx].Switch = mapBranches(s.Switch)
+		}
+
+		return res
+	}
+
+	w.Steps = mapSteps(w.Steps)
+
+	return w
+}
@@ -1,8 +1,11 @@
 package scheduler
 
 import (
+	"time"
+
 	"github.com/homebot/core/resource"
 	"github.com/homebot/insight/logger"
+	"github.com/homebot/sigma/events"
 )
 
 // Option is a Scheduler option
@@ -24,6 +27,24 @@ func WithID(id resource.Name) Option {
 	}
 }
 
+// WithEventBus configures the bus lifecycle events of the scheduler and all
+// function controllers are published on. Defaults to events.DefaultBus
+func WithEventBus(bus *events.Bus) Option {
+	return func(s *scheduler) error {
+		s.bus = bus
+		return nil
+	}
+}
+
+// WithDrainTimeout configures how long nodes may take to finish in-flight
+// events when they are drained. Defaults to function.DefaultDrainTimeout
+func WithDrainTimeout(d time.Duration) Option {
+	return func(s *scheduler) error {
+		s.drainTimeout = d
+		return nil
+	}
+}
+
 func WithLogger(l logger.Logger) Option {
 	return func(s *scheduler) error {
 		s.log = l
@@ -2,6 +2,8 @@ package scheduler
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,11 +15,18 @@ import (
 	"github.com/homebot/core/resource"
 	"github.com/homebot/insight/logger"
 	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/events"
 	"github.com/homebot/sigma/function"
 	"github.com/homebot/sigma/node"
 	"github.com/homebot/sigma/trigger"
 )
 
+var (
+	// ErrOutputLoop is returned when the outputs of a function would route
+	// results back to the function itself
+	ErrOutputLoop = errors.New("function outputs form a loop")
+)
+
 // NodeInstance describes a node instance
 type NodeInstance struct {
 	// Name is the Name of the node
@@ -40,6 +49,9 @@ type FunctionRegistration struct {
 
 	// Nodes holds a list of nodes baking the function
 	Nodes []NodeInstance
+
+	// Triggers holds the state of all function triggers
+	Triggers []function.TriggerState
 }
 
 // Scheduler creates, manages and destroys function controllers
@@ -49,24 +61,43 @@ type Scheduler interface {
 	// Create creates a new function controller for the spec
 	Create(context.Context, sigma.FunctionSpec) (string, error)
 
-	// Destroy destroys the function controller for the URN
+	// Destroy destroys the function controller for the URN. Nodes are
+	// drained and finish their in-flight events before they are stopped
 	Destroy(context.Context, string) error
 
+	// ForceDestroy destroys the function controller for the URN and stops
+	// all nodes immediately
+	ForceDestroy(context.Context, string) error
+
 	// Dispatch dispatches an event to a function and returns the result
 	Dispatch(context.Context, string, sigma.Event) (string, []byte, error)
 
+	// DispatchStream dispatches an event to a function, calls the
+	// FrameFunc for each partial result and returns the rest of the result
+	// that has not been passed to the FrameFunc
+	DispatchStream(context.Context, string, sigma.Event, node.FrameFunc) (string, []byte, error)
+
 	// Functions returns a list of functions registered at the scheduler
 	Functions(context.Context) ([]FunctionRegistration, error)
 
 	// Inspec inspects a function and returns details and statistics about
 	// the function controller
 	Inspect(context.Context, resource.Name) (FunctionRegistration, error)
+
+	// EnableTrigger enables a trigger of a function
+	EnableTrigger(ctx context.Context, function string, trigger string) error
+
+	// DisableTrigger disables a trigger of a function
+	DisableTrigger(ctx context.Context, function string, trigger string) error
 }
 
 type scheduler struct {
 	id        resource.Name
 	namespace string
 	deployer  node.Deployer
+	bus       *events.Bus
+
+	drainTimeout time.Duration
 
 	log logger.Logger
 
@@ -97,6 +128,10 @@ func NewScheduler(d node.Deployer, opts ...Option) (Scheduler, error) {
 		s.log = logger.NopLogger{}
 	}
 
+	if s.bus == nil {
+		s.bus = events.DefaultBus
+	}
+
 	return s, nil
 }
 
@@ -134,9 +169,15 @@ func (s *scheduler) Create(ctx context.Context, spec sigma.FunctionSpec) (string
 	opts := []function.ControllerOption{
 		function.WithScalingPolicies(spec.Policies),
 		function.WithEventDispatcher(event.NewNopDispatcher(true)),
+		function.WithEventBus(s.bus),
 		function.WithControlLoopInterval(10 * time.Second),
 		function.WithDeployer(s.deployer),
 		function.WithTriggerBuilder(trigger.DefaultBuilder),
+		function.WithOutputHandler(s.routeOutput),
+	}
+
+	if s.drainTimeout > 0 {
+		opts = append(opts, function.WithDrainTimeout(s.drainTimeout))
 	}
 
 	log := s.log.WithResource(spec.ID)
@@ -155,18 +196,38 @@ func (s *scheduler) Create(ctx context.Context, spec sigma.FunctionSpec) (string
 		return ctrl.Name().String(), errors.New("function already created")
 	}
 
+	if path := s.findLoop(spec); path != nil {
+		log.Errorf("function outputs form a loop: %s", strings.Join(path, " -> "))
+		return u, fmt.Errorf("%s: %s", ErrOutputLoop, strings.Join(path, " -> "))
+	}
+
 	s.controllers[ctrl.Name().String()] = ctrl
 	if err := ctrl.Start(); err != nil {
 		log.Errorf("failed to start controller")
 		return u, err
 	}
 
+	s.bus.Publish(events.Event{
+		Type:     events.FunctionCreated,
+		Function: ctrl.Name().String(),
+	})
+
 	log.Infof("successfully created function")
 	return ctrl.Name().String(), nil
 }
 
-// Destroy destroys the function controller and all nodes
+// Destroy destroys the function controller and drains all nodes
 func (s *scheduler) Destroy(ctx context.Context, u string) error {
+	return s.destroy(ctx, u, false)
+}
+
+// ForceDestroy destroys the function controller and all nodes without
+// waiting for in-flight events
+func (s *scheduler) ForceDestroy(ctx context.Context, u string) error {
+	return s.destroy(ctx, u, true)
+}
+
+func (s *scheduler) destroy(ctx context.Context, u string, force bool) error {
 	log := s.log.WithResource(u)
 
 	s.mu.Lock()
@@ -182,7 +243,20 @@ func (s *scheduler) Destroy(ctx context.Context, u string) error {
 	if err := ctrl.Stop(); err != nil {
 		log.Errorf("failed to stop function controller: %s", err)
 	}
-	if err := ctrl.DestroyAll(); err != nil {
+
+	var err error
+	if force {
+		err = ctrl.DestroyAll()
+	} else {
+		err = ctrl.DrainAll(ctx)
+	}
+
+	s.bus.Publish(events.Event{
+		Type:     events.FunctionDestroyed,
+		Function: u,
+	})
+
+	if err != nil {
 		log.Errorf("failed to destroy function nodes: %s", err)
 		return err
 	}
@@ -195,6 +269,12 @@ func (s *scheduler) Destroy(ctx context.Context, u string) error {
 // Dispatch dispatches an event to the function controller and returns the result
 // of the function
 func (s *scheduler) Dispatch(ctx context.Context, u string, event sigma.Event) (string, []byte, error) {
+	return s.DispatchStream(ctx, u, event, nil)
+}
+
+// DispatchStream dispatches an event to the function controller, forwards
+// partial results to fn and returns the rest of the result of the function
+func (s *scheduler) DispatchStream(ctx context.Context, u string, event sigma.Event, fn node.FrameFunc) (string, []byte, error) {
 	log := s.log.WithResource(u)
 
 	s.mu.Lock()
@@ -207,7 +287,7 @@ func (s *scheduler) Dispatch(ctx context.Context, u string, event sigma.Event) (
 	}
 
 	start := time.Now()
-	node, res, err := ctrl.Dispatch(event)
+	selected, res, err := ctrl.DispatchStream(ctx, event, fn)
 
 	duration := time.Now().Sub(start)
 
@@ -217,7 +297,49 @@ func (s *scheduler) Dispatch(ctx context.Context, u string, event sigma.Event) (
 		log.Infof("function executed in %s", duration)
 	}
 
-	return node, res, err
+	return selected, res, err
+}
+
+// EnableTrigger enables a trigger of the function
+func (s *scheduler) EnableTrigger(ctx context.Context, u string, name string) error {
+	log := s.log.WithResource(u)
+
+	s.mu.Lock()
+	ctrl, ok := s.controllers[u]
+	s.mu.Unlock()
+
+	if !ok {
+		log.Errorf("unknown function")
+		return errors.New("unknown function")
+	}
+
+	if err := ctrl.EnableTrigger(name); err != nil {
+		log.Errorf("failed to enable trigger %q: %s", name, err)
+		return err
+	}
+
+	return nil
+}
+
+// DisableTrigger disables a trigger of the function
+func (s *scheduler) DisableTrigger(ctx context.Context, u string, name string) error {
+	log := s.log.WithResource(u)
+
+	s.mu.Lock()
+	ctrl, ok := s.controllers[u]
+	s.mu.Unlock()
+
+	if !ok {
+		log.Errorf("unknown function")
+		return errors.New("unknown function")
+	}
+
+	if err := ctrl.DisableTrigger(name); err != nil {
+		log.Errorf("failed to disable trigger %q: %s", name, err)
+		return err
+	}
+
+	return nil
 }
 
 func (s *scheduler) inspect(ctx context.Context, u resource.Name) (FunctionRegistration, error) {
@@ -244,6 +366,63 @@ func (s *scheduler) inspect(ctx context.Context, u resource.Name) (FunctionRegis
 	}
 
 	reg.Spec = ctrl.FunctionSpec()
+	reg.Triggers = ctrl.Triggers()
 
 	return reg, nil
 }
+
+// routeOutput dispatches the output event of a function to the target
+// function. Outputs are dispatched asynchronously so chained functions
+// do not block the upstream function
+func (s *scheduler) routeOutput(target string, event sigma.Event) {
+	go func() {
+		if _, _, err := s.Dispatch(context.Background(), target, event); err != nil {
+			s.log.WithResource(target).Warnf("failed to route %q event: %s", event.Type(), err)
+		}
+	}()
+}
+
+// findLoop checks if the outputs of spec, together with the outputs of all
+// functions already registered, route back to spec. It returns the path of
+// function IDs forming the loop or nil. The caller must hold the scheduler
+// lock
+func (s *scheduler) findLoop(spec sigma.FunctionSpec) []string {
+	outputs := func(id string) []sigma.OutputSpec {
+		if id == spec.ID {
+			return spec.Outputs
+		}
+
+		ctrl, ok := s.controllers[id]
+		if !ok {
+			return nil
+		}
+
+		return ctrl.FunctionSpec().Outputs
+	}
+
+	visited := make(map[string]bool)
+
+	var visit func(id string, path []string) []string
+	visit = func(id string, path []string) []string {
+		path = append(path, id)
+
+		for _, o := range outputs(id) {
+			if o.Function == spec.ID {
+				return append(path, o.Function)
+			}
+
+			if visited[o.Function] {
+				continue
+			}
+			visited[o.Function] = true
+
+			if loop := visit(o.Function, path); loop != nil {
+				return loop
+			}
+		}
+
+		return nil
+	}
+
+	return visit(spec.ID, nil)
+}
@@ -0,0 +1,50 @@
+package scheduler
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/node"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduler_OutputLoop(t *testing.T) {
+	assert := assert.New(t)
+
+	deployer := node.DeployFunc(func(context.Context, string, sigma.FunctionSpec) (node.Controller, error) {
+		return nil, errors.New("not supported")
+	})
+
+	s, err := NewScheduler(deployer)
+	if !assert.NoError(err) {
+		return
+	}
+
+	create := func(id string, outputs ...string) error {
+		spec := sigma.FunctionSpec{
+			ID:   id,
+			Type: "test",
+		}
+
+		for _, o := range outputs {
+			spec.Outputs = append(spec.Outputs, sigma.OutputSpec{Function: o})
+		}
+
+		_, err := s.Create(context.Background(), spec)
+		return err
+	}
+
+	assert.NoError(create("a", "b", "c"))
+	assert.NoError(create("b", "c"))
+	assert.NoError(create("d", "d-unknown"))
+
+	err = create("c", "d", "a")
+	assert.Error(err)
+	assert.Contains(err.Error(), "c -> a -> b -> c")
+
+	assert.Error(create("self", "self"))
+	assert.NoError(create("c", "d"))
+}
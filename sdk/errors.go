@@ -0,0 +1,79 @@
+package sdk
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// CodeInternal is used for unexpected errors and panics of a handler
+	CodeInternal = "internal"
+
+	// CodeInvalidArgument is used if an event cannot be processed because
+	// of it's payload
+	CodeInvalidArgument = "invalid_argument"
+
+	// CodeUnavailable is used if the runtime cannot execute an event right
+	// now, for example because it is shutting down
+	CodeUnavailable = "unavailable"
+)
+
+var (
+	// ErrHeartbeatTimeout is returned from Run if the sigma server stopped
+	// sending heartbeats
+	ErrHeartbeatTimeout = errors.New("sigma server missed heartbeats")
+
+	// ErrShuttingDown is reported for events received while the runtime
+	// shuts down
+	ErrShuttingDown = &Error{Code: CodeUnavailable, Message: "runtime is shutting down"}
+)
+
+// Error is a structured execution error. It is reported to the sigma
+// server as "<code>: <message>"
+type Error struct {
+	// Code classifies the error
+	Code string
+
+	// Message describes the error
+	Message string
+}
+
+// Errorf returns a new structured error with the given code
+func Errorf(code string, format string, args ...interface{}) *Error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// ParseError parses an execution error reported by a runtime. Errors that
+// are not structured are returned with CodeInternal
+func ParseError(s string) *Error {
+	parts := strings.SplitN(s, ": ", 2)
+	if len(parts) == 2 && isCode(parts[0]) {
+		return &Error{Code: parts[0], Message: parts[1]}
+	}
+
+	return &Error{Code: CodeInternal, Message: s}
+}
+
+// isCode returns true if s looks like an error code
+func isCode(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && r != '_' {
+			return false
+		}
+	}
+
+	return true
+}
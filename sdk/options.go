@@ -0,0 +1,81 @@
+package sdk
+
+import (
+	"time"
+
+	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
+	"github.com/homebot/sigma/launcher"
+)
+
+// Option configures a runtime
+type Option func(*Runtime)
+
+// WithConfig configures the address, URN, secret and credentials used to
+// connect to the sigma node handler. Defaults to launcher.ConfigFromEnv()
+func WithConfig(c launcher.Config) Option {
+	return func(r *Runtime) {
+		r.config = c
+	}
+}
+
+// WithClient configures the node handler client to use instead of dialing
+// the address of the configuration
+func WithClient(cli sigmaV1.NodeHandlerClient) Option {
+	return func(r *Runtime) {
+		r.client = cli
+	}
+}
+
+// WithNodeType configures the node type announced during registration.
+// Defaults to the type of the configuration
+func WithNodeType(typ string) Option {
+	return func(r *Runtime) {
+		r.nodeType = typ
+	}
+}
+
+// WithConcurrency configures the number of events executed at the same
+// time. Defaults to 1
+func WithConcurrency(n int) Option {
+	return func(r *Runtime) {
+		if n < 1 {
+			n = 1
+		}
+		r.concurrency = n
+	}
+}
+
+// WithMaxPayloadSize configures the maximum size of event payloads in bytes
+// the runtime accepts. Zero means unlimited
+func WithMaxPayloadSize(n int) Option {
+	return func(r *Runtime) {
+		r.maxPayloadSize = n
+	}
+}
+
+// WithInit registers a function that is called with the function content
+// and parameters after the runtime registered at the sigma server. If fn
+// returns an error, Run fails
+func WithInit(fn func(Init) error) Option {
+	return func(r *Runtime) {
+		r.onInit = fn
+	}
+}
+
+// WithShutdownTimeout configures how long Run waits for in-flight events
+// after the context has been cancelled before their handlers are cancelled
+// as well. Defaults to DefaultShutdownTimeout
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(r *Runtime) {
+		r.shutdownTimeout = d
+	}
+}
+
+// WithResubscribeDelay configures how long the runtime waits before
+// resuming it's session after the subscription stream broke. Defaults to
+// DefaultResubscribeDelay
+func WithResubscribeDelay(d time.Duration) Option {
+	return func(r *Runtime) {
+		r.resubscribeDelay = d
+	}
+}
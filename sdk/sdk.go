@@ -0,0 +1,430 @@
+// Package sdk implements the sigma node protocol for function runtimes
+// written in Go. A runtime registers itself at the sigma server, executes
+// the events it receives using a Handler, answers heartbeats and resumes
+// it's session if the connection to the server breaks
+package sdk
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/certs"
+	"github.com/homebot/sigma/launcher"
+	"github.com/homebot/sigma/node"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// DefaultShutdownTimeout is the default time in-flight events may take
+	// to finish when the runtime is stopped
+	DefaultShutdownTimeout = 30 * time.Second
+
+	// DefaultResubscribeDelay is the default time the runtime waits before
+	// resuming it's session
+	DefaultResubscribeDelay = time.Second
+)
+
+// Event is an event dispatched to the function
+type Event struct {
+	// ID uniquely identifies the invocation
+	ID string
+
+	// Type is the type of the event
+	Type string
+
+	// Payload holds the event data
+	Payload []byte
+}
+
+// Init holds the function configuration sent by the sigma server when the
+// runtime registers
+type Init struct {
+	// URN is the URN of the node
+	URN string
+
+	// Content holds the function content, e.g. the source code to execute
+	Content []byte
+
+	// Parameters holds the function parameters
+	Parameters sigma.ValueMap
+}
+
+// Handler executes events and returns their result. Errors of type *Error
+// are reported with their code
+type Handler interface {
+	Handle(context.Context, Event) ([]byte, error)
+}
+
+// HandlerFunc implements Handler
+type HandlerFunc func(context.Context, Event) ([]byte, error)
+
+// Handle calls `f` and implements Handler
+func (f HandlerFunc) Handle(ctx context.Context, e Event) ([]byte, error) {
+	return f(ctx, e)
+}
+
+// Runtime connects a Handler to the sigma node handler server
+type Runtime struct {
+	handler Handler
+
+	config           launcher.Config
+	client           sigmaV1.NodeHandlerClient
+	nodeType         string
+	concurrency      int
+	maxPayloadSize   int
+	onInit           func(Init) error
+	shutdownTimeout  time.Duration
+	resubscribeDelay time.Duration
+
+	// sem limits the number of events executed concurrently and wg tracks
+	// all events that have been accepted
+	sem chan struct{}
+	wg  sync.WaitGroup
+
+	// sendMu serializes sends on the subscription stream
+	sendMu sync.Mutex
+
+	mu       sync.Mutex
+	stream   sigmaV1.NodeHandler_SubscribeClient
+	closing  bool
+	lastSeen time.Time
+}
+
+// New returns a new runtime for the handler
+func New(h Handler, opts ...Option) *Runtime {
+	r := &Runtime{
+		handler:          h,
+		config:           launcher.ConfigFromEnv(),
+		concurrency:      1,
+		shutdownTimeout:  DefaultShutdownTimeout,
+		resubscribeDelay: DefaultResubscribeDelay,
+	}
+
+	for _, fn := range opts {
+		fn(r)
+	}
+
+	if r.nodeType == "" {
+		r.nodeType = r.config.Type
+	}
+
+	r.sem = make(chan struct{}, r.concurrency)
+
+	return r
+}
+
+// Run creates a new runtime for the handler and runs it until the process
+// receives SIGINT or SIGTERM
+func Run(h Handler, opts ...Option) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return New(h, opts...).Run(ctx)
+}
+
+// Run registers the runtime at the sigma server and executes events until
+// ctx is cancelled. Once cancelled, new events are rejected with
+// ErrShuttingDown and in-flight events may finish within the shutdown
+// timeout. Run returns ErrHeartbeatTimeout if the server stops sending
+// heartbeats
+func (r *Runtime) Run(ctx context.Context) error {
+	if r.client == nil {
+		conn, err := dial(r.config)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		r.client = sigmaV1.NewNodeHandlerClient(conn)
+	}
+
+	md := metadata.Pairs("node-urn", r.config.URN, "node-secret", r.config.Secret)
+
+	res, err := r.client.Register(metadata.NewOutgoingContext(ctx, md), &sigmaV1.NodeRegistrationRequest{
+		Urn:             r.config.URN,
+		NodeType:        r.nodeType,
+		ProtocolVersion: node.ProtocolVersion,
+		Capabilities: node.Capabilities{
+			Concurrency:    r.concurrency,
+			Heartbeats:     true,
+			MaxPayloadSize: r.maxPayloadSize,
+		}.ToProtobuf(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if r.onInit != nil {
+		if err := r.onInit(Init{
+			URN:        res.GetUrn(),
+			Content:    res.GetContent(),
+			Parameters: sigma.ValueMapFrom(res.GetParameters()),
+		}); err != nil {
+			return err
+		}
+	}
+
+	// the subscription stream and the handlers outlive ctx so in-flight
+	// events can finish during shutdown
+	streamCtx, cancelStreams := context.WithCancel(metadata.NewOutgoingContext(context.Background(), md))
+	defer cancelStreams()
+
+	handlerCtx, cancelHandlers := context.WithCancel(context.Background())
+	defer cancelHandlers()
+
+	r.seen(time.Now())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.subscribe(streamCtx, handlerCtx)
+	}()
+
+	// the watchdog is disabled if the server does not send heartbeats
+	var watchdog <-chan time.Time
+	timeout := heartbeatTimeout(res)
+	if timeout > 0 {
+		ticker := time.NewTicker(timeout / 4)
+		defer ticker.Stop()
+
+		watchdog = ticker.C
+	}
+
+	teardown := func() {
+		r.shutdown(cancelHandlers)
+		cancelStreams()
+		<-done
+	}
+
+	for {
+		select {
+		case now := <-watchdog:
+			if now.Sub(r.lastSeenAt()) > timeout {
+				teardown()
+				return ErrHeartbeatTimeout
+			}
+		case <-ctx.Done():
+			teardown()
+			return nil
+		}
+	}
+}
+
+// heartbeatTimeout returns the time after which the sigma server is
+// considered gone if nothing has been received
+func heartbeatTimeout(res *sigmaV1.NodeRegistrationResponse) time.Duration {
+	interval, err := ptypes.Duration(res.GetHeartbeatInterval())
+	if err != nil || interval <= 0 || res.GetHeartbeatThreshold() <= 0 {
+		return 0
+	}
+
+	return interval * time.Duration(res.GetHeartbeatThreshold()+1)
+}
+
+// shutdown rejects new events and waits for in-flight events. Handlers are
+// cancelled if they do not finish within the shutdown timeout
+func (r *Runtime) shutdown(cancelHandlers context.CancelFunc) {
+	r.mu.Lock()
+	r.closing = true
+	r.mu.Unlock()
+
+	idle := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(idle)
+	}()
+
+	select {
+	case <-idle:
+	case <-time.After(r.shutdownTimeout):
+		cancelHandlers()
+		<-idle
+	}
+}
+
+// subscribe opens the subscription stream and resumes the session each
+// time the stream breaks until streamCtx is cancelled
+func (r *Runtime) subscribe(streamCtx, handlerCtx context.Context) {
+	for {
+		stream, err := r.client.Subscribe(streamCtx)
+		if err == nil {
+			r.setStream(stream)
+			r.serve(handlerCtx, stream)
+			r.setStream(nil)
+		}
+
+		select {
+		case <-streamCtx.Done():
+			return
+		case <-time.After(r.resubscribeDelay):
+		}
+	}
+}
+
+// serve receives events from stream until it breaks
+func (r *Runtime) serve(handlerCtx context.Context, stream sigmaV1.NodeHandler_SubscribeClient) {
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			return
+		}
+		r.seen(time.Now())
+
+		if msg.GetType() == node.HeartbeatEventType {
+			r.send(&sigmaV1.ExecutionResult{
+				Id:              msg.GetId(),
+				ExecutionResult: &sigmaV1.ExecutionResult_Result{},
+			})
+			continue
+		}
+
+		if !r.accept() {
+			r.send(errorResult(msg.GetId(), ErrShuttingDown))
+			continue
+		}
+
+		go r.execute(handlerCtx, msg)
+	}
+}
+
+// accept registers a new in-flight event unless the runtime shuts down
+func (r *Runtime) accept() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closing {
+		return false
+	}
+
+	r.wg.Add(1)
+	return true
+}
+
+// execute waits for a free slot, executes the event and reports the result
+func (r *Runtime) execute(ctx context.Context, msg *sigmaV1.DispatchEvent) {
+	defer r.wg.Done()
+
+	select {
+	case r.sem <- struct{}{}:
+	case <-ctx.Done():
+		r.send(errorResult(msg.GetId(), ErrShuttingDown))
+		return
+	}
+	defer func() { <-r.sem }()
+
+	res, err := r.call(ctx, Event{
+		ID:      msg.GetId(),
+		Type:    msg.GetType(),
+		Payload: msg.GetPayload(),
+	})
+	if err != nil {
+		r.send(errorResult(msg.GetId(), err))
+		return
+	}
+
+	r.send(&sigmaV1.ExecutionResult{
+		Id: msg.GetId(),
+		ExecutionResult: &sigmaV1.ExecutionResult_Result{
+			Result: res,
+		},
+	})
+}
+
+// call calls the handler and converts panics to errors
+func (r *Runtime) call(ctx context.Context, e Event) (res []byte, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = Errorf(CodeInternal, "panic: %v", v)
+		}
+	}()
+
+	return r.handler.Handle(ctx, e)
+}
+
+// send sends res on the current stream. If the runtime is currently
+// disconnected the result is dropped and the sigma server either fails or
+// re-delivers the event
+func (r *Runtime) send(res *sigmaV1.ExecutionResult) {
+	r.mu.Lock()
+	stream := r.stream
+	r.mu.Unlock()
+
+	if stream == nil {
+		return
+	}
+
+	r.sendMu.Lock()
+	defer r.sendMu.Unlock()
+
+	if err := stream.Send(res); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send result for %s: %s\n", res.GetId(), err)
+	}
+}
+
+func (r *Runtime) setStream(s sigmaV1.NodeHandler_SubscribeClient) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stream = s
+}
+
+func (r *Runtime) seen(t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastSeen = t
+}
+
+func (r *Runtime) lastSeenAt() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.lastSeen
+}
+
+func errorResult(id string, err error) *sigmaV1.ExecutionResult {
+	return &sigmaV1.ExecutionResult{
+		Id: id,
+		ExecutionResult: &sigmaV1.ExecutionResult_Error{
+			Error: err.Error(),
+		},
+	}
+}
+
+// dial connects to the node handler server of the configuration
+func dial(c launcher.Config) (*grpc.ClientConn, error) {
+	opt := grpc.WithInsecure()
+
+	if c.TLS != nil {
+		tlsConfig, err := certs.ClientConfigFromPEM(c.TLS.CA, c.TLS.Cert, c.TLS.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		opt = grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+	}
+
+	return grpc.Dial(c.Address, opt)
+}
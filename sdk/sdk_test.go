@@ -0,0 +1,380 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/launcher"
+	"github.com/homebot/sigma/node"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// localClient implements sigmaV1.NodeHandlerClient by calling a node
+// server in-process
+type localClient struct {
+	srv node.NodeServer
+}
+
+func incoming(ctx context.Context) context.Context {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return metadata.NewIncomingContext(ctx, md)
+}
+
+func (c *localClient) Register(ctx context.Context, in *sigmaV1.NodeRegistrationRequest, opts ...grpc.CallOption) (*sigmaV1.NodeRegistrationResponse, error) {
+	return c.srv.Register(incoming(ctx), in)
+}
+
+func (c *localClient) Subscribe(ctx context.Context, opts ...grpc.CallOption) (sigmaV1.NodeHandler_SubscribeClient, error) {
+	p := &pipe{
+		ctx:     incoming(ctx),
+		events:  make(chan *sigmaV1.DispatchEvent),
+		results: make(chan *sigmaV1.ExecutionResult),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.close()
+		case <-p.done:
+		}
+	}()
+
+	go func() {
+		c.srv.Subscribe(&serverStream{pipe: p})
+		p.close()
+	}()
+
+	return &clientStream{pipe: p}, nil
+}
+
+// pipe connects a client and a server stream
+type pipe struct {
+	ctx     context.Context
+	events  chan *sigmaV1.DispatchEvent
+	results chan *sigmaV1.ExecutionResult
+
+	once sync.Once
+	done chan struct{}
+}
+
+func (p *pipe) close() {
+	p.once.Do(func() { close(p.done) })
+}
+
+func (p *pipe) Context() context.Context { return p.ctx }
+
+type serverStream struct {
+	grpc.ServerStream
+	*pipe
+}
+
+func (s *serverStream) Context() context.Context { return s.pipe.Context() }
+
+func (s *serverStream) Send(in *sigmaV1.DispatchEvent) error {
+	select {
+	case s.events <- in:
+		return nil
+	case <-s.done:
+		return io.EOF
+	}
+}
+
+func (s *serverStream) Recv() (*sigmaV1.ExecutionResult, error) {
+	select {
+	case res := <-s.results:
+		return res, nil
+	case <-s.done:
+		return nil, io.EOF
+	}
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	*pipe
+}
+
+func (s *clientStream) Context() context.Context { return s.pipe.Context() }
+
+func (s *clientStream) Send(in *sigmaV1.ExecutionResult) error {
+	select {
+	case s.results <- in:
+		return nil
+	case <-s.done:
+		return io.EOF
+	}
+}
+
+func (s *clientStream) Recv() (*sigmaV1.DispatchEvent, error) {
+	select {
+	case evt := <-s.events:
+		return evt, nil
+	case <-s.done:
+		return nil, io.EOF
+	}
+}
+
+type instance struct{}
+
+func (instance) Healthy() error { return nil }
+func (instance) Stop() error    { return nil }
+
+// start prepares a node at a new node server, runs a runtime for h and
+// returns the connection to the node and a channel receiving the result
+// of Run
+func start(t *testing.T, ctx context.Context, h Handler, opts ...Option) (*Runtime, node.Conn, chan error) {
+	srv := node.NewNodeServer(node.WithHeartbeatInterval(10 * time.Millisecond))
+
+	conn, err := srv.Prepare("urn:node", "secret", sigma.FunctionSpec{Type: "go", Content: "content"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts = append([]Option{
+		WithClient(&localClient{srv: srv}),
+		WithConfig(launcher.Config{URN: "urn:node", Secret: "secret", Type: "go"}),
+		WithResubscribeDelay(time.Millisecond),
+	}, opts...)
+
+	r := New(h, opts...)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Run(ctx)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for !conn.Registered() || !conn.Connected() {
+		if time.Now().After(deadline) {
+			t.Fatal("runtime did not connect")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	return r, conn, done
+}
+
+func TestRuntime(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var init Init
+	handler := HandlerFunc(func(ctx context.Context, e Event) ([]byte, error) {
+		switch string(e.Payload) {
+		case "fail":
+			return nil, Errorf(CodeInvalidArgument, "cannot handle %q", e.Payload)
+		case "panic":
+			panic("oops")
+		default:
+			return bytes.ToUpper(e.Payload), nil
+		}
+	})
+
+	_, conn, done := start(t, ctx, handler,
+		WithConcurrency(2),
+		WithInit(func(i Init) error {
+			init = i
+			return nil
+		}),
+	)
+	ctrl := node.CreateController("urn:node", instance{}, conn)
+
+	assert.Equal([]byte("content"), init.Content)
+	assert.Equal(node.Capabilities{Concurrency: 2, Heartbeats: true}, conn.Capabilities())
+
+	res, err := ctrl.Dispatch(ctx, &sigmaV1.DispatchEvent{Payload: []byte("hello")})
+	assert.NoError(err)
+	assert.Equal([]byte("HELLO"), res)
+
+	_, err = ctrl.Dispatch(ctx, &sigmaV1.DispatchEvent{Payload: []byte("fail")})
+	if assert.Error(err) {
+		assert.Equal(&Error{Code: CodeInvalidArgument, Message: `cannot handle "fail"`}, ParseError(err.Error()))
+	}
+
+	_, err = ctrl.Dispatch(ctx, &sigmaV1.DispatchEvent{Payload: []byte("panic")})
+	if assert.Error(err) {
+		assert.Equal(CodeInternal, ParseError(err.Error()).Code)
+	}
+
+	// heartbeats are answered by the runtime
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(node.StateActive, ctrl.State())
+	assert.False(ctrl.Stats().LastSeen.IsZero())
+
+	cancel()
+	assert.NoError(<-done)
+}
+
+func TestRuntime_GracefulShutdown(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	handler := HandlerFunc(func(ctx context.Context, e Event) ([]byte, error) {
+		close(started)
+		<-release
+		return []byte("done"), nil
+	})
+
+	// the timeout is long enough to never cancel the in-flight event but
+	// keeps the test from hanging
+	r, conn, done := start(t, ctx, handler, WithShutdownTimeout(5*time.Second))
+	ctrl := node.CreateController("urn:node", instance{}, conn)
+
+	result := make(chan []byte, 1)
+	go func() {
+		res, _ := ctrl.Dispatch(context.Background(), &sigmaV1.DispatchEvent{})
+		result <- res
+	}()
+	<-started
+
+	cancel()
+
+	// events sent before the runtime is closing would be accepted and
+	// wait for the in-flight event
+	deadline := time.Now().Add(time.Second)
+	for {
+		r.mu.Lock()
+		closing := r.closing
+		r.mu.Unlock()
+
+		if closing {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("runtime is not closing")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// new events are rejected while the in-flight event finishes
+	probeCtx, cancelProbe := context.WithTimeout(context.Background(), time.Second)
+	defer cancelProbe()
+
+	_, err := ctrl.Dispatch(probeCtx, &sigmaV1.DispatchEvent{})
+	if assert.Error(err) {
+		assert.Equal(ErrShuttingDown.Error(), err.Error())
+	}
+
+	select {
+	case <-done:
+		t.Fatal("runtime stopped before the in-flight event finished")
+	default:
+	}
+
+	close(release)
+	assert.Equal([]byte("done"), <-result)
+	assert.NoError(<-done)
+}
+
+func TestRuntime_ShutdownTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan struct{})
+	handler := HandlerFunc(func(ctx context.Context, e Event) ([]byte, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	_, conn, done := start(t, ctx, handler, WithShutdownTimeout(10*time.Millisecond))
+	ctrl := node.CreateController("urn:node", instance{}, conn)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := ctrl.Dispatch(context.Background(), &sigmaV1.DispatchEvent{})
+		result <- err
+	}()
+	<-started
+
+	cancel()
+
+	assert.NoError(<-done)
+	assert.Equal(context.Canceled.Error(), (<-result).Error())
+}
+
+// silentClient registers at a node server but it's subscription stream
+// only delivers the given events and never a heartbeat
+type silentClient struct {
+	*localClient
+	events chan *sigmaV1.DispatchEvent
+}
+
+func (c *silentClient) Subscribe(ctx context.Context, opts ...grpc.CallOption) (sigmaV1.NodeHandler_SubscribeClient, error) {
+	p := &pipe{
+		ctx:     ctx,
+		events:  c.events,
+		results: make(chan *sigmaV1.ExecutionResult, 10),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		<-ctx.Done()
+		p.close()
+	}()
+
+	return &clientStream{pipe: p}, nil
+}
+
+func TestRuntime_HeartbeatTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := node.NewNodeServer(node.WithHeartbeatInterval(10 * time.Millisecond))
+	if _, err := srv.Prepare("urn:node", "secret", sigma.FunctionSpec{Type: "go"}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &silentClient{
+		localClient: &localClient{srv: srv},
+		events:      make(chan *sigmaV1.DispatchEvent, 1),
+	}
+	client.events <- &sigmaV1.DispatchEvent{Id: "event"}
+
+	finished := make(chan struct{})
+	handler := HandlerFunc(func(ctx context.Context, e Event) ([]byte, error) {
+		defer close(finished)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	err := New(handler,
+		WithClient(client),
+		WithConfig(launcher.Config{URN: "urn:node", Secret: "secret", Type: "go"}),
+		WithResubscribeDelay(time.Millisecond),
+		WithShutdownTimeout(10*time.Millisecond),
+	).Run(context.Background())
+	assert.Equal(ErrHeartbeatTimeout, err)
+
+	// in-flight handlers are shut down before Run returns
+	select {
+	case <-finished:
+	default:
+		t.Fatal("handler still running after Run returned")
+	}
+}
+
+func TestParseError(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(&Error{Code: CodeUnavailable, Message: "busy"}, ParseError("unavailable: busy"))
+	assert.Equal(&Error{Code: CodeInternal, Message: "Something: went wrong"}, ParseError("Something: went wrong"))
+	assert.Equal(&Error{Code: CodeInternal, Message: "boom"}, ParseError(errors.New("boom").Error()))
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/golang/protobuf/ptypes"
+
+	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
+	"github.com/homebot/sigma/logs"
+)
+
+// Logs streams the captured output of a function. If the request asks to
+// follow the logs, new entries are streamed until the client disconnects
+func (s *Server) Logs(in *sigmaV1.LogsRequest, stream sigmaV1.Sigma_LogsServer) error {
+	if in == nil || in.GetFunction() == "" {
+		return errors.New("invalid request: missing function")
+	}
+
+	if err := authorize(stream.Context(), in.GetFunction()); err != nil {
+		return err
+	}
+
+	filter := logs.Filter{
+		Function: in.GetFunction(),
+		Node:     in.GetNode(),
+	}
+
+	if in.GetSince() != nil {
+		since, err := ptypes.Timestamp(in.GetSince())
+		if err != nil {
+			return err
+		}
+		filter.Since = since
+	}
+
+	if !in.GetFollow() {
+		for _, e := range s.logs.Query(filter) {
+			if err := stream.Send(logEntryProto(e)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	backlog, sub := s.logs.Follow(filter, logs.DefaultBufferSize)
+	defer sub.Close()
+
+	for _, e := range backlog {
+		if err := stream.Send(logEntryProto(e)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case e := <-sub.C:
+			if err := stream.Send(logEntryProto(e)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func logEntryProto(e logs.Entry) *sigmaV1.LogEntry {
+	t, _ := ptypes.TimestampProto(e.Time)
+
+	return &sigmaV1.LogEntry{
+		Time:       t,
+		Function:   e.Function,
+		Node:       e.Node,
+		Invocation: e.Invocation,
+		Stream:     e.Stream,
+		Line:       e.Line,
+	}
+}
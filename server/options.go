@@ -1,6 +1,10 @@
 package server
 
-import "github.com/homebot/idam/token"
+import (
+	"github.com/homebot/idam/token"
+	"github.com/homebot/sigma/logs"
+	"github.com/homebot/sigma/orchestrator"
+)
 
 // Option is a server option
 type Option func(s *Server) error
@@ -13,3 +17,21 @@ func WithIdamKeyProvider(f token.KeyProviderFunc) Option {
 		return nil
 	}
 }
+
+// WithOrchestrator sets the workflow orchestrator to use. Without an
+// orchestrator, all workflow methods return an error
+func WithOrchestrator(o *orchestrator.Orchestrator) Option {
+	return func(s *Server) error {
+		s.orchestrator = o
+		return nil
+	}
+}
+
+// WithLogStore sets the store function logs are read from. Defaults to
+// logs.DefaultStore
+func WithLogStore(store *logs.Store) Option {
+	return func(s *Server) error {
+		s.logs = store
+		return nil
+	}
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/golang/protobuf/ptypes/empty"
 	uuid "github.com/satori/go.uuid"
@@ -15,12 +16,21 @@ import (
 	"github.com/homebot/idam/token"
 	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
 	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/function"
+	"github.com/homebot/sigma/logs"
+	"github.com/homebot/sigma/orchestrator"
 	"github.com/homebot/sigma/scheduler"
 )
 
+// ErrPermissionDenied is returned when a caller accesses a resource outside of
+// its namespace
+var ErrPermissionDenied = errors.New("permission denied")
+
 // Server is a gRPC Sigma server and implements sigma.SigmaServer
 type Server struct {
-	scheduler scheduler.Scheduler
+	scheduler    scheduler.Scheduler
+	orchestrator *orchestrator.Orchestrator
+	logs         *logs.Store
 
 	// keyFn is used to resolve the signing certifiact/key
 	// for verifying JWTs
@@ -31,6 +41,7 @@ type Server struct {
 func NewServer(s scheduler.Scheduler, opts ...Option) (*Server, error) {
 	srv := &Server{
 		scheduler: s,
+		logs:      logs.DefaultStore,
 	}
 
 	for _, fn := range opts {
@@ -69,6 +80,20 @@ func (s *Server) Create(ctx context.Context, in *sigmaV1.CreateFunctionRequest)
 
 	spec.ID = fmt.Sprintf("%s/functions/%s", name, spec.ID)
 
+	// outputs referring to functions by ID are resolved within the
+	// namespace of the caller. Results must not be routed to functions of
+	// other namespaces
+	for idx, o := range spec.Outputs {
+		if !strings.Contains(o.Function, "/") {
+			spec.Outputs[idx].Function = fmt.Sprintf("%s/functions/%s", name, o.Function)
+			continue
+		}
+
+		if !strings.HasPrefix(o.Function, name+"/") {
+			return nil, fmt.Errorf("%s: output %s", ErrPermissionDenied, o.Function)
+		}
+	}
+
 	u, err := s.scheduler.Create(ctx, spec)
 	if err != nil {
 		return nil, err
@@ -87,7 +112,12 @@ func (s *Server) Destroy(ctx context.Context, in *sigmaV1.DestroyRequest) (*empt
 
 	u := in.GetName()
 
-	if err := s.scheduler.Destroy(ctx, u); err != nil {
+	destroy := s.scheduler.Destroy
+	if in.GetForce() {
+		destroy = s.scheduler.ForceDestroy
+	}
+
+	if err := destroy(ctx, u); err != nil {
 		return nil, err
 	}
 
@@ -104,33 +134,98 @@ func (s *Server) IsResourceOwner(resource, identity string, permissions []string
 
 // Dispatch dispatches an event to the given function and returns the result
 func (s *Server) Dispatch(ctx context.Context, in *sigmaV1.DispatchRequest) (*sigmaV1.DispatchResult, error) {
-	if in == nil || in.Event == nil {
-		return nil, errors.New("invalid request")
+	u, e, err := parseDispatchRequest(in)
+	if err != nil {
+		return nil, err
 	}
 
-	// a unique ID for the execution
-	in.Event.Id = uuid.NewV4().String()
+	node, res, err := s.scheduler.Dispatch(ctx, u, e)
+	if err != nil {
+		return nil, err
+	}
 
-	u := in.GetTarget()
+	return &sigmaV1.DispatchResult{
+		Target: u,
+		Node:   node,
+		Result: &sigmaV1.DispatchResult_Data{
+			Data: res,
+		},
+	}, nil
+}
 
-	if in.GetEvent() == nil || in.GetEvent().GetId() == "" {
-		return nil, errors.New("invalid request: event data invalid")
+// DispatchStream dispatches an event to the given function and streams
+// partial results and progress reports as they arrive. The last message
+// holds the part of the result that has not been streamed yet
+func (s *Server) DispatchStream(in *sigmaV1.DispatchRequest, stream sigmaV1.Sigma_DispatchStreamServer) error {
+	u, e, err := parseDispatchRequest(in)
+	if err != nil {
+		return err
 	}
 
-	e := sigma.NewSimpleEvent(in.GetEvent().GetId(), in.GetEvent().GetPayload())
+	if err := authorize(stream.Context(), u); err != nil {
+		return err
+	}
+
+	// if the client goes away the function still runs to completion
+	var sendErr error
+
+	forward := func(frame *sigmaV1.ExecutionResult) error {
+		if sendErr != nil {
+			return nil
+		}
+
+		msg := &sigmaV1.DispatchResult{Target: u}
+
+		switch v := frame.GetExecutionResult().(type) {
+		case *sigmaV1.ExecutionResult_Chunk:
+			msg.Result = &sigmaV1.DispatchResult_Chunk{Chunk: v.Chunk}
+		case *sigmaV1.ExecutionResult_Progress:
+			msg.Result = &sigmaV1.DispatchResult_Progress{Progress: v.Progress}
+		default:
+			return nil
+		}
+
+		sendErr = stream.Send(msg)
+		return nil
+	}
+
+	node, res, err := s.scheduler.DispatchStream(stream.Context(), u, e, forward)
+	if sendErr != nil {
+		return sendErr
+	}
 
-	node, res, err := s.scheduler.Dispatch(ctx, u, e)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &sigmaV1.DispatchResult{
+	return stream.Send(&sigmaV1.DispatchResult{
 		Target: u,
 		Node:   node,
 		Result: &sigmaV1.DispatchResult_Data{
 			Data: res,
 		},
-	}, nil
+	})
+}
+
+// parseDispatchRequest returns the target and the event of a dispatch
+// request
+func parseDispatchRequest(in *sigmaV1.DispatchRequest) (string, sigma.Event, error) {
+	if in == nil || in.Event == nil {
+		return "", nil, errors.New("invalid request")
+	}
+
+	// a unique ID for the execution
+	in.Event.Id = uuid.NewV4().String()
+
+	u := in.GetTarget()
+
+	if in.GetEvent() == nil || in.GetEvent().GetId() == "" {
+		return "", nil, errors.New("invalid request: event data invalid")
+	}
+
+	e := sigma.NewSimpleEvent(in.GetEvent().GetId(), in.GetEvent().GetPayload())
+
+	return u, e, nil
 }
 
 // Inspect inspects a function and returns details and statistics for the function
@@ -153,9 +248,10 @@ func (s *Server) Inspect(ctx context.Context, in *sigmaV1.InspectRequest) (*sigm
 	}
 
 	return &sigmaV1.Function{
-		Spec:  f.Spec.ToProtobuf(),
-		Urn:   f.Name.String(),
-		Nodes: nodes,
+		Spec:     f.Spec.ToProtobuf(),
+		Urn:      f.Name.String(),
+		Nodes:    nodes,
+		Triggers: triggersToProtobuf(f.Triggers),
 	}, nil
 }
 
@@ -181,9 +277,10 @@ func (s *Server) List(ctx context.Context, _ *empty.Empty) (*sigmaV1.ListResult,
 		}
 
 		result = append(result, &sigmaV1.Function{
-			Urn:   f.Name.String(),
-			Spec:  f.Spec.ToProtobuf(),
-			Nodes: nodes,
+			Urn:      f.Name.String(),
+			Spec:     f.Spec.ToProtobuf(),
+			Nodes:    nodes,
+			Triggers: triggersToProtobuf(f.Triggers),
 		})
 	}
 
@@ -192,5 +289,74 @@ func (s *Server) List(ctx context.Context, _ *empty.Empty) (*sigmaV1.ListResult,
 	}, nil
 }
 
+// EnableTrigger enables a trigger of a function
+func (s *Server) EnableTrigger(ctx context.Context, in *sigmaV1.TriggerRequest) (*empty.Empty, error) {
+	if in == nil || in.GetFunction() == "" || in.GetTrigger() == "" {
+		return nil, errors.New("invalid request")
+	}
+
+	if err := authorize(ctx, in.GetFunction()); err != nil {
+		return nil, err
+	}
+
+	if err := s.scheduler.EnableTrigger(ctx, in.GetFunction(), in.GetTrigger()); err != nil {
+		return nil, err
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// DisableTrigger disables a trigger of a function
+func (s *Server) DisableTrigger(ctx context.Context, in *sigmaV1.TriggerRequest) (*empty.Empty, error) {
+	if in == nil || in.GetFunction() == "" || in.GetTrigger() == "" {
+		return nil, errors.New("invalid request")
+	}
+
+	if err := authorize(ctx, in.GetFunction()); err != nil {
+		return nil, err
+	}
+
+	if err := s.scheduler.DisableTrigger(ctx, in.GetFunction(), in.GetTrigger()); err != nil {
+		return nil, err
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// namespace returns the resource namespace of the authenticated caller
+func namespace(ctx context.Context) (string, error) {
+	auth, ok := policy.TokenFromContext(ctx)
+	if !ok || auth == nil {
+		return "", errors.New("not authenticated")
+	}
+
+	return idam.ResourceName(auth.Name)
+}
+
+// authorize returns ErrPermissionDenied if the resource name is not within the
+// namespace of the authenticated caller
+func authorize(ctx context.Context, name string) error {
+	ns, err := namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(name, ns+"/") {
+		return ErrPermissionDenied
+	}
+
+	return nil
+}
+
+func triggersToProtobuf(states []function.TriggerState) []*sigmaV1.Trigger {
+	var res []*sigmaV1.Trigger
+
+	for _, t := range states {
+		res = append(res, t.ToProtobuf())
+	}
+
+	return res
+}
+
 // compile time check
 var _ sigmaV1.SigmaServer = &Server{}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/homebot/idam"
+	"github.com/homebot/idam/policy"
+	sigmaV1 "github.com/homebot/protobuf/pkg/api/sigma/v1"
+	"github.com/homebot/sigma/orchestrator"
+)
+
+var (
+	// ErrWorkflowsDisabled is returned by all workflow methods if the server
+	// does not have an orchestrator configured
+	ErrWorkflowsDisabled = errors.New("workflows are not enabled")
+)
+
+// CreateWorkflow creates or replaces a workflow. The workflow spec is
+// expected to be JSON encoded
+func (s *Server) CreateWorkflow(ctx context.Context, in *sigmaV1.CreateWorkflowRequest) (*sigmaV1.CreateWorkflowResponse, error) {
+	if s.orchestrator == nil {
+		return nil, ErrWorkflowsDisabled
+	}
+
+	auth, ok := policy.TokenFromContext(ctx)
+	if !ok {
+		return nil, errors.New("not authenticated")
+	}
+
+	if in == nil {
+		return nil, errors.New("invalid request")
+	}
+
+	var w orchestrator.Workflow
+	if err := json.Unmarshal(in.GetSpec(), &w); err != nil {
+		return nil, err
+	}
+
+	if w.ID == "" {
+		return nil, errors.New("invalid workflow spec")
+	}
+
+	name, err := idam.ResourceName(auth.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	w.ID = fmt.Sprintf("%s/workflows/%s", name, w.ID)
+
+	// functions referred to by ID are resolved within the namespace of the
+	// caller. Functions of other namespaces must not be executed
+	var denied string
+	w = w.MapFunctions(func(fn string) string {
+		if !strings.Contains(fn, "/") {
+			return fmt.Sprintf("%s/functions/%s", name, fn)
+		}
+
+		if !strings.HasPrefix(fn, name+"/") && denied == "" {
+			denied = fn
+		}
+		return fn
+	})
+
+	if denied != "" {
+		return nil, fmt.Errorf("%s: function %s", ErrPermissionDenied, denied)
+	}
+
+	if err := s.orchestrator.Register(w); err != nil {
+		return nil, err
+	}
+
+	return &sigmaV1.CreateWorkflowResponse{
+		Name: w.ID,
+	}, nil
+}
+
+// RunWorkflow starts a new execution of a workflow
+func (s *Server) RunWorkflow(ctx context.Context, in *sigmaV1.RunWorkflowRequest) (*sigmaV1.RunWorkflowResponse, error) {
+	if s.orchestrator == nil {
+		return nil, ErrWorkflowsDisabled
+	}
+
+	if in == nil || in.GetName() == "" {
+		return nil, errors.New("invalid request")
+	}
+
+	if err := authorize(ctx, in.GetName()); err != nil {
+		return nil, err
+	}
+
+	id, err := s.orchestrator.Run(in.GetName(), in.GetPayload())
+	if err != nil {
+		return nil, err
+	}
+
+	return &sigmaV1.RunWorkflowResponse{
+		Execution: id,
+	}, nil
+}
+
+// InspectExecution returns the state of a workflow execution and all of it's
+// steps
+func (s *Server) InspectExecution(ctx context.Context, in *sigmaV1.InspectExecutionRequest) (*sigmaV1.WorkflowExecution, error) {
+	if s.orchestrator == nil {
+		return nil, ErrWorkflowsDisabled
+	}
+
+	if in == nil {
+		return nil, errors.New("invalid request")
+	}
+
+	e, err := s.orchestrator.Execution(in.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	// executions hold the input and output of all steps so they are only
+	// returned to callers that may run the workflow
+	if err := authorize(ctx, e.Workflow); err != nil {
+		return nil, err
+	}
+
+	return e.ToProtobuf(), nil
+}
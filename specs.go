@@ -1,12 +1,29 @@
 package sigma
 
 import (
-	"github.com/homebot/core/utils"
 	"github.com/homebot/protobuf/pkg/api/sigma/v1"
 )
 
+// BatchSpec configures how trigger events are combined into batches. At
+// least one of MaxEvents or MaxWait must be set
+type BatchSpec struct {
+	// MaxEvents is the maximum number of events within a batch
+	MaxEvents int `json:"maxEvents" yaml:"maxEvents"`
+
+	// MaxWait is the maximum duration (e.g. "5s") a batch is kept open after
+	// it's first event
+	MaxWait string `json:"maxWait" yaml:"maxWait"`
+
+	// MaxBytes is the maximum size of all event payloads within a batch
+	MaxBytes int `json:"maxBytes" yaml:"maxBytes"`
+}
+
 // TriggerSpec describes a sigma function trigger
 type TriggerSpec struct {
+	// Name is the name of the trigger and must be unique within a function.
+	// If empty, a name is derived from the trigger type
+	Name string `json:"name" yaml:"name"`
+
 	// Type is the type of trigger to build
 	Type string `json:"type" yaml:"type"`
 
@@ -14,26 +31,119 @@ type TriggerSpec struct {
 	// event before the function is triggered
 	Condition string `json:"when" yaml:"when"`
 
+	// Transform holds an optional Go template that is used to reshape the
+	// event payload before it is dispatched to the function
+	Transform string `json:"transform" yaml:"transform"`
+
 	// Options holds additional options for building the trigger
 	Options map[string]string `json:"options" yaml:"options"`
+
+	// Batch configures batching of trigger events. If set, the function
+	// receives a JSON array of event payloads
+	Batch *BatchSpec `json:"batch" yaml:"batch"`
+
+	// RateLimit limits the number of events dispatched per time unit using a
+	// token bucket (e.g. "10/s" or "100/m"). Events exceeding the limit are
+	// dropped
+	RateLimit string `json:"rateLimit" yaml:"rateLimit"`
+
+	// Debounce delays events until the trigger has been quiet for the given
+	// duration (e.g. "500ms") and only dispatches the last one
+	Debounce string `json:"debounce" yaml:"debounce"`
+
+	// Throttle dispatches the first event and drops all further events for
+	// the given duration
+	Throttle string `json:"throttle" yaml:"throttle"`
 }
 
 // ToProtobuf converts the trigger spec to it's protocol buffer representation
 func (t TriggerSpec) ToProtobuf() *sigma.TriggerSpec {
-	return &sigma.TriggerSpec{
+	res := &sigma.TriggerSpec{
+		Name:      t.Name,
 		Type:      t.Type,
 		Condition: t.Condition,
+		Transform: t.Transform,
 		Options:   t.Options,
+		RateLimit: t.RateLimit,
+		Debounce:  t.Debounce,
+		Throttle:  t.Throttle,
+	}
+
+	if t.Batch != nil {
+		res.Batch = &sigma.BatchSpec{
+			MaxEvents: int64(t.Batch.MaxEvents),
+			MaxWait:   t.Batch.MaxWait,
+			MaxBytes:  int64(t.Batch.MaxBytes),
+		}
 	}
+
+	return res
 }
 
 // TriggerSpecFromProtobuf creates a trigger spec from it's protocol buffer
 // representation
 func TriggerSpecFromProtobuf(t *sigma.TriggerSpec) TriggerSpec {
-	return TriggerSpec{
+	res := TriggerSpec{
+		Name:      t.GetName(),
 		Type:      t.GetType(),
 		Options:   t.GetOptions(),
 		Condition: t.GetCondition(),
+		Transform: t.GetTransform(),
+		RateLimit: t.GetRateLimit(),
+		Debounce:  t.GetDebounce(),
+		Throttle:  t.GetThrottle(),
+	}
+
+	if b := t.GetBatch(); b != nil {
+		res.Batch = &BatchSpec{
+			MaxEvents: int(b.GetMaxEvents()),
+			MaxWait:   b.GetMaxWait(),
+			MaxBytes:  int(b.GetMaxBytes()),
+		}
+	}
+
+	return res
+}
+
+const (
+	// OutputOnResult routes successful results to the output function
+	OutputOnResult = "result"
+
+	// OutputOnError routes execution errors to the output function
+	OutputOnError = "error"
+)
+
+// OutputSpec describes a route from the result of a function to another
+// function
+type OutputSpec struct {
+	// Function is the ID of the function the output is routed to
+	Function string `json:"function" yaml:"function"`
+
+	// On selects whether the result (OutputOnResult) or the error
+	// (OutputOnError) of the function is routed. Defaults to OutputOnResult
+	On string `json:"on" yaml:"on"`
+
+	// Condition holds a govaluate expression that is evaluated against the
+	// output event before it is routed
+	Condition string `json:"when" yaml:"when"`
+}
+
+// ToProtobuf converts the output spec to it's protocol buffer representation
+func (o OutputSpec) ToProtobuf() *sigma.OutputSpec {
+	return &sigma.OutputSpec{
+		Function:  o.Function,
+		On:        o.On,
+		Condition: o.Condition,
+	}
+}
+
+// OutputSpecFromProtobuf creates an output spec from it's protocol buffer
+// representation
+func OutputSpecFromProtobuf(o *sigma.OutputSpec) OutputSpec {
+	return OutputSpec{
+		Function:  o.GetFunction(),
+		On:        o.GetOn(),
+		Condition: o.GetCondition(),
 	}
 }
 
@@ -56,7 +166,10 @@ type FunctionSpec struct {
 	Triggers []TriggerSpec `json:"triggers" yaml:"triggers"`
 
 	// Parameters may hold optional parameters for the function
-	Parameteres utils.ValueMap `json:"parameters" yaml:"parameters"`
+	Parameteres ValueMap `json:"parameters" yaml:"parameters"`
+
+	// Outputs routes the results of the function to other functions
+	Outputs []OutputSpec `json:"outputs" yaml:"outputs"`
 }
 
 // TriggersToProtobuf converts a slice or array of triggers to their
@@ -83,6 +196,30 @@ func TriggersFromProtobuf(t []*sigma.TriggerSpec) []TriggerSpec {
 	return res
 }
 
+// OutputsToProtobuf converts a slice of outputs to their protocol buffer
+// representation
+func OutputsToProtobuf(o []OutputSpec) []*sigma.OutputSpec {
+	var res []*sigma.OutputSpec
+
+	for _, output := range o {
+		res = append(res, output.ToProtobuf())
+	}
+
+	return res
+}
+
+// OutputsFromProtobuf converts a slice of protocol buffer outputs to a slice
+// of OutputSpec
+func OutputsFromProtobuf(o []*sigma.OutputSpec) []OutputSpec {
+	var res []OutputSpec
+
+	for _, output := range o {
+		res = append(res, OutputSpecFromProtobuf(output))
+	}
+
+	return res
+}
+
 // PoliciesToProtobuf creates a list of protocol buffer policy definitions
 func PoliciesToProtobuf(policies map[string]map[string]string) []*sigma.Policy {
 	var p []*sigma.Policy
@@ -118,6 +255,7 @@ func (spec FunctionSpec) ToProtobuf() *sigma.FunctionSpec {
 		Content:    []byte(spec.Content),
 		Triggers:   TriggersToProtobuf(spec.Triggers),
 		Parameters: spec.Parameteres.ToProto(),
+		Outputs:    OutputsToProtobuf(spec.Outputs),
 	}
 }
 
@@ -130,6 +268,7 @@ func SpecFromProto(in *sigma.FunctionSpec) FunctionSpec {
 		Policies:    ProtobufToPolicies(in.GetPolicies()),
 		Content:     string(in.GetContent()),
 		Triggers:    TriggersFromProtobuf(in.GetTriggers()),
-		Parameteres: utils.ValueMapFrom(in.GetParameters()),
+		Parameteres: ValueMapFrom(in.GetParameters()),
+		Outputs:     OutputsFromProtobuf(in.GetOutputs()),
 	}
 }
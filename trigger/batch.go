@@ -0,0 +1,174 @@
+package trigger
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/homebot/sigma"
+)
+
+var (
+	// ErrUnboundedBatch is returned when batch options would never flush a
+	// batch
+	ErrUnboundedBatch = errors.New("batch requires either maxEvents or maxWait")
+)
+
+// BatchOptions configures how events are combined into batches
+type BatchOptions struct {
+	// MaxEvents is the maximum number of events within a batch
+	MaxEvents int
+
+	// MaxWait is the maximum duration a batch is kept open after the first
+	// event has been added
+	MaxWait time.Duration
+
+	// MaxBytes is the maximum size of all payloads within a batch
+	MaxBytes int
+}
+
+// Valid checks if the batch options are valid
+func (o BatchOptions) Valid() error {
+	if o.MaxEvents < 0 || o.MaxWait < 0 || o.MaxBytes < 0 {
+		return errors.New("batch limits must not be negative")
+	}
+
+	if o.MaxEvents == 0 && o.MaxWait == 0 {
+		return ErrUnboundedBatch
+	}
+
+	return nil
+}
+
+// Batcher combines events into batches and passes each batch to a flush
+// function. The payload of a batch is a JSON array holding the payload of
+// each event. Payloads that are not valid JSON are added as strings. The type
+// of a batch is the type of it's first event. If events of a batch implement
+// Replier, the batch does as well and passes the result to all of them
+type Batcher struct {
+	opts  BatchOptions
+	flush func(sigma.Event)
+
+	mu       sync.Mutex
+	typ      string
+	payloads []json.RawMessage
+	repliers multiReplier
+	size     int
+	timer    *time.Timer
+
+	// generation is incremented for each batch so a timer that already
+	// fired does not flush the next batch
+	generation int
+}
+
+// NewBatcher returns a new batcher that calls flush for each batch
+func NewBatcher(opts BatchOptions, flush func(sigma.Event)) *Batcher {
+	return &Batcher{
+		opts:  opts,
+		flush: flush,
+	}
+}
+
+// Add adds an event to the current batch. If the batch is full, it is
+// flushed
+func (b *Batcher) Add(evt sigma.Event) {
+	payload := encodePayload(evt.Payload())
+
+	var full []sigma.Event
+
+	b.mu.Lock()
+
+	// flush the current batch first if the event would exceed the size
+	// limit
+	if b.opts.MaxBytes > 0 && len(b.payloads) > 0 && b.size+len(payload) > b.opts.MaxBytes {
+		full = append(full, b.take())
+	}
+
+	if len(b.payloads) == 0 {
+		b.typ = evt.Type()
+
+		if b.opts.MaxWait > 0 {
+			generation := b.generation
+			b.timer = time.AfterFunc(b.opts.MaxWait, func() {
+				b.flushGeneration(generation)
+			})
+		}
+	}
+
+	b.payloads = append(b.payloads, payload)
+	b.size += len(payload)
+
+	if r, ok := evt.(Replier); ok {
+		b.repliers = append(b.repliers, r)
+	}
+
+	if (b.opts.MaxEvents > 0 && len(b.payloads) >= b.opts.MaxEvents) ||
+		(b.opts.MaxBytes > 0 && b.size >= b.opts.MaxBytes) {
+		full = append(full, b.take())
+	}
+
+	b.mu.Unlock()
+
+	for _, batch := range full {
+		b.flush(batch)
+	}
+}
+
+// Flush flushes the current batch, if any
+func (b *Batcher) Flush() {
+	b.mu.Lock()
+	generation := b.generation
+	b.mu.Unlock()
+
+	b.flushGeneration(generation)
+}
+
+func (b *Batcher) flushGeneration(generation int) {
+	b.mu.Lock()
+
+	if len(b.payloads) == 0 || generation != b.generation {
+		b.mu.Unlock()
+		return
+	}
+
+	batch := b.take()
+	b.mu.Unlock()
+
+	b.flush(batch)
+}
+
+// take returns the current batch as an event and resets the batcher. The
+// caller must hold the batcher lock
+func (b *Batcher) take() sigma.Event {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+
+	blob, _ := json.Marshal(b.payloads)
+	evt := sigma.NewSimpleEvent(b.typ, blob)
+
+	if len(b.repliers) > 0 {
+		evt = WithReplier(evt, b.repliers)
+	}
+
+	b.payloads = nil
+	b.repliers = nil
+	b.size = 0
+	b.typ = ""
+	b.generation++
+
+	return evt
+}
+
+// encodePayload returns payload as raw JSON or as a JSON string if it's not
+// valid JSON
+func encodePayload(payload []byte) json.RawMessage {
+	if len(payload) > 0 && json.Valid(payload) {
+		return json.RawMessage(payload)
+	}
+
+	blob, _ := json.Marshal(string(payload))
+	return json.RawMessage(blob)
+}
@@ -0,0 +1,113 @@
+package trigger
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/homebot/sigma"
+	"github.com/stretchr/testify/assert"
+)
+
+type batchRecorder struct {
+	mu      sync.Mutex
+	batches []sigma.Event
+}
+
+func (r *batchRecorder) flush(evt sigma.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.batches = append(r.batches, evt)
+}
+
+func (r *batchRecorder) get() []sigma.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]sigma.Event(nil), r.batches...)
+}
+
+func TestBatchOptions_Valid(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(ErrUnboundedBatch, BatchOptions{}.Valid())
+	assert.Equal(ErrUnboundedBatch, BatchOptions{MaxBytes: 10}.Valid())
+	assert.Error(BatchOptions{MaxEvents: -1}.Valid())
+	assert.NoError(BatchOptions{MaxEvents: 1}.Valid())
+	assert.NoError(BatchOptions{MaxWait: time.Second}.Valid())
+}
+
+func TestBatcher_MaxEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	r := &batchRecorder{}
+	b := NewBatcher(BatchOptions{MaxEvents: 2}, r.flush)
+
+	b.Add(sigma.NewSimpleEvent("a", []byte(`{"n": 1}`)))
+	assert.Len(r.get(), 0)
+
+	b.Add(sigma.NewSimpleEvent("b", []byte(`not json`)))
+	b.Add(sigma.NewSimpleEvent("c", []byte(`3`)))
+
+	batches := r.get()
+	if assert.Len(batches, 1) {
+		assert.Equal("a", batches[0].Type())
+		assert.JSONEq(`[{"n": 1}, "not json"]`, string(batches[0].Payload()))
+	}
+
+	b.Flush()
+	batches = r.get()
+	if assert.Len(batches, 2) {
+		assert.Equal("c", batches[1].Type())
+		assert.JSONEq(`[3]`, string(batches[1].Payload()))
+	}
+
+	// flushing an empty batch is a no-op
+	b.Flush()
+	assert.Len(r.get(), 2)
+}
+
+func TestBatcher_MaxBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	r := &batchRecorder{}
+	b := NewBatcher(BatchOptions{MaxEvents: 10, MaxBytes: 8}, r.flush)
+
+	b.Add(sigma.NewSimpleEvent("a", []byte(`"abc"`)))
+	b.Add(sigma.NewSimpleEvent("a", []byte(`"def"`)))
+
+	// the second event would exceed the limit so the first batch is
+	// flushed before adding it
+	batches := r.get()
+	if assert.Len(batches, 1) {
+		assert.JSONEq(`["abc"]`, string(batches[0].Payload()))
+	}
+
+	b.Add(sigma.NewSimpleEvent("a", []byte(`"g"`)))
+	batches = r.get()
+	if assert.Len(batches, 2) {
+		assert.JSONEq(`["def", "g"]`, string(batches[1].Payload()))
+	}
+}
+
+func TestBatcher_MaxWait(t *testing.T) {
+	assert := assert.New(t)
+
+	r := &batchRecorder{}
+	b := NewBatcher(BatchOptions{MaxWait: 10 * time.Millisecond}, r.flush)
+
+	b.Add(sigma.NewSimpleEvent("a", []byte(`1`)))
+	b.Add(sigma.NewSimpleEvent("a", []byte(`2`)))
+	assert.Len(r.get(), 0)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(r.get()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	batches := r.get()
+	if assert.Len(batches, 1) {
+		assert.JSONEq(`[1, 2]`, string(batches[0].Payload()))
+	}
+}
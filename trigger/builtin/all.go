@@ -2,5 +2,11 @@ package builtin
 
 import (
 	// Import all built-in triggers
+	_ "github.com/homebot/sigma/trigger/builtin/astro"
+	_ "github.com/homebot/sigma/trigger/builtin/fswatch"
+	_ "github.com/homebot/sigma/trigger/builtin/lifecycle"
+	_ "github.com/homebot/sigma/trigger/builtin/nats"
+	_ "github.com/homebot/sigma/trigger/builtin/poll"
+	_ "github.com/homebot/sigma/trigger/builtin/syslog"
 	_ "github.com/homebot/sigma/trigger/builtin/timer"
 )
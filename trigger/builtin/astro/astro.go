@@ -0,0 +1,290 @@
+package astro
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/trigger"
+)
+
+var (
+	// ErrMissingPosition is returned when the `latitude` or `longitude`
+	// configuration keys are missing during Build()
+	ErrMissingPosition = errors.New("missing `latitude` or `longitude` configuration key")
+
+	// ErrMissingEvents is returned when the `events` configuration key is
+	// missing during Build()
+	ErrMissingEvents = errors.New("missing `events` configuration key")
+
+	// ErrUnknownEvent is returned when the `events` configuration key
+	// contains an unsupported sun event
+	ErrUnknownEvent = errors.New("unknown sun event in `events`")
+
+	// ErrUnknownWeekday is returned when the `days` configuration key
+	// contains an invalid weekday
+	ErrUnknownWeekday = errors.New("unknown weekday in `days`")
+
+	// ErrNoUpcomingEvent is returned from Next() if none of the configured
+	// events occurs within the next year at the configured position
+	ErrNoUpcomingEvent = errors.New("no upcoming sun event")
+)
+
+// weekdays maps the names supported by the `days` configuration key to
+// their weekday
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Astro is a trigger.Trigger that fires at sun events like sunrise or
+// sunset at a given position
+type Astro struct {
+	latitude  float64
+	longitude float64
+	events    []string
+	offset    time.Duration
+	days      map[time.Weekday]bool
+	location  *time.Location
+
+	// now returns the current time and may be replaced in tests
+	now func() time.Time
+
+	// last is the time the trigger fired last
+	last time.Time
+
+	closed chan struct{}
+}
+
+// URN returns the URN for the astro trigger
+func (a *Astro) URN() string { return "astro" }
+
+// Close closes the astro trigger
+func (a *Astro) Close() error {
+	select {
+	case <-a.closed:
+		return errors.New("already closed")
+	default:
+		close(a.closed)
+	}
+
+	return nil
+}
+
+// Next waits for the next sun event and returns it as a sigma event
+func (a *Astro) Next() (sigma.Event, error) {
+	after := a.now()
+	if a.last.After(after) {
+		after = a.last
+	}
+
+	event, at, fire, ok := a.next(after)
+	if !ok {
+		return nil, trigger.Permanent(ErrNoUpcomingEvent)
+	}
+
+	timer := time.NewTimer(fire.Sub(a.now()))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-a.closed:
+		return nil, io.EOF
+	}
+
+	a.last = fire
+
+	blob, _ := json.Marshal(map[string]interface{}{
+		"event":     event,
+		"time":      at.In(a.location).Format(time.RFC3339),
+		"scheduled": fire.In(a.location).Format(time.RFC3339),
+		"offset":    a.offset.String(),
+	})
+
+	return sigma.NewSimpleEvent("astro", blob), nil
+}
+
+// next returns the first configured event that fires after the given time,
+// the time of the sun event itself and the time the trigger fires
+// (including the offset)
+func (a *Astro) next(after time.Time) (string, time.Time, time.Time, bool) {
+	var (
+		event    string
+		at, fire time.Time
+		found    = -1
+	)
+
+	// start one day early as offsets may move an event to the previous day.
+	// Searching a whole year makes sure to find events during polar day or
+	// night
+	day := after.In(a.location).AddDate(0, 0, -1)
+
+	for i := 0; i < 368; i++ {
+		for _, name := range a.events {
+			t, ok := SunTime(name, day, a.latitude, a.longitude)
+			if !ok {
+				continue
+			}
+
+			f := t.Add(a.offset)
+			if !f.After(after) {
+				continue
+			}
+
+			if a.days != nil && !a.days[f.In(a.location).Weekday()] {
+				continue
+			}
+
+			if fire.IsZero() || f.Before(fire) {
+				event, at, fire = name, t, f
+			}
+		}
+
+		// an event of the following day may still fire earlier if it has
+		// a large negative offset
+		if found < 0 && !fire.IsZero() {
+			found = i
+		}
+
+		if found >= 0 && i > found {
+			break
+		}
+
+		day = day.AddDate(0, 0, 1)
+	}
+
+	return event, at, fire, !fire.IsZero()
+}
+
+func parseDays(s string) (map[time.Weekday]bool, error) {
+	days := make(map[time.Weekday]bool)
+
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		if parts := strings.SplitN(name, "-", 2); len(parts) == 2 {
+			from, ok1 := weekdays[parts[0]]
+			to, ok2 := weekdays[parts[1]]
+			if !ok1 || !ok2 {
+				return nil, fmt.Errorf("%s: %q", ErrUnknownWeekday, name)
+			}
+
+			for d := from; ; d = (d + 1) % 7 {
+				days[d] = true
+				if d == to {
+					break
+				}
+			}
+			continue
+		}
+
+		d, ok := weekdays[name]
+		if !ok {
+			return nil, fmt.Errorf("%s: %q", ErrUnknownWeekday, name)
+		}
+		days[d] = true
+	}
+
+	if len(days) == 0 {
+		return nil, nil
+	}
+
+	return days, nil
+}
+
+// Factory is a trigger.Factory for astro triggers
+type Factory struct{}
+
+// Build builds a new astro trigger and implements trigger.Factory
+//
+// Supported options:
+//
+//	latitude   - the latitude of the position in degrees (required)
+//	longitude  - the longitude of the position in degrees, east is positive (required)
+//	events     - comma separated list of sunrise, sunset, noon, civilDawn,
+//	             civilDusk, nauticalDawn, nauticalDusk, astronomicalDawn and
+//	             astronomicalDusk (required)
+//	offset     - a duration added to each event, e.g. "-30m" (default: 0)
+//	days       - comma separated list of weekdays or ranges, e.g. "mon-fri,sun"
+//	             (default: all)
+//	timezone   - the timezone used for weekdays and the event payload
+//	             (default: local)
+//
+// All events are calculated locally without any network access
+func (f Factory) Build(opts map[string]string) (trigger.Trigger, error) {
+	latS, ok1 := opts["latitude"]
+	lonS, ok2 := opts["longitude"]
+	if !ok1 || !ok2 {
+		return nil, ErrMissingPosition
+	}
+
+	a := &Astro{
+		location: time.Local,
+		now:      time.Now,
+		closed:   make(chan struct{}),
+	}
+
+	var err error
+
+	if a.latitude, err = strconv.ParseFloat(latS, 64); err != nil || a.latitude < -90 || a.latitude > 90 {
+		return nil, fmt.Errorf("invalid latitude %q", latS)
+	}
+
+	if a.longitude, err = strconv.ParseFloat(lonS, 64); err != nil || a.longitude < -180 || a.longitude > 180 {
+		return nil, fmt.Errorf("invalid longitude %q", lonS)
+	}
+
+	for _, name := range strings.Split(opts["events"], ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		if _, ok := sunEvents[name]; !ok && name != Noon {
+			return nil, fmt.Errorf("%s: %q", ErrUnknownEvent, name)
+		}
+
+		a.events = append(a.events, name)
+	}
+
+	if len(a.events) == 0 {
+		return nil, ErrMissingEvents
+	}
+
+	if s, ok := opts["offset"]; ok {
+		if a.offset, err = time.ParseDuration(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s, ok := opts["days"]; ok {
+		if a.days, err = parseDays(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s, ok := opts["timezone"]; ok {
+		if a.location, err = time.LoadLocation(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+func init() {
+	trigger.Register("astro", &Factory{})
+}
@@ -0,0 +1,144 @@
+package astro
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/homebot/sigma/trigger"
+	"github.com/stretchr/testify/assert"
+)
+
+func build(t *testing.T, opts map[string]string) *Astro {
+	opts["timezone"] = "UTC"
+
+	tr, err := Factory{}.Build(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tr.(*Astro)
+}
+
+func assertNear(t *testing.T, expected, actual time.Time) {
+	if d := actual.Sub(expected); d > 3*time.Minute || d < -3*time.Minute {
+		t.Errorf("expected %s but got %s", expected, actual)
+	}
+}
+
+func TestSunTime(t *testing.T) {
+	assert := assert.New(t)
+
+	day := time.Date(2018, 12, 21, 0, 0, 0, 0, time.UTC)
+
+	// Greenwich, values from the NOAA solar calculator
+	for event, expected := range map[string]time.Time{
+		Sunrise:      time.Date(2018, 12, 21, 8, 4, 0, 0, time.UTC),
+		Noon:         time.Date(2018, 12, 21, 11, 58, 0, 0, time.UTC),
+		Sunset:       time.Date(2018, 12, 21, 15, 53, 0, 0, time.UTC),
+		CivilDusk:    time.Date(2018, 12, 21, 16, 34, 0, 0, time.UTC),
+		NauticalDusk: time.Date(2018, 12, 21, 17, 17, 0, 0, time.UTC),
+	} {
+		at, ok := SunTime(event, day, 51.4769, 0)
+		assert.True(ok)
+		assertNear(t, expected, at)
+	}
+
+	// no sunset during polar day
+	_, ok := SunTime(Sunset, time.Date(2018, 6, 21, 0, 0, 0, 0, time.UTC), 69.65, 18.96)
+	assert.False(ok)
+}
+
+func TestFactory_Invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, opts := range []map[string]string{
+		{"events": "sunset"},
+		{"latitude": "91", "longitude": "0", "events": "sunset"},
+		{"latitude": "0", "longitude": "0"},
+		{"latitude": "0", "longitude": "0", "events": "moonrise"},
+		{"latitude": "0", "longitude": "0", "events": "sunset", "days": "mon-funday"},
+		{"latitude": "0", "longitude": "0", "events": "sunset", "offset": "soon"},
+	} {
+		_, err := Factory{}.Build(opts)
+		assert.Error(err, "%v", opts)
+	}
+}
+
+func TestAstro_Next(t *testing.T) {
+	assert := assert.New(t)
+
+	// Friday morning in Greenwich
+	now := time.Date(2018, 12, 21, 10, 0, 0, 0, time.UTC)
+
+	a := build(t, map[string]string{
+		"latitude":  "51.4769",
+		"longitude": "0",
+		"events":    "civilDusk, sunset",
+	})
+
+	event, at, fire, ok := a.next(now)
+	assert.True(ok)
+	assert.Equal(Sunset, event)
+	assert.Equal(at, fire)
+	assertNear(t, time.Date(2018, 12, 21, 15, 53, 0, 0, time.UTC), fire)
+
+	// 30 minutes before sunset on weekends only
+	a = build(t, map[string]string{
+		"latitude":  "51.4769",
+		"longitude": "0",
+		"events":    "sunset",
+		"offset":    "-30m",
+		"days":      "sat-sun",
+	})
+
+	event, at, fire, ok = a.next(now)
+	assert.True(ok)
+	assert.Equal(-30*time.Minute, fire.Sub(at))
+	assert.Equal(time.Saturday, fire.Weekday())
+	assertNear(t, time.Date(2018, 12, 22, 15, 24, 0, 0, time.UTC), fire)
+
+	// the first sunset after polar day in Tromsø
+	a = build(t, map[string]string{
+		"latitude":  "69.65",
+		"longitude": "18.96",
+		"events":    "sunset",
+	})
+
+	_, _, fire, ok = a.next(time.Date(2018, 6, 21, 0, 0, 0, 0, time.UTC))
+	assert.True(ok)
+	assert.Equal(time.July, fire.Month())
+}
+
+func TestAstro_Fire(t *testing.T) {
+	assert := assert.New(t)
+
+	a := build(t, map[string]string{
+		"latitude":  "48.2082",
+		"longitude": "16.3738",
+		"events":    "noon",
+	})
+
+	noon, _ := SunTime(Noon, time.Date(2018, 6, 21, 0, 0, 0, 0, time.UTC), a.latitude, a.longitude)
+	a.now = func() time.Time { return noon.Add(-10 * time.Millisecond) }
+
+	evt, err := a.Next()
+	if !assert.NoError(err) {
+		return
+	}
+
+	var payload map[string]string
+	assert.NoError(json.Unmarshal(evt.Payload(), &payload))
+	assert.Equal("astro", evt.Type())
+	assert.Equal(Noon, payload["event"])
+	assert.Equal(noon.Format(time.RFC3339), payload["time"])
+
+	// the same event does not fire twice
+	_, _, fire, _ := a.next(a.last)
+	assert.True(fire.After(noon.Add(23 * time.Hour)))
+
+	assert.NoError(a.Close())
+	_, err = a.Next()
+	assert.Error(err)
+	assert.False(trigger.IsPermanent(err))
+}
@@ -0,0 +1,96 @@
+package astro
+
+import (
+	"math"
+	"time"
+)
+
+// Sun events supported by the astro trigger
+const (
+	Sunrise          = "sunrise"
+	Sunset           = "sunset"
+	Noon             = "noon"
+	CivilDawn        = "civilDawn"
+	CivilDusk        = "civilDusk"
+	NauticalDawn     = "nauticalDawn"
+	NauticalDusk     = "nauticalDusk"
+	AstronomicalDawn = "astronomicalDawn"
+	AstronomicalDusk = "astronomicalDusk"
+)
+
+// sunEvent describes a sun event by the elevation of the sun's center and
+// whether the sun is rising or setting
+type sunEvent struct {
+	elevation float64
+	rising    bool
+}
+
+// sunEvents holds all sun events except for noon. The elevation for sunrise
+// and sunset accounts for atmospheric refraction and the sun's radius
+var sunEvents = map[string]sunEvent{
+	Sunrise:          {-0.833, true},
+	Sunset:           {-0.833, false},
+	CivilDawn:        {-6, true},
+	CivilDusk:        {-6, false},
+	NauticalDawn:     {-12, true},
+	NauticalDusk:     {-12, false},
+	AstronomicalDawn: {-18, true},
+	AstronomicalDusk: {-18, false},
+}
+
+// j2000 is the julian date of 2000-01-01T12:00:00Z
+const j2000 = 2451545.0
+
+func sin(deg float64) float64 { return math.Sin(deg * math.Pi / 180) }
+func cos(deg float64) float64 { return math.Cos(deg * math.Pi / 180) }
+
+func fromJulian(j float64) time.Time {
+	secs := (j - 2440587.5) * 86400
+	return time.Unix(0, int64(secs*float64(time.Second))).UTC()
+}
+
+// SunTime calculates the time of a sun event on the given date at the given
+// position using the sunrise equation. Only the year, month and day of date
+// are used. The result is accurate to about one minute. It returns false if
+// the event does not occur on that day (e.g. no sunset during polar day)
+func SunTime(event string, date time.Time, latitude, longitude float64) (time.Time, bool) {
+	y, m, d := date.Date()
+	noonUTC := time.Date(y, m, d, 12, 0, 0, 0, time.UTC)
+
+	// mean solar time for the longitude (east is positive)
+	n := math.Floor(noonUTC.Sub(fromJulian(j2000)).Hours()/24 + 0.5)
+	jStar := n - longitude/360
+
+	// solar mean anomaly, equation of the center and ecliptic longitude
+	M := math.Mod(357.5291+0.98560028*jStar, 360)
+	C := 1.9148*sin(M) + 0.02*sin(2*M) + 0.0003*sin(3*M)
+	lambda := math.Mod(M+C+180+102.9372, 360)
+
+	transit := j2000 + jStar + 0.0053*sin(M) - 0.0069*sin(2*lambda)
+
+	if event == Noon {
+		return fromJulian(transit), true
+	}
+
+	e, ok := sunEvents[event]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	// declination of the sun and the hour angle for the elevation
+	sinDecl := sin(lambda) * sin(23.44)
+	cosDecl := math.Cos(math.Asin(sinDecl))
+
+	cosHourAngle := (sin(e.elevation) - sin(latitude)*sinDecl) / (cos(latitude) * cosDecl)
+	if cosHourAngle < -1 || cosHourAngle > 1 {
+		return time.Time{}, false
+	}
+
+	hourAngle := math.Acos(cosHourAngle) * 180 / math.Pi
+
+	if e.rising {
+		return fromJulian(transit - hourAngle/360), true
+	}
+
+	return fromJulian(transit + hourAngle/360), true
+}
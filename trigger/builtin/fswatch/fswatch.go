@@ -0,0 +1,464 @@
+package fswatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/trigger"
+)
+
+var (
+	// ErrMissingPath is returned when the `path` configuration key is missing
+	// during Build()
+	ErrMissingPath = errors.New("missing `path` configuration key")
+
+	// ErrUnknownOperation is returned when the `events` configuration key
+	// contains an unsupported operation
+	ErrUnknownOperation = errors.New("unknown operation in `events`")
+
+	// ErrPathNotAllowed is returned when the `path` configuration key is not
+	// within one of the allowed paths of the factory
+	ErrPathNotAllowed = errors.New("path is not allowed")
+)
+
+// operations maps the names supported by the `events` configuration key to
+// their inotify (fsnotify) operation
+var operations = map[string]fsnotify.Op{
+	"create": fsnotify.Create,
+	"write":  fsnotify.Write,
+	"remove": fsnotify.Remove,
+	"rename": fsnotify.Rename,
+}
+
+// Watch is a trigger.Trigger that fires when files matching a glob pattern
+// are created, written, removed or renamed. The event payload is a JSON
+// object with the following keys:
+//
+//	path       - the path of the file
+//	op         - one of create, write, remove or rename
+//	size       - the size of the file (not set for remove and rename)
+//	content    - the base64 encoded file content if maxContentSize is set
+//	truncated  - true if content has been truncated to maxContentSize
+type Watch struct {
+	watcher *fsnotify.Watcher
+
+	pattern        string
+	recursive      bool
+	ops            fsnotify.Op
+	debounce       time.Duration
+	maxContentSize int64
+
+	mu      sync.Mutex
+	pending map[string]*pendingEvent
+
+	events chan sigma.Event
+	errors chan error
+	closed chan struct{}
+	wg     sync.WaitGroup
+}
+
+type pendingEvent struct {
+	op    fsnotify.Op
+	timer *time.Timer
+}
+
+// URN returns the URN for the filesystem watcher
+func (w *Watch) URN() string { return "fswatch" }
+
+// Close closes the filesystem watcher
+func (w *Watch) Close() error {
+	select {
+	case <-w.closed:
+		return errors.New("already closed")
+	default:
+		close(w.closed)
+	}
+
+	err := w.watcher.Close()
+
+	w.mu.Lock()
+	for path, p := range w.pending {
+		p.timer.Stop()
+		delete(w.pending, path)
+	}
+	w.mu.Unlock()
+
+	w.wg.Wait()
+
+	return err
+}
+
+// Next waits for the next filesystem event and returns it as a
+// sigma event
+func (w *Watch) Next() (sigma.Event, error) {
+	select {
+	case evt := <-w.events:
+		return evt, nil
+	case err := <-w.errors:
+		return nil, err
+	case <-w.closed:
+		return nil, io.EOF
+	}
+}
+
+func (w *Watch) watch() {
+	defer w.wg.Done()
+
+	for {
+		select {
+		case evt, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			w.handle(evt)
+
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+
+			select {
+			case w.errors <- err:
+			case <-w.closed:
+				return
+			}
+
+		case <-w.closed:
+			return
+		}
+	}
+}
+
+func (w *Watch) handle(evt fsnotify.Event) {
+	// Directories created below a recursive watch must be watched as well
+	// as inotify does not support recursive watches on it's own
+	if w.recursive && evt.Op&fsnotify.Create == fsnotify.Create {
+		if stat, err := os.Stat(evt.Name); err == nil && stat.IsDir() {
+			if err := w.addRecursive(evt.Name); err != nil && !os.IsNotExist(err) {
+				// the new directory cannot be watched so report the error
+				// and let the trigger be rebuilt
+				select {
+				case w.errors <- fmt.Errorf("failed to watch %s: %s", evt.Name, err):
+				case <-w.closed:
+				}
+				return
+			}
+		}
+	}
+
+	if evt.Op&w.ops == 0 {
+		return
+	}
+
+	if !w.matches(evt.Name) {
+		return
+	}
+
+	op := evt.Op & w.ops
+
+	if w.debounce == 0 {
+		w.emit(evt.Name, op)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if p, ok := w.pending[evt.Name]; ok {
+		p.op |= op
+		p.timer.Reset(w.debounce)
+		return
+	}
+
+	p := &pendingEvent{op: op}
+	p.timer = time.AfterFunc(w.debounce, func() {
+		w.mu.Lock()
+		if w.pending[evt.Name] != p {
+			// already flushed or stopped by Close()
+			w.mu.Unlock()
+			return
+		}
+		delete(w.pending, evt.Name)
+		op := p.op
+		w.mu.Unlock()
+
+		w.emit(evt.Name, op)
+	})
+
+	w.pending[evt.Name] = p
+}
+
+func (w *Watch) matches(path string) bool {
+	if w.pattern == "" {
+		return true
+	}
+
+	ok, _ := filepath.Match(w.pattern, filepath.Base(path))
+	return ok
+}
+
+func (w *Watch) emit(path string, op fsnotify.Op) {
+	payload := map[string]interface{}{
+		"path": path,
+		"op":   opName(op),
+	}
+
+	if op&(fsnotify.Remove|fsnotify.Rename) == 0 {
+		if stat, err := os.Lstat(path); err == nil {
+			payload["size"] = stat.Size()
+
+			// symlinks are not followed as they may point outside of the
+			// allowed paths
+			if w.maxContentSize > 0 && stat.Mode().IsRegular() {
+				if content, truncated, err := readContent(path, w.maxContentSize); err == nil {
+					payload["content"] = content
+					payload["truncated"] = truncated
+				}
+			}
+		}
+	}
+
+	blob, _ := json.Marshal(payload)
+
+	select {
+	case w.events <- sigma.NewSimpleEvent("fswatch", blob):
+	case <-w.closed:
+	}
+}
+
+func (w *Watch) addRecursive(root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		return w.watcher.Add(path)
+	})
+}
+
+func readContent(path string, limit int64) ([]byte, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	// read one byte more than allowed so we can detect truncation
+	content, err := ioutil.ReadAll(io.LimitReader(f, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+
+	if int64(len(content)) > limit {
+		return content[:limit], true, nil
+	}
+
+	return content, false, nil
+}
+
+func opName(op fsnotify.Op) string {
+	switch {
+	case op&fsnotify.Remove == fsnotify.Remove:
+		return "remove"
+	case op&fsnotify.Rename == fsnotify.Rename:
+		return "rename"
+	case op&fsnotify.Create == fsnotify.Create:
+		return "create"
+	case op&fsnotify.Write == fsnotify.Write:
+		return "write"
+	default:
+		return strings.ToLower(op.String())
+	}
+}
+
+func parseOps(s string) (fsnotify.Op, error) {
+	var ops fsnotify.Op
+
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		op, ok := operations[name]
+		if !ok {
+			return 0, fmt.Errorf("%s: %q", ErrUnknownOperation, name)
+		}
+
+		ops |= op
+	}
+
+	return ops, nil
+}
+
+// Factory is a trigger.Factory for filesystem watchers
+type Factory struct {
+	// AllowedPaths holds the files and directories that may be watched,
+	// including everything below them. Watches report changes and file
+	// contents with the permissions of the process building the trigger so
+	// no path can be watched unless allowed
+	AllowedPaths []string
+}
+
+// DefaultFactory is the factory registered for the "fswatch" trigger type.
+// No path is allowed by default
+var DefaultFactory = &Factory{}
+
+// Build builds a new filesystem watch trigger and implements trigger.Factory
+//
+// Supported options:
+//
+//	path            - the file or directory to watch (required). It must be
+//	                  within one of the allowed paths of the factory
+//	pattern         - a glob pattern matched against the file name
+//	recursive       - watch all sub-directories as well (default: false)
+//	events          - comma separated list of create,write,remove,rename (default: all)
+//	debounce        - duration to wait for further events on the same path
+//	maxContentSize  - include the file content up to the given number of bytes.
+//	                  The content is base64 encoded in the event payload
+func (f Factory) Build(opts map[string]string) (trigger.Trigger, error) {
+	path, ok := opts["path"]
+	if !ok || path == "" {
+		return nil, ErrMissingPath
+	}
+
+	path, err := f.allowed(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watch{
+		pattern: opts["pattern"],
+		ops:     fsnotify.Create | fsnotify.Write | fsnotify.Remove | fsnotify.Rename,
+		pending: make(map[string]*pendingEvent),
+		events:  make(chan sigma.Event),
+		errors:  make(chan error),
+		closed:  make(chan struct{}),
+	}
+
+	if w.pattern != "" {
+		if _, err := filepath.Match(w.pattern, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if s, ok := opts["recursive"]; ok {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, err
+		}
+		w.recursive = b
+	}
+
+	if s, ok := opts["events"]; ok {
+		ops, err := parseOps(s)
+		if err != nil {
+			return nil, err
+		}
+
+		if ops != 0 {
+			w.ops = ops
+		}
+	}
+
+	if s, ok := opts["debounce"]; ok {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, err
+		}
+		w.debounce = d
+	}
+
+	if s, ok := opts["maxContentSize"]; ok {
+		size, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		w.maxContentSize = size
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w.watcher = watcher
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	if w.recursive && stat.IsDir() {
+		err = w.addRecursive(path)
+	} else {
+		err = watcher.Add(path)
+	}
+
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	w.wg.Add(1)
+	go w.watch()
+
+	return w, nil
+}
+
+// allowed resolves path and returns ErrPathNotAllowed if it is not within
+// one of the allowed paths. Symlinks are resolved so they cannot be used to
+// escape the allowed paths
+func (f Factory) allowed(path string) (string, error) {
+	resolved, err := resolve(path)
+	if err != nil {
+		return "", err
+	}
+
+	for _, root := range f.AllowedPaths {
+		root, err := resolve(root)
+		if err != nil {
+			continue
+		}
+
+		rel, err := filepath.Rel(root, resolved)
+		if err != nil {
+			continue
+		}
+
+		if rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return resolved, nil
+		}
+	}
+
+	return "", ErrPathNotAllowed
+}
+
+func resolve(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.EvalSymlinks(path)
+}
+
+func init() {
+	trigger.Register("fswatch", DefaultFactory)
+}
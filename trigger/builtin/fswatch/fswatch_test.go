@@ -0,0 +1,244 @@
+package fswatch
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/trigger"
+	"github.com/stretchr/testify/assert"
+)
+
+type payload struct {
+	Path      string
+	Op        string
+	Size      int64
+	Content   []byte
+	Truncated bool
+}
+
+func next(t *testing.T, tr trigger.Trigger) payload {
+	res := make(chan sigma.Event, 1)
+	errs := make(chan error, 1)
+
+	go func() {
+		evt, err := tr.Next()
+		if err != nil {
+			errs <- err
+			return
+		}
+		res <- evt
+	}()
+
+	select {
+	case evt := <-res:
+		var p payload
+		if err := json.Unmarshal(evt.Payload(), &p); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	case err := <-errs:
+		t.Fatal(err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for filesystem event")
+	}
+
+	return payload{}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "fswatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// resolve symlinks so paths match the ones reported by inotify
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestFactory_Invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	f := Factory{AllowedPaths: []string{dir}}
+
+	_, err := f.Build(map[string]string{})
+	assert.Equal(ErrMissingPath, err)
+
+	_, err = f.Build(map[string]string{"path": dir, "events": "create,chmod"})
+	assert.Error(err)
+
+	_, err = f.Build(map[string]string{"path": dir, "pattern": "["})
+	assert.Error(err)
+
+	_, err = f.Build(map[string]string{"path": filepath.Join(dir, "missing")})
+	assert.Error(err)
+}
+
+func TestFactory_AllowedPaths(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	allowed := filepath.Join(dir, "allowed")
+	sub := filepath.Join(allowed, "sub")
+	other := filepath.Join(dir, "allowed-other")
+	link := filepath.Join(allowed, "link")
+
+	assert.NoError(os.MkdirAll(sub, 0755))
+	assert.NoError(os.Mkdir(other, 0755))
+	assert.NoError(os.Symlink(other, link))
+
+	_, err := Factory{}.Build(map[string]string{"path": allowed})
+	assert.Equal(ErrPathNotAllowed, err)
+
+	f := Factory{AllowedPaths: []string{allowed}}
+
+	for _, path := range []string{allowed, sub, filepath.Join(sub, "..")} {
+		tr, err := f.Build(map[string]string{"path": path})
+		if assert.NoError(err, path) {
+			tr.Close()
+		}
+	}
+
+	for _, path := range []string{dir, other, link, filepath.Join(allowed, "..")} {
+		_, err := f.Build(map[string]string{"path": path})
+		assert.Equal(ErrPathNotAllowed, err, path)
+	}
+}
+
+func TestWatch_Operations(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	f := Factory{AllowedPaths: []string{dir}}
+
+	tr, err := f.Build(map[string]string{
+		"path":   dir,
+		"events": "create,remove,rename",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	a := filepath.Join(dir, "a.txt")
+	b := filepath.Join(dir, "b.txt")
+
+	assert.NoError(ioutil.WriteFile(a, []byte("a"), 0644))
+	assert.Equal(payload{Path: a, Op: "create", Size: 1}, next(t, tr))
+
+	assert.NoError(os.Rename(a, b))
+	assert.Equal(payload{Path: a, Op: "rename"}, next(t, tr))
+	assert.Equal(payload{Path: b, Op: "create", Size: 1}, next(t, tr))
+
+	assert.NoError(os.Remove(b))
+	assert.Equal(payload{Path: b, Op: "remove"}, next(t, tr))
+}
+
+func TestWatch_WriteContent(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	f := Factory{AllowedPaths: []string{dir}}
+
+	file := filepath.Join(dir, "data")
+	assert.NoError(ioutil.WriteFile(file, nil, 0644))
+
+	tr, err := f.Build(map[string]string{
+		"path":           dir,
+		"events":         "write",
+		"debounce":       "50ms",
+		"maxContentSize": "3",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	// truncating and writing the file is reported as a single event
+	assert.NoError(ioutil.WriteFile(file, []byte("hello"), 0644))
+	assert.Equal(payload{
+		Path:      file,
+		Op:        "write",
+		Size:      5,
+		Content:   []byte("hel"),
+		Truncated: true,
+	}, next(t, tr))
+}
+
+func TestWatch_Recursive(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	f := Factory{AllowedPaths: []string{dir}}
+
+	existing := filepath.Join(dir, "existing")
+	assert.NoError(os.Mkdir(existing, 0755))
+
+	tr, err := f.Build(map[string]string{
+		"path":      dir,
+		"events":    "create",
+		"recursive": "true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	file := filepath.Join(existing, "a")
+	assert.NoError(ioutil.WriteFile(file, nil, 0644))
+	assert.Equal(file, next(t, tr).Path)
+
+	// directories created after the trigger has been built are watched
+	// as well
+	sub := filepath.Join(dir, "sub")
+	assert.NoError(os.Mkdir(sub, 0755))
+	assert.Equal(sub, next(t, tr).Path)
+
+	file = filepath.Join(sub, "b")
+	assert.NoError(ioutil.WriteFile(file, nil, 0644))
+	assert.Equal(file, next(t, tr).Path)
+}
+
+func TestWatch_Pattern(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	f := Factory{AllowedPaths: []string{dir}}
+
+	tr, err := f.Build(map[string]string{
+		"path":    dir,
+		"events":  "create",
+		"pattern": "*.json",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "a.txt"), nil, 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "b.json"), nil, 0644))
+
+	assert.Equal(filepath.Join(dir, "b.json"), next(t, tr).Path)
+}
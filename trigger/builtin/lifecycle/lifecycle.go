@@ -0,0 +1,170 @@
+package lifecycle
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/events"
+	"github.com/homebot/sigma/trigger"
+)
+
+// ErrInvalidBuffer is returned if the buffer option is not a positive number
+var ErrInvalidBuffer = errors.New("buffer must be positive")
+
+// Watch is a trigger.Trigger that fires for lifecycle events of the sigma
+// platform, like functions or nodes being created and destroyed or failed
+// executions. Events of the function the trigger is built for are skipped
+// so a function cannot trigger itself, e.g. by failing on each
+// sigma.execution.failed event. Only events of functions in the same
+// namespace as the function the trigger is built for are delivered
+type Watch struct {
+	sub *events.Subscription
+
+	types    []string
+	function string
+
+	// self is the ID of the function the trigger is built for
+	self string
+
+	// namespace is the namespace of the function the trigger is built for
+	namespace string
+}
+
+// URN returns the URN for the lifecycle trigger
+func (w *Watch) URN() string { return "internal" }
+
+// Close unsubscribes from the event bus
+func (w *Watch) Close() error {
+	w.sub.Close()
+	return nil
+}
+
+// Next waits for the next lifecycle event matching the configured filters
+// and returns it as a sigma event
+func (w *Watch) Next() (sigma.Event, error) {
+	for evt := range w.sub.C {
+		if !w.matches(evt) {
+			continue
+		}
+
+		blob, err := json.Marshal(evt)
+		if err != nil {
+			return nil, err
+		}
+
+		return sigma.NewSimpleEvent(evt.Type, blob), nil
+	}
+
+	return nil, io.EOF
+}
+
+func (w *Watch) matches(evt events.Event) bool {
+	if w.self != "" && evt.Function == w.self {
+		return false
+	}
+
+	if w.namespace != "" && !strings.HasPrefix(evt.Function, w.namespace+"/") {
+		return false
+	}
+
+	if w.function != "" {
+		if ok, _ := path.Match(w.function, evt.Function); !ok {
+			return false
+		}
+	}
+
+	if len(w.types) == 0 {
+		return true
+	}
+
+	for _, typ := range w.types {
+		if ok, _ := path.Match(typ, evt.Type); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Factory is a trigger.Factory for lifecycle triggers
+type Factory struct {
+	// Bus is the event bus to subscribe to
+	Bus *events.Bus
+}
+
+// Build builds a new lifecycle trigger and implements trigger.Factory
+//
+// Supported options:
+//
+//	events    - comma separated list of event types, may contain wildcards
+//	            like "sigma.node.*" (default: all)
+//	function  - a pattern matched against the function ID, e.g.
+//	            "acct/functions/*" (default: all)
+//	buffer    - the number of events buffered before dropping them (default: 64)
+//
+// When built for a function, only events of functions within the namespace of
+// that function are delivered, regardless of the function pattern.
+//
+// Patterns use path.Match so `*` does not match across `/`. The pattern
+// "acct/*" does not match the function "acct/functions/fn".
+//
+// The payload of each event is a JSON object with the keys type, function,
+// node, time and data
+func (f Factory) Build(opts map[string]string) (trigger.Trigger, error) {
+	if f.Bus == nil {
+		return nil, errors.New("no event bus configured")
+	}
+
+	w := &Watch{
+		function: opts["function"],
+		self:     opts[trigger.OptionFunction],
+	}
+
+	if i := strings.Index(w.self, "/functions/"); i > 0 {
+		w.namespace = w.self[:i]
+	}
+
+	if w.function != "" {
+		if _, err := path.Match(w.function, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, typ := range strings.Split(opts["events"], ",") {
+		typ = strings.TrimSpace(typ)
+		if typ == "" {
+			continue
+		}
+
+		if _, err := path.Match(typ, ""); err != nil {
+			return nil, err
+		}
+
+		w.types = append(w.types, typ)
+	}
+
+	size := events.DefaultBufferSize
+	if s, ok := opts["buffer"]; ok {
+		var err error
+		if size, err = strconv.Atoi(s); err != nil {
+			return nil, err
+		}
+
+		if size <= 0 {
+			return nil, ErrInvalidBuffer
+		}
+	}
+
+	w.sub = f.Bus.Subscribe(size)
+
+	return w, nil
+}
+
+func init() {
+	trigger.Register("internal", &Factory{Bus: events.DefaultBus})
+}
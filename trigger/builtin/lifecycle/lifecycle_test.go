@@ -0,0 +1,150 @@
+package lifecycle
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/homebot/sigma/events"
+	"github.com/homebot/sigma/trigger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFactory_Invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := Factory{}.Build(nil)
+	assert.Error(err)
+
+	_, err = Factory{Bus: events.NewBus()}.Build(map[string]string{"function": "["})
+	assert.Error(err)
+
+	_, err = Factory{Bus: events.NewBus()}.Build(map[string]string{"buffer": "many"})
+	assert.Error(err)
+
+	for _, size := range []string{"0", "-1"} {
+		_, err = Factory{Bus: events.NewBus()}.Build(map[string]string{"buffer": size})
+		assert.Equal(ErrInvalidBuffer, err)
+	}
+}
+
+func TestWatch_Filter(t *testing.T) {
+	assert := assert.New(t)
+
+	bus := events.NewBus()
+
+	tr, err := Factory{Bus: bus}.Build(map[string]string{
+		"function": "acct/functions/*",
+		"events":   "sigma.node.*, sigma.execution.failed",
+	})
+	if !assert.NoError(err) {
+		return
+	}
+
+	bus.Publish(events.Event{Type: events.NodeCreated, Function: "other/functions/fn"})
+	bus.Publish(events.Event{Type: events.FunctionCreated, Function: "acct/functions/fn"})
+	bus.Publish(events.Event{
+		Type:     events.NodeDestroyed,
+		Function: "acct/functions/fn",
+		Node:     "node-1",
+		Data:     json.RawMessage(`{"reason": "unhealthy"}`),
+	})
+
+	evt, err := tr.Next()
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(events.NodeDestroyed, evt.Type())
+
+	var payload events.Event
+	assert.NoError(json.Unmarshal(evt.Payload(), &payload))
+	assert.Equal("acct/functions/fn", payload.Function)
+	assert.Equal("node-1", payload.Node)
+
+	// lifecycle events can be filtered further using trigger conditions
+	c, err := trigger.CompileCondition(`json(payload).data.reason == "unhealthy"`)
+	if assert.NoError(err) {
+		ok, err := c.Evaluate(evt, nil)
+		assert.NoError(err)
+		assert.True(ok)
+	}
+
+	assert.NoError(tr.Close())
+
+	_, err = tr.Next()
+	assert.Error(err)
+}
+
+func TestWatch_Patterns(t *testing.T) {
+	assert := assert.New(t)
+
+	bus := events.NewBus()
+
+	tr, err := Factory{Bus: bus}.Build(map[string]string{"function": "acct/*"})
+	if !assert.NoError(err) {
+		return
+	}
+	defer tr.Close()
+
+	// `*` does not match across `/`
+	bus.Publish(events.Event{Type: events.FunctionCreated, Function: "acct/functions/fn"})
+	bus.Publish(events.Event{Type: events.FunctionCreated, Function: "acct/fn"})
+
+	evt, err := tr.Next()
+	if assert.NoError(err) {
+		var payload events.Event
+		assert.NoError(json.Unmarshal(evt.Payload(), &payload))
+		assert.Equal("acct/fn", payload.Function)
+	}
+}
+
+func TestWatch_SkipsOwnFunction(t *testing.T) {
+	assert := assert.New(t)
+
+	bus := events.NewBus()
+
+	tr, err := Factory{Bus: bus}.Build(map[string]string{
+		"events":               events.ExecutionFailed,
+		trigger.OptionFunction: "acct/functions/self",
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	defer tr.Close()
+
+	// failures of the function itself must not trigger it again
+	bus.Publish(events.Event{Type: events.ExecutionFailed, Function: "acct/functions/self"})
+	bus.Publish(events.Event{Type: events.ExecutionFailed, Function: "acct/functions/other"})
+
+	evt, err := tr.Next()
+	if assert.NoError(err) {
+		var payload events.Event
+		assert.NoError(json.Unmarshal(evt.Payload(), &payload))
+		assert.Equal("acct/functions/other", payload.Function)
+	}
+}
+
+func TestWatch_OwnNamespace(t *testing.T) {
+	assert := assert.New(t)
+
+	bus := events.NewBus()
+
+	tr, err := Factory{Bus: bus}.Build(map[string]string{
+		"function":             "*/functions/*",
+		trigger.OptionFunction: "acct/functions/self",
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	defer tr.Close()
+
+	// events of other namespaces are never delivered
+	bus.Publish(events.Event{Type: events.FunctionCreated, Function: "other/functions/fn"})
+	bus.Publish(events.Event{Type: events.FunctionCreated, Function: "acct/functions/fn"})
+
+	evt, err := tr.Next()
+	if assert.NoError(err) {
+		var payload events.Event
+		assert.NoError(json.Unmarshal(evt.Payload(), &payload))
+		assert.Equal("acct/functions/fn", payload.Function)
+	}
+}
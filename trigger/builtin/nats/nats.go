@@ -0,0 +1,199 @@
+package nats
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+
+	gnats "github.com/nats-io/nats.go"
+
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/trigger"
+)
+
+var (
+	// ErrMissingSubject is returned when the `subject` configuration key is
+	// missing during Build()
+	ErrMissingSubject = errors.New("missing `subject` configuration key")
+
+	// ErrConnectionClosed is returned by Next() if the connection to the NATS
+	// server has been closed unexpectedly
+	ErrConnectionClosed = errors.New("nats connection closed")
+)
+
+// Subscription is a trigger.Trigger that fires for each message published
+// on a NATS subject
+type Subscription struct {
+	conn    *gnats.Conn
+	sub     *gnats.Subscription
+	publish string
+
+	events chan sigma.Event
+	errors chan error
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// URN returns the URN for the NATS subscription
+func (s *Subscription) URN() string { return "nats" }
+
+// Close unsubscribes and closes the connection to the NATS server
+func (s *Subscription) Close() error {
+	err := errors.New("already closed")
+
+	s.closeOnce.Do(func() {
+		close(s.closed)
+
+		err = s.sub.Unsubscribe()
+		s.conn.Close()
+	})
+
+	return err
+}
+
+// Next waits for the next message and returns it as a sigma event
+func (s *Subscription) Next() (sigma.Event, error) {
+	select {
+	case evt := <-s.events:
+		return evt, nil
+	case err := <-s.errors:
+		return nil, err
+	case <-s.closed:
+		return nil, io.EOF
+	}
+}
+
+func (s *Subscription) handle(msg *gnats.Msg) {
+	blob, _ := json.Marshal(map[string]interface{}{
+		"subject": msg.Subject,
+		"data":    encodeData(msg.Data),
+	})
+
+	evt := &Event{
+		Event: sigma.NewSimpleEvent("nats", blob),
+		sub:   s,
+		reply: msg.Reply,
+	}
+
+	select {
+	case s.events <- evt:
+	case <-s.closed:
+	}
+}
+
+func (s *Subscription) connectionClosed(*gnats.Conn) {
+	select {
+	case <-s.closed:
+		// closed by Close()
+	case s.errors <- ErrConnectionClosed:
+	}
+}
+
+// Event is a sigma event for a NATS message. It implements trigger.Replier
+// and publishes the function result to the reply subject of the message (for
+// requests) or to the `publish` subject of the trigger, if configured
+type Event struct {
+	sigma.Event
+
+	sub   *Subscription
+	reply string
+}
+
+// Reply publishes the result of the function execution. Errors are published
+// as a JSON object with an `error` key
+func (e *Event) Reply(result []byte, err error) error {
+	subject := e.reply
+	if subject == "" {
+		subject = e.sub.publish
+	}
+
+	if subject == "" {
+		return nil
+	}
+
+	if err != nil {
+		result, _ = json.Marshal(map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return e.sub.conn.Publish(subject, result)
+}
+
+// encodeData returns data as raw JSON or as a JSON string if it's not valid
+// JSON
+func encodeData(data []byte) json.RawMessage {
+	if len(data) > 0 && json.Valid(data) {
+		return json.RawMessage(data)
+	}
+
+	blob, _ := json.Marshal(string(data))
+	return json.RawMessage(blob)
+}
+
+// Factory is a trigger.Factory for NATS subscriptions
+type Factory struct{}
+
+// Build builds a new NATS subscription and implements trigger.Factory
+//
+// Supported options:
+//
+//	url      - the URL of the NATS server (default: nats://127.0.0.1:4222)
+//	subject  - the subject to subscribe to, may contain wildcards (required)
+//	queue    - a queue group used to share messages between sigma instances
+//	publish  - a subject to publish function results to
+//	name     - the connection name reported to the NATS server
+//
+// Requests are replied with the result of the function execution
+func (f Factory) Build(opts map[string]string) (trigger.Trigger, error) {
+	subject := opts["subject"]
+	if subject == "" {
+		return nil, ErrMissingSubject
+	}
+
+	url := opts["url"]
+	if url == "" {
+		url = gnats.DefaultURL
+	}
+
+	s := &Subscription{
+		publish: opts["publish"],
+		events:  make(chan sigma.Event),
+		errors:  make(chan error),
+		closed:  make(chan struct{}),
+	}
+
+	connOpts := []gnats.Option{
+		gnats.ClosedHandler(s.connectionClosed),
+	}
+
+	if name := opts["name"]; name != "" {
+		connOpts = append(connOpts, gnats.Name(name))
+	}
+
+	conn, err := gnats.Connect(url, connOpts...)
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+
+	if queue := opts["queue"]; queue != "" {
+		s.sub, err = conn.QueueSubscribe(subject, queue, s.handle)
+	} else {
+		s.sub, err = conn.Subscribe(subject, s.handle)
+	}
+
+	if err != nil {
+		close(s.closed)
+		conn.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func init() {
+	trigger.Register("nats", &Factory{})
+}
@@ -0,0 +1,212 @@
+package nats
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	gnats "github.com/nats-io/nats.go"
+
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/trigger"
+	"github.com/stretchr/testify/assert"
+)
+
+func build(t *testing.T, opts map[string]string) *Subscription {
+	tr, err := Factory{}.Build(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := tr.(*Subscription)
+
+	// make sure the subscription is known to the server before publishing
+	if err := s.conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func connect(t *testing.T, url string) *gnats.Conn {
+	conn, err := gnats.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
+
+func next(t *testing.T, s *Subscription) sigma.Event {
+	res := make(chan sigma.Event, 1)
+
+	go func() {
+		evt, err := s.Next()
+		if err == nil {
+			res <- evt
+		}
+	}()
+
+	select {
+	case evt := <-res:
+		return evt
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for message")
+		return nil
+	}
+}
+
+func TestFactory_MissingSubject(t *testing.T) {
+	_, err := Factory{}.Build(map[string]string{})
+	assert.Equal(t, ErrMissingSubject, err)
+}
+
+func TestSubscription_Wildcards(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := natsserver.RunRandClientPortServer()
+	defer srv.Shutdown()
+
+	s := build(t, map[string]string{
+		"url":     srv.ClientURL(),
+		"subject": "sensors.*.temperature",
+	})
+	defer s.Close()
+
+	pub := connect(t, srv.ClientURL())
+	defer pub.Close()
+
+	assert.NoError(pub.Publish("sensors.kitchen.humidity", []byte(`55`)))
+	assert.NoError(pub.Publish("sensors.kitchen.temperature", []byte(`{"value": 21.5}`)))
+	assert.NoError(pub.Publish("sensors.garage.temperature", []byte(`cold`)))
+
+	evt := next(t, s)
+	assert.Equal("nats", evt.Type())
+	assert.JSONEq(`{"subject": "sensors.kitchen.temperature", "data": {"value": 21.5}}`, string(evt.Payload()))
+
+	evt = next(t, s)
+	assert.JSONEq(`{"subject": "sensors.garage.temperature", "data": "cold"}`, string(evt.Payload()))
+
+	assert.NoError(s.Close())
+	assert.Error(s.Close())
+
+	_, err := s.Next()
+	assert.Error(err)
+}
+
+func TestSubscription_QueueGroup(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := natsserver.RunRandClientPortServer()
+	defer srv.Shutdown()
+
+	opts := map[string]string{
+		"url":     srv.ClientURL(),
+		"subject": "jobs.>",
+		"queue":   "workers",
+	}
+
+	first := build(t, opts)
+	defer first.Close()
+
+	second := build(t, opts)
+	defer second.Close()
+
+	received := make(chan string, 20)
+	for _, s := range []*Subscription{first, second} {
+		go func(s *Subscription) {
+			for {
+				evt, err := s.Next()
+				if err != nil {
+					return
+				}
+
+				var payload struct {
+					Subject string
+				}
+				json.Unmarshal(evt.Payload(), &payload)
+				received <- payload.Subject
+			}
+		}(s)
+	}
+
+	pub := connect(t, srv.ClientURL())
+	defer pub.Close()
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(pub.Publish("jobs.build", nil))
+	}
+	assert.NoError(pub.Flush())
+
+	// each message is delivered to exactly one member of the group
+	for i := 0; i < 10; i++ {
+		select {
+		case subject := <-received:
+			assert.Equal("jobs.build", subject)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received only %d messages", i)
+		}
+	}
+
+	select {
+	case <-received:
+		t.Fatal("message delivered more than once")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSubscription_RequestReply(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := natsserver.RunRandClientPortServer()
+	defer srv.Shutdown()
+
+	s := build(t, map[string]string{
+		"url":     srv.ClientURL(),
+		"subject": "rpc.ping",
+		"publish": "results",
+	})
+	defer s.Close()
+
+	cli := connect(t, srv.ClientURL())
+	defer cli.Close()
+
+	replies := make(chan *gnats.Msg, 1)
+	go func() {
+		msg, err := cli.Request("rpc.ping", []byte("ping"), 2*time.Second)
+		if err == nil {
+			replies <- msg
+		}
+		close(replies)
+	}()
+
+	evt := next(t, s)
+
+	// the replier survives a transform of the event
+	r, ok := trigger.WithReplier(sigma.NewSimpleEvent("transformed", nil), evt.(trigger.Replier)).(trigger.Replier)
+	if assert.True(ok) {
+		assert.NoError(r.Reply([]byte("pong"), nil))
+	}
+
+	msg := <-replies
+	if assert.NotNil(msg) {
+		assert.Equal("pong", string(msg.Data))
+	}
+
+	// messages without a reply subject publish to the `publish` subject
+	results, err := cli.SubscribeSync("results")
+	assert.NoError(err)
+	assert.NoError(cli.Flush())
+
+	assert.NoError(cli.Publish("rpc.ping", []byte("ping")))
+
+	evt = next(t, s)
+	assert.NoError(evt.(trigger.Replier).Reply(nil, errors.New("failed")))
+
+	msg, err = results.NextMsg(2 * time.Second)
+	if assert.NoError(err) {
+		assert.JSONEq(`{"error": "failed"}`, string(msg.Data))
+	}
+}
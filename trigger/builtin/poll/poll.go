@@ -0,0 +1,419 @@
+package poll
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yalp/jsonpath"
+
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/trigger"
+)
+
+var (
+	// ErrMissingInterval is returned when the `interval` configuration key
+	// is missing during Build()
+	ErrMissingInterval = errors.New("missing `interval` configuration key")
+
+	// ErrMissingSource is returned when none of the `url`, `command` or
+	// `file` configuration keys is set
+	ErrMissingSource = errors.New("one of `url`, `command` or `file` is required")
+
+	// ErrMultipleSources is returned when more than one of the `url`,
+	// `command` or `file` configuration keys is set
+	ErrMultipleSources = errors.New("only one of `url`, `command` or `file` may be set")
+
+	// ErrSourceDisabled is returned when the `command` or `file` source is
+	// used without being enabled for the factory
+	ErrSourceDisabled = errors.New("source is disabled")
+
+	// ErrInvalidInterval is returned when the `interval` configuration key
+	// is not positive
+	ErrInvalidInterval = errors.New("`interval` must be positive")
+
+	// ErrHostNotAllowed is returned when the host of the `url` source is not
+	// within the allowed hosts of the factory
+	ErrHostNotAllowed = errors.New("host not allowed")
+
+	// ErrContentTooLarge is returned from polls when the content of the
+	// `url` source exceeds MaxContentSize
+	ErrContentTooLarge = errors.New("content too large")
+)
+
+// MaxContentSize is the maximum size of the content fetched from a `url`
+// source
+const MaxContentSize = 1024 * 1024
+
+// source returns the current content of the polled resource
+type source func(ctx context.Context) ([]byte, error)
+
+// pollError is returned from Next() when polling failed. It is temporary so
+// the trigger is retried without losing the last known value
+type pollError struct {
+	err error
+}
+
+func (p *pollError) Error() string   { return p.err.Error() }
+func (p *pollError) Temporary() bool { return true }
+
+// Poller is a trigger.Trigger that periodically polls a HTTP endpoint, a
+// command or a file and fires when the polled value changes
+type Poller struct {
+	source      source
+	name        string
+	filter      jsonpath.FilterFunc
+	timeout     time.Duration
+	emitInitial bool
+
+	ticker *time.Ticker
+
+	// polled is set after the first poll. The first poll happens
+	// immediately, all further ones on each tick
+	polled      bool
+	initialized bool
+	previous    interface{}
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+}
+
+// URN returns the URN for the poller
+func (p *Poller) URN() string { return "poll" }
+
+// Close stops polling and cancels a running poll
+func (p *Poller) Close() error {
+	err := errors.New("already closed")
+
+	p.closeOnce.Do(func() {
+		p.cancel()
+		p.ticker.Stop()
+		err = nil
+	})
+
+	return err
+}
+
+// Next polls the source until it's value changes and returns the previous
+// and current value as an event
+func (p *Poller) Next() (sigma.Event, error) {
+	for {
+		if p.polled {
+			select {
+			case <-p.ticker.C:
+			case <-p.ctx.Done():
+				return nil, io.EOF
+			}
+		}
+		p.polled = true
+
+		current, content, err := p.poll()
+		if err != nil {
+			if p.ctx.Err() != nil {
+				return nil, io.EOF
+			}
+
+			return nil, &pollError{err}
+		}
+
+		if !p.initialized {
+			p.initialized = true
+			p.previous = current
+
+			if p.emitInitial {
+				return p.event(nil, current, content), nil
+			}
+			continue
+		}
+
+		if reflect.DeepEqual(p.previous, current) {
+			continue
+		}
+
+		previous := p.previous
+		p.previous = current
+
+		return p.event(previous, current, content), nil
+	}
+}
+
+func (p *Poller) event(previous, current interface{}, content []byte) sigma.Event {
+	payload := map[string]interface{}{
+		"source":   p.name,
+		"previous": previous,
+		"current":  current,
+		"time":     time.Now().Format(time.RFC3339),
+	}
+
+	// without a jsonpath the value is a hash so we include the content
+	// as well
+	if p.filter == nil {
+		if json.Valid(content) {
+			payload["content"] = json.RawMessage(content)
+		} else {
+			payload["content"] = string(content)
+		}
+	}
+
+	blob, _ := json.Marshal(payload)
+
+	return sigma.NewSimpleEvent("poll", blob)
+}
+
+// poll fetches the source and returns the selected value (or the hash of
+// the content) and the content itself
+func (p *Poller) poll() (interface{}, []byte, error) {
+	ctx := p.ctx
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	content, err := p.source(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if p.filter == nil {
+		sum := sha256.Sum256(content)
+		return hex.EncodeToString(sum[:]), content, nil
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, nil, err
+	}
+
+	value, err := p.filter(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return value, content, nil
+}
+
+func httpSource(url string, allowed func(host string) bool) source {
+	client := &http.Client{
+		// redirects must not lead to hosts that cannot be polled directly
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+
+			if !allowed(req.URL.Hostname()) {
+				return fmt.Errorf("%s: %s", ErrHostNotAllowed, req.URL.Hostname())
+			}
+			return nil
+		},
+	}
+
+	return func(ctx context.Context) ([]byte, error) {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			return nil, fmt.Errorf("unexpected status: %s", res.Status)
+		}
+
+		content, err := ioutil.ReadAll(io.LimitReader(res.Body, MaxContentSize+1))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(content) > MaxContentSize {
+			return nil, ErrContentTooLarge
+		}
+
+		return content, nil
+	}
+}
+
+func commandSource(command string) source {
+	return func(ctx context.Context) ([]byte, error) {
+		return exec.CommandContext(ctx, "sh", "-c", command).Output()
+	}
+}
+
+func fileSource(path string) source {
+	return func(ctx context.Context) ([]byte, error) {
+		return ioutil.ReadFile(path)
+	}
+}
+
+// Factory is a trigger.Factory for pollers
+type Factory struct {
+	// AllowCommand enables the `command` source. Commands are executed with
+	// the permissions of the process building the trigger so it must only be
+	// enabled if everyone allowed to create functions may run commands on
+	// the host
+	AllowCommand bool
+
+	// AllowFile enables the `file` source. Every file readable by the
+	// process building the trigger can be polled
+	AllowFile bool
+
+	// AllowedHosts restricts the hosts of the `url` source, including hosts
+	// redirected to. Entries either match a host name exactly or, if they
+	// start with "*.", all of its subdomains. If empty, any host can be
+	// polled, including ones only reachable from the process building the
+	// trigger
+	AllowedHosts []string
+}
+
+// DefaultFactory is the factory registered for the "poll" trigger type. The
+// command and file sources are disabled by default
+var DefaultFactory = &Factory{}
+
+// Build builds a new poller and implements trigger.Factory
+//
+// Supported options:
+//
+//	interval     - the interval between two polls (required)
+//	url          - a URL to fetch using HTTP GET
+//	command      - a command executed using `sh -c`; it's output is polled
+//	file         - a file to read
+//	jsonpath     - a JSONPath expression selecting the value to compare;
+//	               if empty, the SHA256 hash of the content is compared
+//	timeout      - the timeout of each poll
+//	emitInitial  - emit an event for the first value as well (default: false)
+//
+// Exactly one of url, command and file must be set. The command and file
+// sources must be enabled using AllowCommand and AllowFile. The host of the
+// url must be within AllowedHosts, if set, and at most MaxContentSize bytes
+// are fetched. The payload of each
+// event holds the source, the previous and the current value. If no jsonpath
+// is configured, the content is included as well
+func (f Factory) Build(opts map[string]string) (trigger.Trigger, error) {
+	is, ok := opts["interval"]
+	if !ok {
+		return nil, ErrMissingInterval
+	}
+
+	interval, err := time.ParseDuration(is)
+	if err != nil {
+		return nil, err
+	}
+
+	if interval <= 0 {
+		return nil, ErrInvalidInterval
+	}
+
+	p := &Poller{}
+
+	if path := opts["jsonpath"]; path != "" {
+		if p.filter, err = jsonpath.Prepare(path); err != nil {
+			return nil, err
+		}
+	}
+
+	for key, fn := range map[string]func(string) source{
+		"url":     f.httpSource,
+		"command": commandSource,
+		"file":    fileSource,
+	} {
+		value := opts[key]
+		if value == "" {
+			continue
+		}
+
+		if p.source != nil {
+			return nil, ErrMultipleSources
+		}
+
+		if (key == "command" && !f.AllowCommand) || (key == "file" && !f.AllowFile) {
+			return nil, fmt.Errorf("%s: %s", ErrSourceDisabled, key)
+		}
+
+		if key == "url" {
+			u, err := url.Parse(value)
+			if err != nil {
+				return nil, err
+			}
+
+			if !f.hostAllowed(u.Hostname()) {
+				return nil, fmt.Errorf("%s: %s", ErrHostNotAllowed, u.Hostname())
+			}
+		}
+
+		p.source = fn(value)
+		p.name = value
+	}
+
+	if p.source == nil {
+		return nil, ErrMissingSource
+	}
+
+	if s, ok := opts["timeout"]; ok {
+		if p.timeout, err = time.ParseDuration(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s, ok := opts["emitInitial"]; ok {
+		if p.emitInitial, err = strconv.ParseBool(s); err != nil {
+			return nil, err
+		}
+	}
+
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	p.ticker = time.NewTicker(interval)
+
+	return p, nil
+}
+
+func (f Factory) httpSource(url string) source {
+	return httpSource(url, f.hostAllowed)
+}
+
+// hostAllowed checks if host is within the allowed hosts of the factory
+func (f Factory) hostAllowed(host string) bool {
+	if len(f.AllowedHosts) == 0 {
+		return true
+	}
+
+	host = strings.ToLower(host)
+
+	for _, allowed := range f.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+
+		if strings.HasPrefix(allowed, "*.") {
+			if strings.HasSuffix(host, allowed[1:]) {
+				return true
+			}
+			continue
+		}
+
+		if host == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+func init() {
+	trigger.Register("poll", DefaultFactory)
+}
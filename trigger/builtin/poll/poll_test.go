@@ -0,0 +1,240 @@
+package poll
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/trigger"
+	"github.com/stretchr/testify/assert"
+)
+
+type payload struct {
+	Source   string
+	Previous interface{}
+	Current  interface{}
+	Content  json.RawMessage
+}
+
+func next(t *testing.T, tr trigger.Trigger) payload {
+	res := make(chan sigma.Event, 1)
+
+	go func() {
+		for {
+			evt, err := tr.Next()
+			if err == nil {
+				res <- evt
+				return
+			}
+			if !trigger.IsTemporary(err) {
+				return
+			}
+		}
+	}()
+
+	select {
+	case evt := <-res:
+		var p payload
+		if err := json.Unmarshal(evt.Payload(), &p); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for change")
+		return payload{}
+	}
+}
+
+func TestFactory_Invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	local := Factory{AllowCommand: true, AllowFile: true}
+
+	for opts, expected := range map[*map[string]string]error{
+		{"url": "http://localhost"}:                                   ErrMissingInterval,
+		{"interval": "1s"}:                                            ErrMissingSource,
+		{"interval": "0s", "url": "http://localhost"}:                 ErrInvalidInterval,
+		{"interval": "-1s", "url": "http://localhost"}:                ErrInvalidInterval,
+		{"interval": "1s", "url": "http://localhost", "file": "/tmp"}: ErrMultipleSources,
+	} {
+		_, err := local.Build(*opts)
+		assert.Equal(expected, err)
+	}
+
+	_, err := local.Build(map[string]string{"interval": "1s", "file": "/tmp", "jsonpath": "$.["})
+	assert.Error(err)
+}
+
+func TestFactory_LocalSourcesDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	// command and file sources must be enabled explicitly
+	for _, key := range []string{"command", "file"} {
+		_, err := Factory{}.Build(map[string]string{"interval": "1s", key: "/etc/passwd"})
+		if assert.Error(err, key) {
+			assert.True(strings.HasPrefix(err.Error(), ErrSourceDisabled.Error()), err.Error())
+		}
+	}
+
+	assert.False(DefaultFactory.AllowCommand)
+	assert.False(DefaultFactory.AllowFile)
+}
+
+func TestFactory_AllowedHosts(t *testing.T) {
+	assert := assert.New(t)
+
+	f := Factory{AllowedHosts: []string{"example.com", "*.example.org"}}
+
+	for u, allowed := range map[string]bool{
+		"http://example.com/status":     true,
+		"http://EXAMPLE.com:8080/":      true,
+		"http://api.example.org/status": true,
+		"http://example.org/status":     false,
+		"http://localhost/status":       false,
+		"http://169.254.169.254/":       false,
+	} {
+		tr, err := f.Build(map[string]string{"interval": "1s", "url": u})
+		if allowed {
+			if assert.NoError(err, u) {
+				tr.Close()
+			}
+			continue
+		}
+
+		if assert.Error(err, u) {
+			assert.True(strings.HasPrefix(err.Error(), ErrHostNotAllowed.Error()), err.Error())
+		}
+	}
+}
+
+func TestPoller_HTTPLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://example.com/", http.StatusFound)
+			return
+		}
+
+		w.Write(make([]byte, MaxContentSize+1))
+	}))
+	defer srv.Close()
+
+	allowed := func(host string) bool { return host == "127.0.0.1" }
+
+	_, err := httpSource(srv.URL, allowed)(context.Background())
+	assert.Equal(ErrContentTooLarge, err)
+
+	// redirects to other hosts are not followed
+	_, err = httpSource(srv.URL+"/redirect", allowed)(context.Background())
+	if assert.Error(err) {
+		assert.Contains(err.Error(), ErrHostNotAllowed.Error())
+	}
+}
+
+func TestPoller_HTTP(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		mu     sync.Mutex
+		status = "ok"
+		load   = 1
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if status == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprintf(w, `{"status": %q, "load": %d}`, status, load)
+		load++
+	}))
+	defer srv.Close()
+
+	tr, err := Factory{}.Build(map[string]string{
+		"interval": "5ms",
+		"url":      srv.URL,
+		"jsonpath": "$.status",
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	defer tr.Close()
+
+	go func() {
+		// the load changes on each request but only the status is
+		// compared
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		status = "degraded"
+		mu.Unlock()
+	}()
+
+	p := next(t, tr)
+	assert.Equal(srv.URL, p.Source)
+	assert.Equal("ok", p.Previous)
+	assert.Equal("degraded", p.Current)
+
+	// failed polls are temporary errors
+	mu.Lock()
+	status = ""
+	mu.Unlock()
+
+	_, err = tr.Next()
+	assert.True(trigger.IsTemporary(err))
+
+	assert.NoError(tr.Close())
+	_, err = tr.Next()
+	assert.Error(err)
+}
+
+func TestPoller_Command(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "sigma-poll")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state")
+	assert.NoError(ioutil.WriteFile(path, []byte("on"), 0644))
+
+	tr, err := Factory{AllowCommand: true}.Build(map[string]string{
+		"interval":    "5ms",
+		"command":     "cat " + path,
+		"emitInitial": "true",
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	defer tr.Close()
+
+	p := next(t, tr)
+	assert.Nil(p.Previous)
+	assert.NotEmpty(p.Current)
+	assert.Equal(`"on"`, string(p.Content))
+
+	initial := p.Current
+	assert.NoError(ioutil.WriteFile(path, []byte(`{"state": "off"}`), 0644))
+
+	p = next(t, tr)
+	assert.Equal(initial, p.Previous)
+	assert.NotEqual(initial, p.Current)
+	assert.JSONEq(`{"state": "off"}`, string(p.Content))
+}
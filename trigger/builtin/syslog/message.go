@@ -0,0 +1,313 @@
+package syslog
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// FormatRFC5424 is the format of messages following RFC5424
+	FormatRFC5424 = "rfc5424"
+
+	// FormatRFC3164 is the format of messages following RFC3164 (BSD syslog)
+	FormatRFC3164 = "rfc3164"
+)
+
+// facilities holds the names of all syslog facilities by code
+var facilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// severities holds the names of all syslog severities by code
+var severities = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// Message is a parsed syslog message
+type Message struct {
+	// Format is the format the message has been parsed as
+	Format string `json:"format"`
+
+	// Facility is the numeric facility of the message
+	Facility int `json:"facility"`
+
+	// FacilityName is the name of the facility, e.g. "daemon"
+	FacilityName string `json:"facilityName"`
+
+	// Severity is the numeric severity of the message where 0 is the most
+	// severe
+	Severity int `json:"severity"`
+
+	// SeverityName is the name of the severity, e.g. "err"
+	SeverityName string `json:"severityName"`
+
+	// Timestamp is the timestamp of the message. For messages without a
+	// (valid) timestamp the time of reception is used
+	Timestamp time.Time `json:"timestamp"`
+
+	// Host is the hostname of the sender
+	Host string `json:"host,omitempty"`
+
+	// App is the application name (RFC5424) or tag (RFC3164) of the message
+	App string `json:"app,omitempty"`
+
+	// ProcID is the process ID of the sender, if any
+	ProcID string `json:"procId,omitempty"`
+
+	// MsgID is the message ID of RFC5424 messages
+	MsgID string `json:"msgId,omitempty"`
+
+	// StructuredData holds the structured data elements of RFC5424 messages
+	// by SD-ID
+	StructuredData map[string]map[string]string `json:"structuredData,omitempty"`
+
+	// Message is the free-form message
+	Message string `json:"message"`
+
+	// Remote is the address of the peer the message has been received from
+	Remote string `json:"remote,omitempty"`
+}
+
+// setPriority sets the facility and severity from the PRI value
+func (m *Message) setPriority(pri int) {
+	m.Facility = pri / 8
+	m.Severity = pri % 8
+
+	if m.Facility < len(facilities) {
+		m.FacilityName = facilities[m.Facility]
+	}
+	m.SeverityName = severities[m.Severity]
+}
+
+// Parse parses a syslog message in either RFC5424 or RFC3164 format. As
+// required by RFC3164, messages without a valid PRI part are treated as
+// user.notice with the whole content as message. now is used for messages
+// without a timestamp and to complete RFC3164 timestamps that lack the year
+func Parse(raw []byte, now time.Time) Message {
+	s := strings.TrimRight(string(raw), "\r\n\x00")
+
+	var m Message
+
+	pri, rest, ok := parsePriority(s)
+	if !ok {
+		m.Format = FormatRFC3164
+		m.setPriority(13)
+		m.Timestamp = now
+		m.Message = s
+		return m
+	}
+	m.setPriority(pri)
+
+	if strings.HasPrefix(rest, "1 ") {
+		if parseRFC5424(&m, rest[2:], now) {
+			return m
+		}
+
+		// not a valid RFC5424 message, fall back to RFC3164
+		m = Message{}
+		m.setPriority(pri)
+	}
+
+	parseRFC3164(&m, rest, now)
+
+	return m
+}
+
+func parsePriority(s string) (int, string, bool) {
+	if len(s) < 3 || s[0] != '<' {
+		return 0, "", false
+	}
+
+	end := strings.IndexByte(s, '>')
+	if end < 2 || end > 4 {
+		return 0, "", false
+	}
+
+	pri, err := strconv.Atoi(s[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return 0, "", false
+	}
+
+	return pri, s[end+1:], true
+}
+
+// parseRFC5424 parses the part of a RFC5424 message following the version
+func parseRFC5424(m *Message, s string, now time.Time) bool {
+	var fields [5]string
+
+	for i := range fields {
+		idx := strings.IndexByte(s, ' ')
+		if idx < 0 {
+			// the message part is optional but structured data is not
+			return false
+		}
+
+		fields[i] = s[:idx]
+		s = s[idx+1:]
+	}
+
+	m.Timestamp = now
+	if fields[0] != "-" {
+		ts, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return false
+		}
+		m.Timestamp = ts
+	}
+
+	m.Host = nilValue(fields[1])
+	m.App = nilValue(fields[2])
+	m.ProcID = nilValue(fields[3])
+	m.MsgID = nilValue(fields[4])
+
+	sd, rest, ok := parseStructuredData(s)
+	if !ok {
+		return false
+	}
+
+	m.Format = FormatRFC5424
+	m.StructuredData = sd
+	m.Message = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\ufeff")
+
+	return true
+}
+
+// parseStructuredData parses the structured data part of a RFC5424 message
+// and returns the remaining message
+func parseStructuredData(s string) (map[string]map[string]string, string, bool) {
+	if strings.HasPrefix(s, "-") {
+		return nil, s[1:], true
+	}
+
+	sd := make(map[string]map[string]string)
+
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+
+		end := strings.IndexAny(s, " ]")
+		if end < 1 {
+			return nil, "", false
+		}
+
+		id := s[:end]
+		params := make(map[string]string)
+		s = s[end:]
+
+		for strings.HasPrefix(s, " ") {
+			s = s[1:]
+
+			eq := strings.Index(s, "=\"")
+			if eq < 1 {
+				return nil, "", false
+			}
+
+			name := s[:eq]
+			s = s[eq+2:]
+
+			value, n, ok := parseParamValue(s)
+			if !ok {
+				return nil, "", false
+			}
+
+			params[name] = value
+			s = s[n:]
+		}
+
+		if !strings.HasPrefix(s, "]") {
+			return nil, "", false
+		}
+		s = s[1:]
+
+		sd[id] = params
+	}
+
+	if len(sd) == 0 {
+		return nil, "", false
+	}
+
+	return sd, s, true
+}
+
+// parseParamValue parses an escaped parameter value up to and including the
+// closing quote and returns the unescaped value and the number of bytes
+// consumed
+func parseParamValue(s string) (string, int, bool) {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\' || s[i+1] == ']') {
+				i++
+			}
+			b.WriteByte(s[i])
+		case '"':
+			return b.String(), i + 1, true
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return "", 0, false
+}
+
+// parseRFC3164 parses the part of a BSD syslog message following the PRI
+func parseRFC3164(m *Message, s string, now time.Time) {
+	m.Format = FormatRFC3164
+	m.Timestamp = now
+
+	// TIMESTAMP is "Mmm dd hh:mm:ss" where days < 10 are padded with a space
+	if len(s) >= 16 && s[15] == ' ' {
+		if ts, err := time.ParseInLocation(time.Stamp, s[:15], now.Location()); err == nil {
+			ts = ts.AddDate(now.Year(), 0, 0)
+
+			// messages from late December received in early January
+			if ts.After(now.AddDate(0, 1, 0)) {
+				ts = ts.AddDate(-1, 0, 0)
+			}
+
+			m.Timestamp = ts
+			s = s[16:]
+
+			if idx := strings.IndexByte(s, ' '); idx > 0 {
+				m.Host = s[:idx]
+				s = s[idx+1:]
+			}
+		}
+	}
+
+	// TAG is terminated by the first non-alphanumeric character, usually
+	// "[pid]:" or ":"
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return r == ':' || r == '[' || r == ' '
+	})
+
+	if end > 0 && end <= 48 && (s[end] == ':' || s[end] == '[') {
+		m.App = s[:end]
+		s = s[end:]
+
+		if strings.HasPrefix(s, "[") {
+			if idx := strings.IndexByte(s, ']'); idx > 0 {
+				m.ProcID = s[1:idx]
+				s = s[idx+1:]
+			}
+		}
+
+		s = strings.TrimPrefix(s, ":")
+		s = strings.TrimPrefix(s, " ")
+	}
+
+	m.Message = s
+}
+
+// nilValue returns s or an empty string if s is the RFC5424 NILVALUE
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+
+	return s
+}
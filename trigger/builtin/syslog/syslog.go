@@ -0,0 +1,339 @@
+package syslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/trigger"
+)
+
+var (
+	// ErrUnknownProtocol is returned when the `protocol` configuration key
+	// holds an unsupported value
+	ErrUnknownProtocol = errors.New("unknown protocol, expected udp, tcp or both")
+
+	// ErrMessageTooLong is returned for newline delimited messages exceeding
+	// the maximum message size. The message is discarded
+	ErrMessageTooLong = errors.New("message too long")
+)
+
+// maxMessageSize is the maximum size of a single syslog message
+const maxMessageSize = 64 * 1024
+
+// maxPrefixSize is the maximum number of digits of the octet count preceding
+// a message, enough for messages of maxMessageSize
+const maxPrefixSize = 5
+
+// Server is a trigger.Trigger that receives syslog messages over UDP and/or
+// TCP and fires for each message
+type Server struct {
+	udp net.PacketConn
+	tcp net.Listener
+
+	// now returns the current time and may be replaced in tests
+	now func() time.Time
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+
+	events chan sigma.Event
+	errors chan error
+	closed chan struct{}
+	wg     sync.WaitGroup
+}
+
+// URN returns the URN for the syslog server
+func (s *Server) URN() string { return "syslog" }
+
+// Close stops listening and closes all TCP connections
+func (s *Server) Close() error {
+	select {
+	case <-s.closed:
+		return errors.New("already closed")
+	default:
+		close(s.closed)
+	}
+
+	var err error
+
+	if s.udp != nil {
+		err = s.udp.Close()
+	}
+
+	if s.tcp != nil {
+		if e := s.tcp.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	return err
+}
+
+// Next waits for the next syslog message and returns it as a sigma event
+func (s *Server) Next() (sigma.Event, error) {
+	select {
+	case evt := <-s.events:
+		return evt, nil
+	case err := <-s.errors:
+		return nil, err
+	case <-s.closed:
+		return nil, io.EOF
+	}
+}
+
+// UDPAddr returns the address of the UDP listener, if any
+func (s *Server) UDPAddr() net.Addr {
+	if s.udp == nil {
+		return nil
+	}
+
+	return s.udp.LocalAddr()
+}
+
+// TCPAddr returns the address of the TCP listener, if any
+func (s *Server) TCPAddr() net.Addr {
+	if s.tcp == nil {
+		return nil
+	}
+
+	return s.tcp.Addr()
+}
+
+func (s *Server) emit(raw []byte, remote net.Addr) {
+	msg := Parse(raw, s.now())
+
+	if remote != nil {
+		msg.Remote = remote.String()
+	}
+
+	blob, _ := json.Marshal(msg)
+
+	select {
+	case s.events <- sigma.NewSimpleEvent("syslog", blob):
+	case <-s.closed:
+	}
+}
+
+func (s *Server) fail(err error) {
+	select {
+	case <-s.closed:
+	case s.errors <- err:
+	}
+}
+
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+
+	buf := make([]byte, maxMessageSize)
+
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			s.fail(err)
+			return
+		}
+
+		raw := make([]byte, n)
+		copy(raw, buf[:n])
+
+		s.emit(raw, addr)
+	}
+}
+
+func (s *Server) serveTCP() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+
+		s.mu.Lock()
+		select {
+		case <-s.closed:
+			s.mu.Unlock()
+			conn.Close()
+			return
+		default:
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn reads messages from a TCP connection. Both octet-counting and
+// non-transparent (newline) framing as described in RFC6587 are supported
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReaderSize(conn, maxMessageSize)
+
+	for {
+		raw, err := readFrame(r)
+		if len(raw) > 0 {
+			s.emit(raw, conn.RemoteAddr())
+		}
+
+		if err == ErrMessageTooLong {
+			continue
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readFrame reads the next syslog message from r
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] < '1' || first[0] > '9' {
+		line, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// discard the rest of overlong lines
+			for err == bufio.ErrBufferFull {
+				_, err = r.ReadSlice('\n')
+			}
+
+			if err != nil {
+				return nil, err
+			}
+			return nil, ErrMessageTooLong
+		}
+		return append([]byte(nil), line...), err
+	}
+
+	var prefix []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if c == ' ' {
+			break
+		}
+
+		prefix = append(prefix, c)
+		if len(prefix) > maxPrefixSize {
+			return nil, fmt.Errorf("invalid message length %q", prefix)
+		}
+	}
+
+	n, err := strconv.Atoi(string(prefix))
+	if err != nil || n > maxMessageSize {
+		return nil, fmt.Errorf("invalid message length %q", prefix)
+	}
+
+	raw := make([]byte, n)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, err
+	}
+
+	return raw, nil
+}
+
+// Factory is a trigger.Factory for syslog servers
+type Factory struct{}
+
+// Build builds a new syslog server and implements trigger.Factory
+//
+// Supported options:
+//
+//	address   - the address to listen on (default: ":514")
+//	protocol  - one of udp, tcp or both (default: udp)
+//
+// Messages in RFC5424 and RFC3164 format are parsed into a JSON payload with
+// the keys format, facility, facilityName, severity, severityName,
+// timestamp, host, app, procId, msgId, structuredData, message and remote
+func (f Factory) Build(opts map[string]string) (trigger.Trigger, error) {
+	address := opts["address"]
+	if address == "" {
+		address = ":514"
+	}
+
+	protocol := opts["protocol"]
+	if protocol == "" {
+		protocol = "udp"
+	}
+
+	s := &Server{
+		now:    time.Now,
+		conns:  make(map[net.Conn]struct{}),
+		events: make(chan sigma.Event),
+		errors: make(chan error),
+		closed: make(chan struct{}),
+	}
+
+	var err error
+
+	switch protocol {
+	case "udp":
+		s.udp, err = net.ListenPacket("udp", address)
+	case "tcp":
+		s.tcp, err = net.Listen("tcp", address)
+	case "both":
+		s.tcp, err = net.Listen("tcp", address)
+		if err == nil {
+			// use the port of the TCP listener in case a random port
+			// has been requested
+			s.udp, err = net.ListenPacket("udp", s.tcp.Addr().String())
+			if err != nil {
+				s.tcp.Close()
+			}
+		}
+	default:
+		return nil, ErrUnknownProtocol
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if s.udp != nil {
+		s.wg.Add(1)
+		go s.serveUDP()
+	}
+
+	if s.tcp != nil {
+		s.wg.Add(1)
+		go s.serveTCP()
+	}
+
+	return s, nil
+}
+
+func init() {
+	trigger.Register("syslog", &Factory{})
+}
@@ -0,0 +1,222 @@
+package syslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/trigger"
+	"github.com/stretchr/testify/assert"
+)
+
+var now = time.Date(2018, 3, 10, 12, 0, 0, 0, time.UTC)
+
+func TestParse_RFC5424(t *testing.T) {
+	assert := assert.New(t)
+
+	m := Parse([]byte(`<165>1 2018-03-10T11:59:58.123Z nas.lan backup 4711 ID47 [exampleSDID@32473 iut="3" eventSource="App\"lication"][meta seq="1"] `+"\ufeffBackup failed\n"), now)
+
+	assert.Equal(FormatRFC5424, m.Format)
+	assert.Equal(20, m.Facility)
+	assert.Equal("local4", m.FacilityName)
+	assert.Equal(5, m.Severity)
+	assert.Equal("notice", m.SeverityName)
+	assert.Equal(time.Date(2018, 3, 10, 11, 59, 58, 123000000, time.UTC), m.Timestamp)
+	assert.Equal("nas.lan", m.Host)
+	assert.Equal("backup", m.App)
+	assert.Equal("4711", m.ProcID)
+	assert.Equal("ID47", m.MsgID)
+	assert.Equal(map[string]map[string]string{
+		"exampleSDID@32473": {"iut": "3", "eventSource": `App"lication`},
+		"meta":              {"seq": "1"},
+	}, m.StructuredData)
+	assert.Equal("Backup failed", m.Message)
+
+	// nil values and no message
+	m = Parse([]byte(`<14>1 - - - - - -`), now)
+	assert.Equal(FormatRFC5424, m.Format)
+	assert.Equal(now, m.Timestamp)
+	assert.Equal("", m.Host)
+	assert.Equal("", m.Message)
+}
+
+func TestParse_RFC3164(t *testing.T) {
+	assert := assert.New(t)
+
+	m := Parse([]byte(`<34>Mar  9 22:14:15 router sshd[123]: Failed password for root`), now)
+	assert.Equal(FormatRFC3164, m.Format)
+	assert.Equal("auth", m.FacilityName)
+	assert.Equal("crit", m.SeverityName)
+	assert.Equal(time.Date(2018, 3, 9, 22, 14, 15, 0, time.UTC), m.Timestamp)
+	assert.Equal("router", m.Host)
+	assert.Equal("sshd", m.App)
+	assert.Equal("123", m.ProcID)
+	assert.Equal("Failed password for root", m.Message)
+
+	// messages from last year
+	m = Parse([]byte(`<13>Dec 31 23:59:59 host app: bye`), time.Date(2018, 1, 1, 0, 0, 1, 0, time.UTC))
+	assert.Equal(2017, m.Timestamp.Year())
+
+	// no timestamp and no tag
+	m = Parse([]byte(`<11>something broke`), now)
+	assert.Equal(now, m.Timestamp)
+	assert.Equal("", m.App)
+	assert.Equal("something broke", m.Message)
+
+	// no PRI
+	m = Parse([]byte(`hello`), now)
+	assert.Equal("user", m.FacilityName)
+	assert.Equal("notice", m.SeverityName)
+	assert.Equal("hello", m.Message)
+}
+
+func build(t *testing.T, protocol string) *Server {
+	tr, err := Factory{}.Build(map[string]string{
+		"address":  "127.0.0.1:0",
+		"protocol": protocol,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tr.(*Server)
+}
+
+func next(t *testing.T, s *Server) Message {
+	res := make(chan sigma.Event, 1)
+
+	go func() {
+		evt, err := s.Next()
+		if err == nil {
+			res <- evt
+		}
+	}()
+
+	select {
+	case evt := <-res:
+		var m Message
+		if err := json.Unmarshal(evt.Payload(), &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for message")
+		return Message{}
+	}
+}
+
+func TestFactory_UnknownProtocol(t *testing.T) {
+	_, err := Factory{}.Build(map[string]string{"protocol": "sctp"})
+	assert.Equal(t, ErrUnknownProtocol, err)
+}
+
+func TestServer_UDP(t *testing.T) {
+	assert := assert.New(t)
+
+	s := build(t, "udp")
+	defer s.Close()
+
+	conn, err := net.Dial("udp", s.UDPAddr().String())
+	if !assert.NoError(err) {
+		return
+	}
+	defer conn.Close()
+
+	fmt.Fprint(conn, `<11>1 2018-03-10T12:00:00Z router kernel - - - link down`)
+
+	m := next(t, s)
+	assert.Equal("router", m.Host)
+	assert.Equal("link down", m.Message)
+	assert.NotEmpty(m.Remote)
+
+	assert.NoError(s.Close())
+	_, err = s.Next()
+	assert.Error(err)
+}
+
+func TestServer_TCP(t *testing.T) {
+	assert := assert.New(t)
+
+	s := build(t, "both")
+	defer s.Close()
+
+	assert.NotNil(s.UDPAddr())
+
+	conn, err := net.Dial("tcp", s.TCPAddr().String())
+	if !assert.NoError(err) {
+		return
+	}
+	defer conn.Close()
+
+	// octet-counting and newline framing may be mixed
+	msg := `<14>1 - nas app - - - first`
+	fmt.Fprintf(conn, "%d %s", len(msg), msg)
+	fmt.Fprint(conn, "<14>Mar 10 11:00:00 nas app: second\n")
+
+	assert.Equal("first", next(t, s).Message)
+	assert.Equal("second", next(t, s).Message)
+}
+
+func TestReadFrame_TooLong(t *testing.T) {
+	assert := assert.New(t)
+
+	long := "<14>Mar 10 11:00:00 nas app: " + strings.Repeat("x", 128) + "\n"
+	r := bufio.NewReaderSize(strings.NewReader(long+"<14>Mar 10 11:00:00 nas app: short\n"), 64)
+
+	raw, err := readFrame(r)
+	assert.Nil(raw)
+	assert.Equal(ErrMessageTooLong, err)
+
+	// the connection continues with the next message
+	raw, err = readFrame(r)
+	assert.NoError(err)
+	assert.Equal("<14>Mar 10 11:00:00 nas app: short\n", string(raw))
+
+	// messages cut off by the end of the connection are not reported
+	r = bufio.NewReaderSize(strings.NewReader(long[:100]), 64)
+	raw, err = readFrame(r)
+	assert.Nil(raw)
+	assert.Equal(io.EOF, err)
+}
+
+func TestReadFrame_OctetCounting(t *testing.T) {
+	assert := assert.New(t)
+
+	r := bufio.NewReader(strings.NewReader("5 hello"))
+	raw, err := readFrame(r)
+	assert.NoError(err)
+	assert.Equal("hello", string(raw))
+
+	// the octet count is not read beyond a few digits
+	r = bufio.NewReader(strings.NewReader(strings.Repeat("1", 1024) + " hello"))
+	raw, err = readFrame(r)
+	assert.Nil(raw)
+	assert.Error(err)
+	assert.True(r.Buffered() > 1000)
+
+	r = bufio.NewReader(strings.NewReader("99999 hello"))
+	_, err = readFrame(r)
+	assert.Error(err)
+}
+
+func TestMessage_Condition(t *testing.T) {
+	assert := assert.New(t)
+
+	blob, _ := json.Marshal(Parse([]byte(`<34>Mar  9 22:14:15 router sshd[123]: Failed password for root`), now))
+	evt := sigma.NewSimpleEvent("syslog", blob)
+
+	c, err := trigger.CompileCondition(`json(payload).severity <= 3 && json(payload).app == "sshd" && contains(json(payload).message, "Failed")`)
+	if !assert.NoError(err) {
+		return
+	}
+
+	ok, err := c.Evaluate(evt, nil)
+	assert.NoError(err)
+	assert.True(ok)
+}
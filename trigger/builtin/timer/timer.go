@@ -3,7 +3,10 @@ package timer
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"math/rand"
+	"strconv"
 	"time"
 
 	"github.com/homebot/sigma"
@@ -14,12 +17,43 @@ var (
 	// ErrMissingInterval is returned when the `interval` configuration key
 	// is missing during Build()
 	ErrMissingInterval = errors.New("missing `interval` configuration key")
+
+	// ErrUnknownPolicy is returned when the `missed` configuration key holds
+	// an unsupported missed-tick policy
+	ErrUnknownPolicy = errors.New("unknown missed-tick policy, expected skip or catchup")
+)
+
+const (
+	// PolicySkip coalesces all missed ticks into a single tick that fires
+	// immediately. The number of skipped ticks is reported in the payload
+	PolicySkip = "skip"
+
+	// PolicyCatchUp fires all missed ticks back-to-back
+	PolicyCatchUp = "catchup"
 )
 
-// Timer is a trigger.Trigger that fires after a given interval
+// Timer is a trigger.Trigger that fires after a given interval. Ticks are
+// scheduled on a fixed grid starting at the first tick so they do not drift
+// if the dispatcher falls behind
 type Timer struct {
-	timer  *time.Ticker
-	i      int64
+	interval  time.Duration
+	jitter    time.Duration
+	catchUp   bool
+	immediate bool
+	maxTicks  int64
+
+	// start is the time of the first scheduled tick
+	start time.Time
+
+	// now returns the current time and may be replaced in tests
+	now  func() time.Time
+	rand *rand.Rand
+
+	// i is the number of ticks fired and k the index of the next scheduled
+	// tick on the grid
+	i int64
+	k int64
+
 	closed chan struct{}
 }
 
@@ -34,7 +68,6 @@ func (t *Timer) Close() error {
 	default:
 		close(t.closed)
 	}
-	t.timer.Stop()
 
 	return nil
 }
@@ -43,37 +76,188 @@ func (t *Timer) Close() error {
 // time as an event
 func (t *Timer) Next() (sigma.Event, error) {
 	select {
-	case cur := <-t.timer.C:
+	case <-t.closed:
+		return nil, io.EOF
+	default:
+	}
+
+	if t.maxTicks > 0 && t.i >= t.maxTicks {
+		return nil, io.EOF
+	}
+
+	if t.immediate && t.i == 0 {
 		t.i++
-		blob, _ := json.Marshal(map[string]interface{}{
-			"time":      cur.Format(time.RFC3339),
-			"timestamp": cur.Unix(),
-			"tick":      t.i,
-		})
-		return sigma.NewSimpleEvent("timer", blob), nil
+		return t.event(t.now(), 0), nil
+	}
+
+	scheduled := t.start.Add(time.Duration(t.k) * t.interval)
+
+	var missed int64
+	if !t.catchUp {
+		if late := t.now().Sub(scheduled); late >= t.interval {
+			missed = int64(late / t.interval)
+			t.k += missed
+			scheduled = scheduled.Add(time.Duration(missed) * t.interval)
+		}
+	}
+
+	if t.jitter > 0 {
+		scheduled = scheduled.Add(time.Duration(t.rand.Int63n(int64(t.jitter))))
+	}
+
+	timer := time.NewTimer(scheduled.Sub(t.now()))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
 	case <-t.closed:
 		return nil, io.EOF
 	}
+
+	t.k++
+	t.i++
+
+	return t.event(scheduled, missed), nil
+}
+
+func (t *Timer) event(at time.Time, missed int64) sigma.Event {
+	payload := map[string]interface{}{
+		"time":      at.Format(time.RFC3339),
+		"timestamp": at.Unix(),
+		"tick":      t.i,
+	}
+
+	if missed > 0 {
+		payload["missed"] = missed
+	}
+
+	blob, _ := json.Marshal(payload)
+	return sigma.NewSimpleEvent("timer", blob)
+}
+
+// parseStartAt parses an RFC3339 timestamp or a time of day ("15:04" or
+// "15:04:05") which refers to it's next occurrence in local time
+func parseStartAt(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		tod, err := time.ParseInLocation(layout, s, now.Location())
+		if err != nil {
+			continue
+		}
+
+		y, m, d := now.Date()
+		t := time.Date(y, m, d, tod.Hour(), tod.Minute(), tod.Second(), 0, now.Location())
+		if t.Before(now) {
+			t = t.AddDate(0, 0, 1)
+		}
+
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid value for `startAt`: %q", s)
 }
 
 // Factory is trigger.Factory for timers
 type Factory struct{}
 
 // Build builds a new timer trigger and implements trigger.Factory
+//
+// Supported options:
+//
+//	interval   - the interval between two ticks (required)
+//	jitter     - a random delay of up to the given duration added to each tick
+//	alignTo    - align ticks to wall-clock boundaries (relative to UTC),
+//	             e.g. "1m" fires on the full minute
+//	startAt    - the time of the first tick as RFC3339 or time of day ("15:04")
+//	immediate  - fire once when the timer is started (default: false)
+//	maxTicks   - the number of ticks after which the timer finishes (default: unlimited)
+//	missed     - the policy for ticks missed while the dispatcher fell behind,
+//	             either skip or catchup (default: skip)
 func (f Factory) Build(opts map[string]string) (trigger.Trigger, error) {
+	return build(opts, time.Now)
+}
+
+func build(opts map[string]string, now func() time.Time) (*Timer, error) {
 	is, ok := opts["interval"]
 	if !ok {
 		return nil, ErrMissingInterval
 	}
 
-	duration, err := time.ParseDuration(is)
+	interval, err := time.ParseDuration(is)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Timer{
-		timer: time.NewTicker(duration),
-	}, nil
+	if interval <= 0 {
+		return nil, errors.New("`interval` must be positive")
+	}
+
+	t := &Timer{
+		interval: interval,
+		now:      now,
+		rand:     rand.New(rand.NewSource(now().UnixNano())),
+		closed:   make(chan struct{}),
+	}
+
+	if s, ok := opts["jitter"]; ok {
+		if t.jitter, err = time.ParseDuration(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s, ok := opts["immediate"]; ok {
+		if t.immediate, err = strconv.ParseBool(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s, ok := opts["maxTicks"]; ok {
+		if t.maxTicks, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+
+	switch opts["missed"] {
+	case "", PolicySkip:
+	case PolicyCatchUp:
+		t.catchUp = true
+	default:
+		return nil, ErrUnknownPolicy
+	}
+
+	start := now().Add(interval)
+
+	if s, ok := opts["startAt"]; ok {
+		if start, err = parseStartAt(s, now()); err != nil {
+			return nil, err
+		}
+	}
+
+	if s, ok := opts["alignTo"]; ok {
+		align, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, err
+		}
+
+		if align > 0 {
+			base := start
+			if _, ok := opts["startAt"]; !ok {
+				base = now()
+			}
+
+			start = base.Truncate(align)
+			if start.Before(base) {
+				start = start.Add(align)
+			}
+		}
+	}
+
+	t.start = start
+
+	return t, nil
 }
 
 func init() {
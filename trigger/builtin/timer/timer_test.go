@@ -0,0 +1,207 @@
+package timer
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type tick struct {
+	Time   string
+	Tick   int64
+	Missed int64
+}
+
+func next(t *testing.T, tr *Timer) tick {
+	evt, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res tick
+	if err := json.Unmarshal(evt.Payload(), &res); err != nil {
+		t.Fatal(err)
+	}
+
+	return res
+}
+
+func TestFactory_Invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	for name, opts := range map[string]map[string]string{
+		"interval": {},
+		"zero":     {"interval": "0s"},
+		"jitter":   {"interval": "1s", "jitter": "x"},
+		"startAt":  {"interval": "1s", "startAt": "tomorrow"},
+		"maxTicks": {"interval": "1s", "maxTicks": "many"},
+		"missed":   {"interval": "1s", "missed": "panic"},
+	} {
+		_, err := Factory{}.Build(opts)
+		assert.Error(err, name)
+	}
+
+	_, err := Factory{}.Build(map[string]string{})
+	assert.Equal(ErrMissingInterval, err)
+}
+
+func TestTimer_Close(t *testing.T) {
+	assert := assert.New(t)
+
+	tr, err := Factory{}.Build(map[string]string{"interval": "1h"})
+	if !assert.NoError(err) {
+		return
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := tr.Next()
+		done <- err
+	}()
+
+	assert.NoError(tr.Close())
+	assert.Error(tr.Close())
+
+	select {
+	case err := <-done:
+		assert.Equal(io.EOF, err)
+	case <-time.After(time.Second):
+		t.Fatal("Next() did not return after Close()")
+	}
+}
+
+func TestTimer_ImmediateAndMaxTicks(t *testing.T) {
+	assert := assert.New(t)
+
+	tr, err := build(map[string]string{
+		"interval":  "1ms",
+		"immediate": "true",
+		"maxTicks":  "3",
+	}, time.Now)
+	if !assert.NoError(err) {
+		return
+	}
+	defer tr.Close()
+
+	assert.Equal(int64(1), next(t, tr).Tick)
+	assert.Equal(int64(2), next(t, tr).Tick)
+	assert.Equal(int64(3), next(t, tr).Tick)
+
+	_, err = tr.Next()
+	assert.Equal(io.EOF, err)
+}
+
+func TestTimer_Immediate(t *testing.T) {
+	assert := assert.New(t)
+
+	tr, err := build(map[string]string{
+		"interval":  "1h",
+		"immediate": "true",
+	}, time.Now)
+	if !assert.NoError(err) {
+		return
+	}
+	defer tr.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := tr.Next()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		assert.NoError(err)
+	case <-time.After(time.Second):
+		t.Fatal("immediate tick did not fire")
+	}
+}
+
+func TestTimer_AlignTo(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2018, 1, 1, 10, 0, 20, 500, time.UTC)
+	clock := func() time.Time { return now }
+
+	tr, err := build(map[string]string{"interval": "5m", "alignTo": "1m"}, clock)
+	assert.NoError(err)
+	assert.Equal(time.Date(2018, 1, 1, 10, 1, 0, 0, time.UTC), tr.start)
+
+	tr, err = build(map[string]string{"interval": "1h", "startAt": "11:30", "alignTo": "1h"}, clock)
+	assert.NoError(err)
+	assert.Equal(time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC), tr.start)
+
+	// times of day in the past refer to the next day
+	tr, err = build(map[string]string{"interval": "24h", "startAt": "09:00"}, clock)
+	assert.NoError(err)
+	assert.Equal(time.Date(2018, 1, 2, 9, 0, 0, 0, time.UTC), tr.start)
+}
+
+func TestTimer_MissedTicks(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2018, 1, 1, 3, 30, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	opts := map[string]string{
+		"interval": "1h",
+		"startAt":  "2018-01-01T00:00:00Z",
+	}
+
+	// skip fires once for the last missed tick
+	tr, err := build(opts, clock)
+	if !assert.NoError(err) {
+		return
+	}
+
+	tk := next(t, tr)
+	assert.Equal("2018-01-01T03:00:00Z", tk.Time)
+	assert.Equal(int64(3), tk.Missed)
+	tr.Close()
+
+	// catchup fires all missed ticks
+	opts["missed"] = PolicyCatchUp
+	tr, err = build(opts, clock)
+	if !assert.NoError(err) {
+		return
+	}
+	defer tr.Close()
+
+	for i, expected := range []string{
+		"2018-01-01T00:00:00Z",
+		"2018-01-01T01:00:00Z",
+		"2018-01-01T02:00:00Z",
+		"2018-01-01T03:00:00Z",
+	} {
+		tk := next(t, tr)
+		assert.Equal(expected, tk.Time)
+		assert.Equal(int64(i+1), tk.Tick)
+		assert.Equal(int64(0), tk.Missed)
+	}
+}
+
+func TestTimer_Jitter(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now.Add(time.Hour) }
+
+	tr, err := build(map[string]string{
+		"interval": "1h",
+		"startAt":  "2018-01-01T00:00:00Z",
+		"jitter":   "1m",
+		"missed":   PolicyCatchUp,
+	}, clock)
+	if !assert.NoError(err) {
+		return
+	}
+	defer tr.Close()
+
+	at, err := time.Parse(time.RFC3339, next(t, tr).Time)
+	assert.NoError(err)
+	assert.False(at.Before(now))
+	assert.True(at.Before(now.Add(time.Minute)))
+}
@@ -1,20 +1,320 @@
 package trigger
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Knetic/govaluate"
-	"github.com/homebot/core/utils"
 	"github.com/homebot/sigma"
 	"github.com/yalp/jsonpath"
 )
 
-func getTimePart(t interface{}, what string) (interface{}, error) {
+// Condition is a compiled trigger condition. Conditions are govaluate
+// expressions with access to the event `type`, the event `payload` and all
+// function parameters.
+//
+// The following functions are available within conditions:
+//
+//	jsonpath(blob, path)          evaluates a JSONPath expression on blob
+//	json(blob).field.0.name       decodes blob and accesses the given field
+//	contains(string, substring)   true if string contains substring
+//	regex(string, pattern)        true if string matches pattern
+//	now()                         the current time in seconds since the epoch
+//	second/minute/hour/day/month/year/weekday(date [, timezone])
+//	number(value)                 converts value to a number
+//	string(value)                 converts value to a string
+//
+// Lists can be tested using the `in` operator, e.g. `type in ("a", "b")`
+type Condition struct {
+	source string
+	expr   *govaluate.EvaluableExpression
+
+	// now returns the current time and may be replaced during tests
+	now func() time.Time
+
+	// the last JSON blob decoded, so the payload is only decoded once per
+	// event even if it's accessed multiple times
+	jsonLock  sync.Mutex
+	lastBlob  string
+	lastValue interface{}
+
+	cacheLock sync.Mutex
+	patterns  map[string]*regexp.Regexp
+	locations map[string]*time.Location
+}
+
+// CompileCondition compiles the condition expression. An empty condition is
+// always satisfied
+func CompileCondition(condition string) (*Condition, error) {
+	c := &Condition{
+		source:    condition,
+		now:       time.Now,
+		patterns:  make(map[string]*regexp.Regexp),
+		locations: make(map[string]*time.Location),
+	}
+
+	if condition == "" {
+		return c, nil
+	}
+
+	expr, err := govaluate.NewEvaluableExpressionWithFunctions(rewriteAccessors(condition), c.functions())
+	if err != nil {
+		return nil, err
+	}
+
+	c.expr = expr
+
+	return c, nil
+}
+
+// String returns the source of the condition
+func (c *Condition) String() string {
+	return c.source
+}
+
+// Evaluate evaluates the condition on event
+func (c *Condition) Evaluate(event sigma.Event, values sigma.ValueMap) (bool, error) {
+	if c == nil || c.expr == nil {
+		return true, nil
+	}
+
+	parameters := map[string]interface{}{
+		"type":    event.Type(),
+		"payload": string(event.Payload()),
+	}
+
+	for k, v := range values {
+		parameters[k] = v
+	}
+
+	res, err := c.expr.Evaluate(parameters)
+	if err != nil {
+		return false, err
+	}
+
+	b, ok := res.(bool)
+	if ok {
+		return b, nil
+	}
+
+	return false, fmt.Errorf("unsupported return value: %#v (%s)", res, reflect.TypeOf(res))
+}
+
+// Evaluate evaluates the condtion on event. Conditions that are evaluated
+// more than once should be compiled using CompileCondition
+func Evaluate(condtion string, event sigma.Event, values sigma.ValueMap) (bool, error) {
+	c, err := CompileCondition(condtion)
+	if err != nil {
+		return false, err
+	}
+
+	return c.Evaluate(event, values)
+}
+
+func (c *Condition) functions() map[string]govaluate.ExpressionFunction {
+	return map[string]govaluate.ExpressionFunction{
+		"jsonpath": func(args ...interface{}) (interface{}, error) {
+			if len(args) != 2 {
+				return nil, errors.New("usage: jsonpath(blob, path)")
+			}
+
+			blob, ok := args[0].(string)
+			if !ok {
+				return nil, errors.New("`blob` mustbe string")
+			}
+
+			path, ok := args[1].(string)
+			if !ok {
+				return nil, errors.New("`path` must be string")
+			}
+
+			res, err := c.decode(blob)
+			if err != nil {
+				return nil, err
+			}
+
+			return jsonpath.Read(res, path)
+		},
+		"json": func(args ...interface{}) (interface{}, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return nil, errors.New("usage: json(blob).field")
+			}
+
+			blob, ok := args[0].(string)
+			if !ok {
+				return nil, errors.New("`blob` must be string")
+			}
+
+			res, err := c.decode(blob)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(args) == 1 {
+				return res, nil
+			}
+
+			path, ok := args[1].(string)
+			if !ok {
+				return nil, errors.New("`path` must be string")
+			}
+
+			return getField(res, path)
+		},
+		"contains": func(args ...interface{}) (interface{}, error) {
+			if len(args) != 2 {
+				return nil, errors.New("usage: contains(string, substring)")
+			}
+
+			s1, ok1 := args[0].(string)
+			s2, ok2 := args[1].(string)
+
+			if !ok1 || !ok2 {
+				return nil, errors.New("string and substring must be strings")
+			}
+
+			return strings.Contains(s1, s2), nil
+		},
+		"regex": func(args ...interface{}) (interface{}, error) {
+			if len(args) != 2 {
+				return nil, errors.New("usage: regex(string, pattern)")
+			}
+
+			s, ok1 := args[0].(string)
+			pattern, ok2 := args[1].(string)
+
+			if !ok1 || !ok2 {
+				return nil, errors.New("string and pattern must be strings")
+			}
+
+			re, err := c.compileRegexp(pattern)
+			if err != nil {
+				return nil, err
+			}
+
+			return re.MatchString(s), nil
+		},
+		"now": func(args ...interface{}) (interface{}, error) {
+			if len(args) != 0 {
+				return nil, errors.New("usage: now()")
+			}
+
+			return float64(c.now().Unix()), nil
+		},
+		"number": func(args ...interface{}) (interface{}, error) {
+			if len(args) != 1 {
+				return nil, errors.New("usage: number(value)")
+			}
+
+			return toNumber(args[0])
+		},
+		"string": func(args ...interface{}) (interface{}, error) {
+			if len(args) != 1 {
+				return nil, errors.New("usage: string(value)")
+			}
+
+			return toString(args[0]), nil
+		},
+		"second":  c.buildTimeFunc("second"),
+		"minute":  c.buildTimeFunc("minute"),
+		"hour":    c.buildTimeFunc("hour"),
+		"day":     c.buildTimeFunc("day"),
+		"month":   c.buildTimeFunc("month"),
+		"year":    c.buildTimeFunc("year"),
+		"weekday": c.buildTimeFunc("weekday"),
+	}
+}
+
+// decode decodes the JSON blob. The last decoded value is cached
+func (c *Condition) decode(blob string) (interface{}, error) {
+	c.jsonLock.Lock()
+	defer c.jsonLock.Unlock()
+
+	if c.lastValue != nil && blob == c.lastBlob {
+		return c.lastValue, nil
+	}
+
+	var res interface{}
+	if err := json.Unmarshal([]byte(blob), &res); err != nil {
+		return nil, err
+	}
+
+	c.lastBlob = blob
+	c.lastValue = res
+
+	return res, nil
+}
+
+func (c *Condition) compileRegexp(pattern string) (*regexp.Regexp, error) {
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+
+	if re, ok := c.patterns[pattern]; ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	c.patterns[pattern] = re
+
+	return re, nil
+}
+
+func (c *Condition) location(name string) (*time.Location, error) {
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+
+	if loc, ok := c.locations[name]; ok {
+		return loc, nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+
+	c.locations[name] = loc
+
+	return loc, nil
+}
+
+func (c *Condition) buildTimeFunc(what string) govaluate.ExpressionFunction {
+	return func(args ...interface{}) (interface{}, error) {
+		if len(args) < 1 || len(args) > 2 {
+			return nil, fmt.Errorf("usage: %s(date [, timezone])", what)
+		}
+
+		loc := time.Local
+
+		if len(args) == 2 {
+			name, ok := args[1].(string)
+			if !ok {
+				return nil, errors.New("`timezone` must be string")
+			}
+
+			var err error
+			loc, err = c.location(name)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return getTimePart(args[0], what, loc)
+	}
+}
+
+func getTimePart(t interface{}, what string, loc *time.Location) (interface{}, error) {
 	var tm time.Time
 
 	switch v := t.(type) {
@@ -28,7 +328,7 @@ func getTimePart(t interface{}, what string) (interface{}, error) {
 		tm = time.Unix(int64(v), 0)
 	case string:
 		var err error
-		tm, err = time.Parse(v, time.RFC3339)
+		tm, err = time.Parse(time.RFC3339, v)
 		if err != nil {
 			return nil, err
 		}
@@ -38,6 +338,8 @@ func getTimePart(t interface{}, what string) (interface{}, error) {
 		return nil, fmt.Errorf("invalid argument: %#v (%s)", t, reflect.TypeOf(t))
 	}
 
+	tm = tm.In(loc)
+
 	switch what {
 	case "hour":
 		return float64(tm.Hour()), nil
@@ -47,6 +349,10 @@ func getTimePart(t interface{}, what string) (interface{}, error) {
 		return float64(tm.Second()), nil
 	case "day":
 		return float64(tm.Day()), nil
+	case "month":
+		return float64(tm.Month()), nil
+	case "year":
+		return float64(tm.Year()), nil
 	case "weekday":
 		return tm.Weekday().String(), nil
 	default:
@@ -54,88 +360,171 @@ func getTimePart(t interface{}, what string) (interface{}, error) {
 	}
 }
 
-func buildTimeFunc(what string) func(...interface{}) (interface{}, error) {
-	return func(args ...interface{}) (interface{}, error) {
-		if len(args) != 1 {
-			return nil, fmt.Errorf("usage: %s(date)", what)
+// getField returns the field identified by the dot separated path. Numeric
+// path elements are used as indexes into lists. Missing fields evaluate to nil
+func getField(value interface{}, path string) (interface{}, error) {
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[key]
+		case []interface{}:
+			idx, err := strconv.Atoi(key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid list index %q", key)
+			}
+
+			if idx < 0 || idx >= len(v) {
+				return nil, nil
+			}
+
+			value = v[idx]
+		default:
+			return nil, nil
 		}
-		return getTimePart(args[0], what)
 	}
+
+	return value, nil
 }
 
-// Evaluate evaluates the condtion on event
-func Evaluate(condtion string, event sigma.Event, values utils.ValueMap) (bool, error) {
-	if condtion == "" {
-		return true, nil
+func toNumber(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case bool:
+		if v {
+			return float64(1), nil
+		}
+		return float64(0), nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	default:
+		return nil, fmt.Errorf("cannot convert %#v to number", value)
 	}
+}
 
-	functions := map[string]govaluate.ExpressionFunction{
-		"jsonpath": func(args ...interface{}) (interface{}, error) {
-			if len(args) != 2 {
-				return nil, errors.New("usage: jsonpath(blob, path)")
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// rewriteAccessors rewrites field accesses on the result of json() like
+// `json(payload).foo.bar` to `json(payload, "foo.bar")` because govaluate
+// does not support accessors on function results
+func rewriteAccessors(expr string) string {
+	var out bytes.Buffer
+
+	for i := 0; i < len(expr); {
+		ch := expr[i]
+
+		switch {
+		case ch == '"' || ch == '\'':
+			end := strings.IndexByte(expr[i+1:], ch)
+			if end < 0 {
+				out.WriteString(expr[i:])
+				return out.String()
 			}
+			out.WriteString(expr[i : i+end+2])
+			i += end + 2
 
-			blob, ok := args[0].(string)
-			if !ok {
-				return nil, errors.New("`blob` mustbe string")
+		case ch == '[':
+			// escaped parameter names
+			end := strings.IndexByte(expr[i:], ']')
+			if end < 0 {
+				out.WriteString(expr[i:])
+				return out.String()
 			}
+			out.WriteString(expr[i : i+end+1])
+			i += end + 1
 
-			path, ok := args[1].(string)
-			if !ok {
-				return nil, errors.New("`path` must be string")
+		case isIdentChar(ch):
+			start := i
+			for i < len(expr) && isIdentChar(expr[i]) {
+				i++
 			}
+			ident := expr[start:i]
 
-			var res interface{}
-			if err := json.Unmarshal([]byte(blob), &res); err != nil {
-				return nil, err
+			if ident != "json" || i >= len(expr) || expr[i] != '(' {
+				out.WriteString(ident)
+				continue
 			}
 
-			return jsonpath.Read(res, path)
-		},
-		"contains": func(args ...interface{}) (interface{}, error) {
-			if len(args) != 2 {
-				return nil, errors.New("usage: contains(string, substring)")
+			end := matchingParen(expr, i)
+			if end < 0 {
+				out.WriteString(expr[start:])
+				return out.String()
 			}
 
-			s1, ok1 := args[0].(string)
-			s2, ok2 := args[1].(string)
+			args := rewriteAccessors(expr[i+1 : end])
+			i = end + 1
 
-			if !ok1 || !ok2 {
-				return nil, errors.New("string and substring must be strings")
+			var path []string
+			for i+1 < len(expr) && expr[i] == '.' && isIdentChar(expr[i+1]) {
+				j := i + 1
+				for j < len(expr) && isIdentChar(expr[j]) {
+					j++
+				}
+				path = append(path, expr[i+1:j])
+				i = j
 			}
 
-			return strings.Contains(s1, s2), nil
-		},
-		"second":  buildTimeFunc("second"),
-		"minute":  buildTimeFunc("minute"),
-		"hour":    buildTimeFunc("hour"),
-		"day":     buildTimeFunc("day"),
-		"weekday": buildTimeFunc("weekday"),
-	}
+			if len(path) == 0 {
+				out.WriteString("json(" + args + ")")
+			} else {
+				out.WriteString("json(" + args + ", \"" + strings.Join(path, ".") + "\")")
+			}
 
-	expr, err := govaluate.NewEvaluableExpressionWithFunctions(condtion, functions)
-	if err != nil {
-		return false, err
+		default:
+			out.WriteByte(ch)
+			i++
+		}
 	}
 
-	parameters := map[string]interface{}{
-		"type":    event.Type(),
-		"payload": string(event.Payload()),
-	}
+	return out.String()
+}
 
-	for k, v := range values {
-		parameters[k] = v
-	}
+// matchingParen returns the index of the parenthesis closing the one at
+// expr[start] or -1
+func matchingParen(expr string, start int) int {
+	depth := 0
 
-	res, err := expr.Evaluate(parameters)
-	if err != nil {
-		return false, err
+	for i := start; i < len(expr); i++ {
+		switch expr[i] {
+		case '"', '\'':
+			end := strings.IndexByte(expr[i+1:], expr[i])
+			if end < 0 {
+				return -1
+			}
+			i += end + 1
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
 	}
 
-	b, ok := res.(bool)
-	if ok {
-		return b, nil
-	}
+	return -1
+}
 
-	return false, fmt.Errorf("unsupported return value: %#v (%s)", res, reflect.TypeOf(res))
+func isIdentChar(ch byte) bool {
+	return ch == '_' ||
+		(ch >= 'a' && ch <= 'z') ||
+		(ch >= 'A' && ch <= 'Z') ||
+		(ch >= '0' && ch <= '9')
 }
@@ -0,0 +1,123 @@
+package trigger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/homebot/sigma"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileCondition_Invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, cond := range []string{
+		"type ==",
+		"(type == \"foo\"",
+		"unknown(payload)",
+	} {
+		c, err := CompileCondition(cond)
+		assert.Error(err, cond)
+		assert.Nil(c, cond)
+	}
+}
+
+func TestCondition_Evaluate(t *testing.T) {
+	assert := assert.New(t)
+
+	evt := sigma.NewSimpleEvent("sensor", []byte(`{"temp": 21.5, "room": {"name": "kitchen"}, "tags": ["a", "b"], "count": "3"}`))
+	values := sigma.ValueMap{"threshold": float64(20)}
+
+	cases := map[string]bool{
+		"":                                                  true,
+		"type == \"sensor\"":                                true,
+		"type in (\"foo\", \"sensor\")":                     true,
+		"type in (\"foo\", \"bar\")":                        false,
+		"json(payload).temp > threshold":                    true,
+		"json(payload).room.name == \"kitchen\"":            true,
+		"json(payload).tags.1 == \"b\"":                     true,
+		"\"a\" in json(payload).tags":                       true,
+		"json(payload).missing == \"x\"":                    false,
+		"jsonpath(payload, \"$.room.name\") == \"kitchen\"": true,
+		"regex(json(payload).room.name, \"^kit\")":          true,
+		"regex(type, \"^foo$\")":                            false,
+		"number(json(payload).count) == 3":                  true,
+		"string(json(payload).temp) == \"21.5\"":            true,
+		"contains(\"json(payload).x\", \".x\")":             true,
+	}
+
+	for cond, expected := range cases {
+		c, err := CompileCondition(cond)
+		if !assert.NoError(err, cond) {
+			continue
+		}
+
+		res, err := c.Evaluate(evt, values)
+		assert.NoError(err, cond)
+		assert.Equal(expected, res, cond)
+	}
+}
+
+func TestCondition_Now(t *testing.T) {
+	assert := assert.New(t)
+
+	c, err := CompileCondition("hour(now(), \"UTC\") == 13 && weekday(now(), \"UTC\") == \"Monday\"")
+	assert.NoError(err)
+
+	c.now = func() time.Time {
+		return time.Date(2018, 1, 1, 13, 30, 0, 0, time.UTC)
+	}
+
+	res, err := c.Evaluate(sigma.NewSimpleEvent("test", nil), nil)
+	assert.NoError(err)
+	assert.True(res)
+}
+
+func TestGetTimePart(t *testing.T) {
+	assert := assert.New(t)
+
+	vienna, err := time.LoadLocation("Europe/Vienna")
+	if !assert.NoError(err) {
+		return
+	}
+
+	res, err := getTimePart("2018-01-01T13:30:15Z", "hour", time.UTC)
+	assert.NoError(err)
+	assert.Equal(float64(13), res)
+
+	res, err = getTimePart("2018-01-01T13:30:15Z", "hour", vienna)
+	assert.NoError(err)
+	assert.Equal(float64(14), res)
+
+	res, err = getTimePart("2018-01-01T13:30:15Z", "second", time.UTC)
+	assert.NoError(err)
+	assert.Equal(float64(15), res)
+
+	res, err = getTimePart(float64(1514813415), "minute", time.UTC)
+	assert.NoError(err)
+	assert.Equal(float64(30), res)
+
+	res, err = getTimePart("2018-01-01T13:30:15Z", "weekday", time.UTC)
+	assert.NoError(err)
+	assert.Equal("Monday", res)
+
+	_, err = getTimePart("not a date", "hour", time.UTC)
+	assert.Error(err)
+}
+
+func TestRewriteAccessors(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := map[string]string{
+		"json(payload).a.b == 1":      "json(payload, \"a.b\") == 1",
+		"json(payload) == nil":        "json(payload) == nil",
+		"json(json(payload).a).b":     "json(json(payload, \"a\"), \"b\")",
+		"\"json(payload).a\" == type": "\"json(payload).a\" == type",
+		"myjson(payload).a":           "myjson(payload).a",
+		"[json(payload).a] > 1.5":     "[json(payload).a] > 1.5",
+	}
+
+	for in, expected := range cases {
+		assert.Equal(expected, rewriteAccessors(in), in)
+	}
+}
@@ -0,0 +1,38 @@
+package trigger
+
+// temporary is implemented by errors that may resolve by themselves
+// (like net.Error)
+type temporary interface {
+	Temporary() bool
+}
+
+// permanentError marks an error as permanent
+type permanentError struct {
+	err error
+}
+
+func (p *permanentError) Error() string { return p.err.Error() }
+
+// Permanent marks err as permanent. Triggers returning a permanent error
+// from Next() are marked as failed and are not restarted
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanentError{err}
+}
+
+// IsPermanent returns true if err has been marked as permanent
+func IsPermanent(err error) bool {
+	_, ok := err.(*permanentError)
+	return ok
+}
+
+// IsTemporary returns true if err is temporary. Triggers returning a
+// temporary error from Next() are not rebuilt but Next() is retried after
+// a backoff delay
+func IsTemporary(err error) bool {
+	t, ok := err.(temporary)
+	return ok && t.Temporary()
+}
@@ -5,6 +5,11 @@ import (
 	"sync"
 )
 
+// OptionFunction is the option holding the ID of the function a trigger is
+// built for. It is set by the function controller and overwrites any value
+// configured in the trigger spec
+const OptionFunction = "sigma.function"
+
 // Factory builds a trigger
 type Factory interface {
 	Build(map[string]string) (Trigger, error)
@@ -24,6 +29,10 @@ type Builder interface {
 	Build(typ string, opts map[string]string) (Trigger, error)
 }
 
+// ErrTriggerRegistered is returned when a trigger type with the same name
+// has already been registered
+var ErrTriggerRegistered = errors.New("trigger already registered")
+
 var factories map[string]Factory
 var rw sync.RWMutex
 
@@ -36,16 +45,38 @@ func (d defaultBuilder) Build(typ string, opt map[string]string) (Trigger, error
 // DefaultBuilder is the default trigger builder
 var DefaultBuilder Builder = defaultBuilder{}
 
-// Register registers a new built-in trigger factory
+// Register registers a new built-in trigger factory. It panics if a trigger
+// with the same name has already been registered
 func Register(name string, f Factory) {
+	if err := RegisterFactory(name, f); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterFactory registers a new trigger factory at runtime. It returns
+// ErrTriggerRegistered if a trigger with the same name has already been
+// registered
+func RegisterFactory(name string, f Factory) error {
 	rw.Lock()
 	defer rw.Unlock()
 
 	if _, ok := factories[name]; ok {
-		panic("Trigger already registered")
+		return ErrTriggerRegistered
 	}
 
 	factories[name] = f
+
+	return nil
+}
+
+// IsRegistered returns true if a trigger with the given name has been
+// registered
+func IsRegistered(name string) bool {
+	rw.RLock()
+	defer rw.RUnlock()
+
+	_, ok := factories[name]
+	return ok
 }
 
 // Build builds the trigger with the given name
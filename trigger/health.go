@@ -0,0 +1,70 @@
+package trigger
+
+import "time"
+
+// Health describes the health of a trigger
+type Health string
+
+const (
+	// HealthRunning is set while the trigger is running without errors
+	HealthRunning = Health("running")
+
+	// HealthBackingOff is set when the trigger failed and is waiting to be
+	// restarted
+	HealthBackingOff = Health("backing-off")
+
+	// HealthFailed is set when the trigger failed permanently and will not
+	// be restarted
+	HealthFailed = Health("failed")
+
+	// HealthStopped is set when the trigger has been stopped or disabled
+	HealthStopped = Health("stopped")
+)
+
+// Backoff configures the exponential backoff used when restarting failed
+// triggers
+type Backoff struct {
+	// Initial is the delay before the first restart
+	Initial time.Duration
+
+	// Max is the maximum delay between two restarts
+	Max time.Duration
+
+	// MaxRetries is the maximum number of consecutive restarts before the
+	// trigger is marked as failed. Zero means unlimited
+	MaxRetries int
+}
+
+// DefaultBackoff is the default backoff for failed triggers
+var DefaultBackoff = Backoff{
+	Initial:    time.Second,
+	Max:        5 * time.Minute,
+	MaxRetries: 10,
+}
+
+// Delay returns the delay before the given attempt (starting at 1)
+func (b Backoff) Delay(attempt int) time.Duration {
+	d := b.Initial
+	if d <= 0 {
+		d = DefaultBackoff.Initial
+	}
+
+	max := b.Max
+	if max <= 0 {
+		max = DefaultBackoff.Max
+	}
+
+	for i := 1; i < attempt; i++ {
+		d *= 2
+
+		if d >= max {
+			return max
+		}
+	}
+
+	if d > max {
+		return max
+	}
+
+	return d
+}
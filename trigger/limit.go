@@ -0,0 +1,273 @@
+package trigger
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/homebot/sigma"
+)
+
+var (
+	// ErrInvalidRate is returned when a rate cannot be parsed
+	ErrInvalidRate = errors.New("invalid rate")
+)
+
+// Rate describes a number of events per time unit
+type Rate struct {
+	// Events is the number of events allowed per time unit
+	Events int
+
+	// Per is the time unit
+	Per time.Duration
+}
+
+// IsZero returns true if the rate is not set
+func (r Rate) IsZero() bool {
+	return r.Events == 0
+}
+
+// ParseRate parses a rate in the form "<events>/<unit>" where unit is either
+// "s", "m", "h" or a duration like "10s"
+func ParseRate(s string) (Rate, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return Rate{}, fmt.Errorf("%s: %q", ErrInvalidRate, s)
+	}
+
+	events, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || events <= 0 {
+		return Rate{}, fmt.Errorf("%s: %q", ErrInvalidRate, s)
+	}
+
+	var per time.Duration
+
+	switch unit := strings.TrimSpace(parts[1]); unit {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		per, err = time.ParseDuration(unit)
+		if err != nil || per <= 0 {
+			return Rate{}, fmt.Errorf("%s: %q", ErrInvalidRate, s)
+		}
+	}
+
+	return Rate{
+		Events: events,
+		Per:    per,
+	}, nil
+}
+
+// LimitOptions configures how the events of a trigger are limited
+type LimitOptions struct {
+	// Rate limits the number of events using a token bucket that holds up
+	// to Rate.Events tokens. Events are dropped if the bucket is empty
+	Rate Rate
+
+	// Debounce delays events until no further event has been received for
+	// the given duration and only emits the last one (trailing edge)
+	Debounce time.Duration
+
+	// Throttle emits the first event and drops all further events for the
+	// given duration (leading edge)
+	Throttle time.Duration
+}
+
+// Valid checks if the limit options are valid
+func (o LimitOptions) Valid() error {
+	if o.Rate.Events < 0 || (!o.Rate.IsZero() && o.Rate.Per <= 0) {
+		return ErrInvalidRate
+	}
+
+	if o.Debounce < 0 || o.Throttle < 0 {
+		return errors.New("debounce and throttle must not be negative")
+	}
+
+	return nil
+}
+
+// IsZero returns true if no limit is configured
+func (o LimitOptions) IsZero() bool {
+	return o.Rate.IsZero() && o.Debounce == 0 && o.Throttle == 0
+}
+
+// LimitStats holds the number of events suppressed by a limiter
+type LimitStats struct {
+	// Dropped is the number of events dropped due to the rate limit or
+	// throttling
+	Dropped int
+
+	// Coalesced is the number of events replaced by a later event while
+	// debouncing
+	Coalesced int
+}
+
+// Limiter applies throttling, debouncing and rate limiting (in that order)
+// to events and passes the remaining ones to an emit function. Events that
+// implement Replier and are dropped or replaced by a later event are rejected
+// using ErrEventDropped or ErrEventCoalesced
+type Limiter struct {
+	opts LimitOptions
+	emit func(sigma.Event)
+
+	// now returns the current time and may be replaced in tests
+	now func() time.Time
+
+	mu             sync.Mutex
+	tokens         float64
+	lastRefill     time.Time
+	throttledUntil time.Time
+	pending        sigma.Event
+	timer          *time.Timer
+	stats          LimitStats
+
+	// generation is incremented for each debounced event so a timer that
+	// already fired does not emit a newer one
+	generation int
+}
+
+// NewLimiter returns a new limiter that calls emit for each event passing
+// the limits
+func NewLimiter(opts LimitOptions, emit func(sigma.Event)) *Limiter {
+	return &Limiter{
+		opts:   opts,
+		emit:   emit,
+		now:    time.Now,
+		tokens: float64(opts.Rate.Events),
+	}
+}
+
+// Add adds an event to the limiter
+func (l *Limiter) Add(evt sigma.Event) {
+	l.mu.Lock()
+
+	if l.opts.Throttle > 0 {
+		now := l.now()
+		if now.Before(l.throttledUntil) {
+			l.stats.Dropped++
+			l.mu.Unlock()
+
+			Reject(evt, ErrEventDropped)
+			return
+		}
+
+		l.throttledUntil = now.Add(l.opts.Throttle)
+	}
+
+	if l.opts.Debounce > 0 {
+		replaced := l.pending
+		if replaced != nil {
+			l.stats.Coalesced++
+		}
+
+		if l.timer != nil {
+			l.timer.Stop()
+		}
+
+		l.generation++
+		generation := l.generation
+
+		l.pending = evt
+		l.timer = time.AfterFunc(l.opts.Debounce, func() {
+			l.flushGeneration(generation)
+		})
+
+		l.mu.Unlock()
+
+		if replaced != nil {
+			Reject(replaced, ErrEventCoalesced)
+		}
+		return
+	}
+
+	l.mu.Unlock()
+
+	l.release(evt)
+}
+
+// Flush emits the pending debounced event, if any
+func (l *Limiter) Flush() {
+	l.mu.Lock()
+	generation := l.generation
+	l.mu.Unlock()
+
+	l.flushGeneration(generation)
+}
+
+// Stats returns the number of events dropped and coalesced by the limiter
+func (l *Limiter) Stats() LimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.stats
+}
+
+func (l *Limiter) flushGeneration(generation int) {
+	l.mu.Lock()
+
+	if l.pending == nil || generation != l.generation {
+		l.mu.Unlock()
+		return
+	}
+
+	evt := l.pending
+	l.pending = nil
+
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+
+	l.mu.Unlock()
+
+	l.release(evt)
+}
+
+// release applies the rate limit to evt and emits it if a token is available
+func (l *Limiter) release(evt sigma.Event) {
+	if !l.opts.Rate.IsZero() {
+		l.mu.Lock()
+		ok := l.take()
+		if !ok {
+			l.stats.Dropped++
+		}
+		l.mu.Unlock()
+
+		if !ok {
+			Reject(evt, ErrEventDropped)
+			return
+		}
+	}
+
+	l.emit(evt)
+}
+
+// take refills the token bucket and takes one token. It returns false if the
+// bucket is empty. The caller must hold the limiter lock
+func (l *Limiter) take() bool {
+	now := l.now()
+	max := float64(l.opts.Rate.Events)
+
+	if !l.lastRefill.IsZero() {
+		elapsed := now.Sub(l.lastRefill)
+		l.tokens += elapsed.Seconds() / l.opts.Rate.Per.Seconds() * max
+		if l.tokens > max {
+			l.tokens = max
+		}
+	}
+	l.lastRefill = now
+
+	if l.tokens < 1 {
+		return false
+	}
+
+	l.tokens--
+	return true
+}
@@ -0,0 +1,108 @@
+package trigger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/homebot/sigma"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRate(t *testing.T) {
+	assert := assert.New(t)
+
+	r, err := ParseRate("10/s")
+	assert.NoError(err)
+	assert.Equal(Rate{Events: 10, Per: time.Second}, r)
+
+	r, err = ParseRate("3/m")
+	assert.NoError(err)
+	assert.Equal(Rate{Events: 3, Per: time.Minute}, r)
+
+	r, err = ParseRate("5/30s")
+	assert.NoError(err)
+	assert.Equal(Rate{Events: 5, Per: 30 * time.Second}, r)
+
+	for _, s := range []string{"", "10", "0/s", "-1/s", "x/s", "1/week", "1/-1s"} {
+		_, err := ParseRate(s)
+		assert.Error(err, s)
+	}
+}
+
+func TestLimiter_Rate(t *testing.T) {
+	assert := assert.New(t)
+
+	r := &batchRecorder{}
+	l := NewLimiter(LimitOptions{Rate: Rate{Events: 2, Per: time.Second}}, r.flush)
+
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		l.Add(sigma.NewSimpleEvent("a", nil))
+	}
+	assert.Len(r.get(), 2)
+	assert.Equal(LimitStats{Dropped: 1}, l.Stats())
+
+	// half a second refills one token
+	now = now.Add(500 * time.Millisecond)
+	l.Add(sigma.NewSimpleEvent("a", nil))
+	l.Add(sigma.NewSimpleEvent("a", nil))
+	assert.Len(r.get(), 3)
+	assert.Equal(LimitStats{Dropped: 2}, l.Stats())
+}
+
+func TestLimiter_Throttle(t *testing.T) {
+	assert := assert.New(t)
+
+	r := &batchRecorder{}
+	l := NewLimiter(LimitOptions{Throttle: time.Second}, r.flush)
+
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	l.Add(sigma.NewSimpleEvent("first", nil))
+	l.Add(sigma.NewSimpleEvent("second", nil))
+
+	now = now.Add(time.Second)
+	l.Add(sigma.NewSimpleEvent("third", nil))
+
+	events := r.get()
+	if assert.Len(events, 2) {
+		assert.Equal("first", events[0].Type())
+		assert.Equal("third", events[1].Type())
+	}
+	assert.Equal(LimitStats{Dropped: 1}, l.Stats())
+}
+
+func TestLimiter_Debounce(t *testing.T) {
+	assert := assert.New(t)
+
+	r := &batchRecorder{}
+	l := NewLimiter(LimitOptions{Debounce: 20 * time.Millisecond}, r.flush)
+
+	l.Add(sigma.NewSimpleEvent("first", nil))
+	l.Add(sigma.NewSimpleEvent("second", nil))
+	l.Add(sigma.NewSimpleEvent("last", nil))
+	assert.Len(r.get(), 0)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(r.get()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	events := r.get()
+	if assert.Len(events, 1) {
+		assert.Equal("last", events[0].Type())
+	}
+	assert.Equal(LimitStats{Coalesced: 2}, l.Stats())
+
+	// pending events are emitted on flush
+	l.Add(sigma.NewSimpleEvent("flushed", nil))
+	l.Flush()
+
+	events = r.get()
+	if assert.Len(events, 2) {
+		assert.Equal("flushed", events[1].Type())
+	}
+}
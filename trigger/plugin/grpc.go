@@ -0,0 +1,234 @@
+package plugin
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"sync"
+
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/trigger"
+)
+
+// The trigger service is small enough to not require a dedicated protocol
+// buffer definition. All methods exchange google.protobuf.Struct messages
+// with the following layout:
+//
+//	Build(options)     -> {"id": string}
+//	Next({"id": ...})  -> {"type": string, "payload": base64, "eof": bool}
+//	Close({"id": ...}) -> {}
+const serviceName = "sigma.trigger.v1.Trigger"
+
+var (
+	// ErrUnknownInstance is returned when a trigger instance is not known
+	// to the plugin
+	ErrUnknownInstance = errors.New("unknown trigger instance")
+)
+
+// triggerService is implemented by the plugin side of the trigger service
+type triggerService interface {
+	Build(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	Next(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	Close(context.Context, *structpb.Struct) (*structpb.Struct, error)
+}
+
+type serviceMethod func(triggerService, context.Context, *structpb.Struct) (*structpb.Struct, error)
+
+func unaryHandler(name string, method serviceMethod) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := new(structpb.Struct)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+
+			if interceptor == nil {
+				return method(srv.(triggerService), ctx, in)
+			}
+
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + serviceName + "/" + name,
+			}
+
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return method(srv.(triggerService), ctx, req.(*structpb.Struct))
+			}
+
+			return interceptor(ctx, in, info, handler)
+		},
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*triggerService)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryHandler("Build", triggerService.Build),
+		unaryHandler("Next", triggerService.Next),
+		unaryHandler("Close", triggerService.Close),
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "sigma/trigger/plugin",
+}
+
+// server serves trigger instances built by a trigger.Factory
+type server struct {
+	factory trigger.Factory
+
+	rw       sync.RWMutex
+	triggers map[string]trigger.Trigger
+}
+
+func newServer(f trigger.Factory) *server {
+	return &server{
+		factory:  f,
+		triggers: make(map[string]trigger.Trigger),
+	}
+}
+
+func (s *server) Build(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	t, err := s.factory.Build(toMap(in))
+	if err != nil {
+		return nil, err
+	}
+
+	id := uuid.NewV4().String()
+
+	s.rw.Lock()
+	s.triggers[id] = t
+	s.rw.Unlock()
+
+	return fromMap(map[string]string{"id": id}), nil
+}
+
+func (s *server) Next(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	t, err := s.get(in)
+	if err != nil {
+		return nil, err
+	}
+
+	evt, err := t.Next()
+	if err == io.EOF {
+		return &structpb.Struct{
+			Fields: map[string]*structpb.Value{
+				"eof": {Kind: &structpb.Value_BoolValue{BoolValue: true}},
+			},
+		}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return fromMap(map[string]string{
+		"type":    evt.Type(),
+		"payload": base64.StdEncoding.EncodeToString(evt.Payload()),
+	}), nil
+}
+
+func (s *server) Close(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	t, err := s.get(in)
+	if err != nil {
+		return nil, err
+	}
+
+	s.rw.Lock()
+	delete(s.triggers, toMap(in)["id"])
+	s.rw.Unlock()
+
+	return &structpb.Struct{}, t.Close()
+}
+
+func (s *server) get(in *structpb.Struct) (trigger.Trigger, error) {
+	s.rw.RLock()
+	defer s.rw.RUnlock()
+
+	t, ok := s.triggers[toMap(in)["id"]]
+	if !ok {
+		return nil, ErrUnknownInstance
+	}
+
+	return t, nil
+}
+
+// client is the host side of the trigger service
+type client struct {
+	conn *grpc.ClientConn
+}
+
+func (c *client) invoke(method string, in map[string]string) (*structpb.Struct, error) {
+	out := new(structpb.Struct)
+
+	if err := c.conn.Invoke(context.Background(), "/"+serviceName+"/"+method, fromMap(in), out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *client) build(opts map[string]string) (string, error) {
+	out, err := c.invoke("Build", opts)
+	if err != nil {
+		return "", err
+	}
+
+	return toMap(out)["id"], nil
+}
+
+func (c *client) next(id string) (sigma.Event, error) {
+	out, err := c.invoke("Next", map[string]string{"id": id})
+	if err != nil {
+		return nil, err
+	}
+
+	if out.GetFields()["eof"].GetBoolValue() {
+		return nil, io.EOF
+	}
+
+	m := toMap(out)
+
+	payload, err := base64.StdEncoding.DecodeString(m["payload"])
+	if err != nil {
+		return nil, err
+	}
+
+	return sigma.NewSimpleEvent(m["type"], payload), nil
+}
+
+func (c *client) close(id string) error {
+	_, err := c.invoke("Close", map[string]string{"id": id})
+	return err
+}
+
+func toMap(s *structpb.Struct) map[string]string {
+	m := make(map[string]string)
+
+	for key, value := range s.GetFields() {
+		if v, ok := value.GetKind().(*structpb.Value_StringValue); ok {
+			m[key] = v.StringValue
+		}
+	}
+
+	return m
+}
+
+func fromMap(m map[string]string) *structpb.Struct {
+	s := &structpb.Struct{
+		Fields: make(map[string]*structpb.Value),
+	}
+
+	for key, value := range m {
+		s.Fields[key] = &structpb.Value{
+			Kind: &structpb.Value_StringValue{StringValue: value},
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+
+	goplugin "github.com/hashicorp/go-plugin"
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/trigger"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/status"
+)
+
+// testFactory builds triggers that fire `count` events with the configured
+// payload. Afterwards they finish if `finite` is set or block until closed
+// otherwise. If `fail` is set, Build returns it as an error
+type testFactory struct{}
+
+func (testFactory) Build(opts map[string]string) (trigger.Trigger, error) {
+	if msg, ok := opts["fail"]; ok {
+		return nil, errors.New(msg)
+	}
+
+	count, _ := strconv.Atoi(opts["count"])
+
+	return &testTrigger{
+		payload: []byte(opts["payload"]),
+		count:   count,
+		finite:  opts["finite"] == "true",
+		closed:  make(chan struct{}),
+	}, nil
+}
+
+type testTrigger struct {
+	payload []byte
+	finite  bool
+
+	mu    sync.Mutex
+	count int
+
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (t *testTrigger) URN() string { return "test" }
+
+func (t *testTrigger) Next() (sigma.Event, error) {
+	t.mu.Lock()
+	if t.count > 0 {
+		t.count--
+		t.mu.Unlock()
+		return sigma.NewSimpleEvent("test", t.payload), nil
+	}
+	t.mu.Unlock()
+
+	if !t.finite {
+		<-t.closed
+	}
+	return nil, io.EOF
+}
+
+func (t *testTrigger) Close() error {
+	t.once.Do(func() { close(t.closed) })
+	return nil
+}
+
+func TestTriggerService(t *testing.T) {
+	assert := assert.New(t)
+
+	conn, _ := goplugin.TestPluginGRPCConn(t, false, map[string]goplugin.Plugin{
+		pluginName: &TriggerPlugin{Factory: testFactory{}},
+	})
+	defer conn.Close()
+
+	raw, err := conn.Dispense(pluginName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := raw.(*client)
+
+	id, err := c.build(map[string]string{"payload": "hello", "count": "1"})
+	assert.NoError(err)
+	assert.NotEmpty(id)
+
+	evt, err := c.next(id)
+	if assert.NoError(err) {
+		assert.Equal("test", evt.Type())
+		assert.Equal([]byte("hello"), evt.Payload())
+	}
+
+	assert.NoError(c.close(id))
+
+	err = c.close(id)
+	assert.Equal(ErrUnknownInstance.Error(), status.Convert(err).Message())
+
+	_, err = c.next(id)
+	assert.Equal(ErrUnknownInstance.Error(), status.Convert(err).Message())
+
+	// triggers that finish on their own are reported as io.EOF
+	id, err = c.build(map[string]string{"finite": "true"})
+	assert.NoError(err)
+
+	_, err = c.next(id)
+	assert.Equal(io.EOF, err)
+	assert.NoError(c.close(id))
+
+	_, err = c.build(map[string]string{"fail": "invalid options"})
+	assert.Equal("invalid options", status.Convert(err).Message())
+}
+
+func TestStructConversion(t *testing.T) {
+	assert := assert.New(t)
+
+	m := map[string]string{"a": "1", "b": ""}
+	assert.Equal(m, toMap(fromMap(m)))
+	assert.Equal(map[string]string{}, toMap(nil))
+}
@@ -0,0 +1,411 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	goplugin "github.com/hashicorp/go-plugin"
+	"github.com/homebot/insight/logger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/homebot/sigma"
+	"github.com/homebot/sigma/trigger"
+)
+
+// Prefix is the file name prefix of trigger plugin executables. The remaining
+// part of the file name is used as the trigger type
+const Prefix = "sigma-trigger-"
+
+var (
+	// ErrHostClosed is returned when the plugin host has already been closed
+	ErrHostClosed = errors.New("plugin host closed")
+)
+
+const (
+	// minRestartDelay is the delay before the first restart of a crashed
+	// plugin. It's doubled on each consecutive failure
+	minRestartDelay = time.Second
+
+	// maxRestartDelay is the maximum delay between two restarts
+	maxRestartDelay = time.Minute
+
+	// superviseInterval is the interval at which plugin processes are checked
+	superviseInterval = time.Second
+)
+
+// Host discovers, launches and supervises trigger plugins
+type Host struct {
+	dir string
+	l   logger.Logger
+
+	rw      sync.RWMutex
+	plugins map[string]*process
+
+	closed chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewHost creates a new plugin host for all plugins within dir
+func NewHost(dir string, l logger.Logger) *Host {
+	if l == nil {
+		l = logger.NopLogger{}
+	}
+
+	return &Host{
+		dir:     dir,
+		l:       l,
+		plugins: make(map[string]*process),
+		closed:  make(chan struct{}),
+	}
+}
+
+// Load discovers all trigger plugins and registers them as trigger types using
+// trigger.RegisterFactory(). Plugins that have already been loaded are
+// skipped and plugins named like an existing trigger type are not started.
+// It returns the names of all trigger types registered by this call
+func (h *Host) Load() ([]string, error) {
+	files, err := ioutil.ReadDir(h.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasPrefix(f.Name(), Prefix) {
+			continue
+		}
+
+		// skip files that are not executable
+		if f.Mode()&0111 == 0 {
+			continue
+		}
+
+		name := strings.TrimPrefix(f.Name(), Prefix)
+
+		h.rw.RLock()
+		_, loaded := h.plugins[name]
+		h.rw.RUnlock()
+
+		if loaded {
+			continue
+		}
+
+		if trigger.IsRegistered(name) {
+			h.l.Errorf("not loading trigger plugin %q: %s", name, trigger.ErrTriggerRegistered)
+			continue
+		}
+
+		p := &process{
+			name: name,
+			path: filepath.Join(h.dir, f.Name()),
+			l:    h.l.WithResource(name),
+		}
+
+		if err := p.start(); err != nil {
+			h.l.Errorf("failed to start trigger plugin %q: %s", name, err)
+			continue
+		}
+
+		if err := trigger.RegisterFactory(name, &factory{name: name, proc: p, host: h}); err != nil {
+			h.l.Errorf("not loading trigger plugin %q: %s", name, err)
+			p.kill()
+			continue
+		}
+
+		h.rw.Lock()
+		h.plugins[name] = p
+		h.rw.Unlock()
+
+		h.wg.Add(1)
+		go h.supervise(p)
+
+		h.l.Infof("loaded trigger plugin %q from %s", name, p.path)
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// Close stops all trigger plugins
+func (h *Host) Close() error {
+	select {
+	case <-h.closed:
+		return ErrHostClosed
+	default:
+		close(h.closed)
+	}
+
+	h.wg.Wait()
+
+	h.rw.Lock()
+	defer h.rw.Unlock()
+
+	for _, p := range h.plugins {
+		p.kill()
+	}
+
+	return nil
+}
+
+// supervise restarts p with an exponential backoff whenever the plugin
+// process exits
+func (h *Host) supervise(p *process) {
+	defer h.wg.Done()
+
+	delay := minRestartDelay
+
+	for {
+		select {
+		case <-h.closed:
+			return
+		case <-time.After(superviseInterval):
+		}
+
+		if !p.exited() {
+			delay = minRestartDelay
+			continue
+		}
+
+		p.l.Warnf("trigger plugin exited, restarting in %s", delay)
+
+		select {
+		case <-h.closed:
+			return
+		case <-time.After(delay):
+		}
+
+		if err := p.start(); err != nil {
+			p.l.Errorf("failed to restart trigger plugin: %s", err)
+
+			delay *= 2
+			if delay > maxRestartDelay {
+				delay = maxRestartDelay
+			}
+			continue
+		}
+
+		p.l.Infof("trigger plugin restarted")
+	}
+}
+
+// process is a running plugin process
+type process struct {
+	name string
+	path string
+	l    logger.Logger
+
+	rw         sync.RWMutex
+	client     *goplugin.Client
+	rpc        *client
+	generation int
+}
+
+func (p *process) start() error {
+	c := goplugin.NewClient(&goplugin.ClientConfig{
+		HandshakeConfig: Handshake,
+		Plugins: map[string]goplugin.Plugin{
+			pluginName: &TriggerPlugin{},
+		},
+		Cmd:              exec.Command(p.path),
+		AllowedProtocols: []goplugin.Protocol{goplugin.ProtocolGRPC},
+	})
+
+	proto, err := c.Client()
+	if err != nil {
+		c.Kill()
+		return err
+	}
+
+	raw, err := proto.Dispense(pluginName)
+	if err != nil {
+		c.Kill()
+		return err
+	}
+
+	rpc, ok := raw.(*client)
+	if !ok {
+		c.Kill()
+		return fmt.Errorf("unexpected plugin type %T", raw)
+	}
+
+	p.rw.Lock()
+	defer p.rw.Unlock()
+
+	if p.client != nil {
+		p.client.Kill()
+	}
+
+	p.client = c
+	p.rpc = rpc
+	p.generation++
+
+	return nil
+}
+
+func (p *process) exited() bool {
+	p.rw.RLock()
+	defer p.rw.RUnlock()
+
+	return p.client == nil || p.client.Exited()
+}
+
+func (p *process) kill() {
+	p.rw.Lock()
+	defer p.rw.Unlock()
+
+	if p.client != nil {
+		p.client.Kill()
+	}
+}
+
+// current returns the current RPC client and it's generation
+func (p *process) current() (*client, int) {
+	p.rw.RLock()
+	defer p.rw.RUnlock()
+
+	return p.rpc, p.generation
+}
+
+// factory is a trigger.Factory that builds triggers within a plugin
+type factory struct {
+	name string
+	proc *process
+	host *Host
+}
+
+// Build builds a new trigger within the plugin and implements trigger.Factory
+func (f *factory) Build(opts map[string]string) (trigger.Trigger, error) {
+	t := &remoteTrigger{
+		name:   f.name,
+		opts:   opts,
+		proc:   f.proc,
+		host:   f.host,
+		closed: make(chan struct{}),
+	}
+
+	// build the remote instance right away so configuration errors are
+	// reported to the caller
+	if _, _, err := t.remote(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// remoteTrigger adapts a trigger instance running inside a plugin to the
+// trigger.Trigger interface. If the plugin is restarted, the remote instance
+// is re-created using the same options
+type remoteTrigger struct {
+	name string
+	opts map[string]string
+	proc *process
+	host *Host
+
+	rw         sync.Mutex
+	id         string
+	generation int
+
+	closed chan struct{}
+}
+
+// URN returns the URN of the trigger
+func (t *remoteTrigger) URN() string { return "plugin/" + t.name }
+
+// Next blocks until the plugin trigger fires
+func (t *remoteTrigger) Next() (sigma.Event, error) {
+	for {
+		rpc, id, err := t.remote()
+		if err == nil {
+			var evt sigma.Event
+
+			evt, err = rpc.next(id)
+			if err == nil || err == io.EOF {
+				return evt, err
+			}
+		}
+
+		if t.isClosed() {
+			return nil, io.EOF
+		}
+
+		// If the plugin is still running and reachable the error has been
+		// returned by the trigger itself
+		if !t.proc.exited() && status.Code(err) != codes.Unavailable {
+			return nil, err
+		}
+
+		// Otherwise, wait for the plugin to be restarted by the host
+		select {
+		case <-t.closed:
+			return nil, io.EOF
+		case <-t.host.closed:
+			return nil, io.EOF
+		case <-time.After(superviseInterval):
+		}
+	}
+}
+
+// Close closes the remote trigger instance
+func (t *remoteTrigger) Close() error {
+	select {
+	case <-t.closed:
+		return errors.New("already closed")
+	default:
+		close(t.closed)
+	}
+
+	t.rw.Lock()
+	defer t.rw.Unlock()
+
+	rpc, generation := t.proc.current()
+	if t.id == "" || generation != t.generation {
+		// the remote instance died together with the plugin
+		return nil
+	}
+
+	return rpc.close(t.id)
+}
+
+func (t *remoteTrigger) isClosed() bool {
+	select {
+	case <-t.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// remote returns the RPC client and the ID of the remote trigger instance
+// and re-creates the instance if the plugin has been restarted
+func (t *remoteTrigger) remote() (*client, string, error) {
+	t.rw.Lock()
+	defer t.rw.Unlock()
+
+	rpc, generation := t.proc.current()
+	if rpc == nil {
+		return nil, "", ErrHostClosed
+	}
+
+	if t.id != "" && generation == t.generation {
+		return rpc, t.id, nil
+	}
+
+	id, err := rpc.build(t.opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	t.id = id
+	t.generation = generation
+
+	return rpc, id, nil
+}
@@ -0,0 +1,98 @@
+package plugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/homebot/sigma/trigger"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMain lets the test binary double as a stub trigger plugin when it is
+// started by a Host
+func TestMain(m *testing.M) {
+	if os.Getenv(Handshake.MagicCookieKey) == Handshake.MagicCookieValue {
+		Serve(testFactory{})
+		return
+	}
+
+	os.Exit(m.Run())
+}
+
+// installPlugin links the test binary into dir as the plugin `name`
+func installPlugin(t *testing.T, dir, name string) {
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(self, filepath.Join(dir, Prefix+name)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHost(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// trigger types are registered globally so they must be unique for
+	// each run
+	name := "host-test-" + filepath.Base(dir)
+	builtin := name + "-builtin"
+
+	assert.NoError(trigger.RegisterFactory(builtin, trigger.FactoryFunc(testFactory{}.Build)))
+
+	installPlugin(t, dir, name)
+	installPlugin(t, dir, builtin)
+
+	// files that are not executable are ignored
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, Prefix+"not-executable"), nil, 0644))
+
+	host := NewHost(dir, nil)
+	defer host.Close()
+
+	names, err := host.Load()
+	assert.NoError(err)
+	assert.Equal([]string{name}, names)
+
+	// loading again must neither fail nor register plugins twice
+	names, err = host.Load()
+	assert.NoError(err)
+	assert.Empty(names)
+
+	tr, err := trigger.Build(name, map[string]string{"payload": "hello", "count": "1"})
+	if assert.NoError(err) {
+		evt, err := tr.Next()
+		if assert.NoError(err) {
+			assert.Equal([]byte("hello"), evt.Payload())
+		}
+		assert.NoError(tr.Close())
+	}
+
+	_, err = trigger.Build(name, map[string]string{"fail": "invalid options"})
+	assert.Error(err)
+
+	assert.NoError(host.Close())
+	assert.Equal(ErrHostClosed, host.Close())
+}
+
+func TestRegisterFactory(t *testing.T) {
+	assert := assert.New(t)
+
+	f := trigger.FactoryFunc(testFactory{}.Build)
+	name := fmt.Sprintf("register-test-%d", time.Now().UnixNano())
+
+	assert.False(trigger.IsRegistered(name))
+	assert.NoError(trigger.RegisterFactory(name, f))
+	assert.True(trigger.IsRegistered(name))
+	assert.Equal(trigger.ErrTriggerRegistered, trigger.RegisterFactory(name, f))
+}
@@ -0,0 +1,67 @@
+// Package plugin provides support for out-of-process trigger plugins based
+// on github.com/hashicorp/go-plugin.
+//
+// A trigger plugin is a standalone executable that calls Serve() with a
+// trigger.Factory from it's main function:
+//
+//	func main() {
+//		plugin.Serve(&myFactory{})
+//	}
+//
+// The sigma server discovers plugins using a Host and registers each of them
+// as a trigger type so they can be used like any compiled-in trigger.
+package plugin
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	goplugin "github.com/hashicorp/go-plugin"
+	"github.com/homebot/sigma/trigger"
+)
+
+// Handshake is the handshake configuration shared between sigma and
+// trigger plugins. It is not a security measure but prevents users from
+// executing plugins directly
+var Handshake = goplugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "SIGMA_TRIGGER_PLUGIN",
+	MagicCookieValue: "f5c2f0ad-6a32-4f31-a6e0-2f1b63e2b8c0",
+}
+
+// pluginName is the name used to dispense the trigger factory
+const pluginName = "trigger"
+
+// TriggerPlugin implements goplugin.GRPCPlugin for trigger factories
+type TriggerPlugin struct {
+	goplugin.NetRPCUnsupportedPlugin
+
+	// Factory is the trigger factory to serve. It is only required on the
+	// plugin side
+	Factory trigger.Factory
+}
+
+// GRPCServer registers the trigger service at s and implements
+// goplugin.GRPCPlugin
+func (p *TriggerPlugin) GRPCServer(broker *goplugin.GRPCBroker, s *grpc.Server) error {
+	s.RegisterService(&serviceDesc, newServer(p.Factory))
+	return nil
+}
+
+// GRPCClient returns a client for the trigger service and implements
+// goplugin.GRPCPlugin
+func (p *TriggerPlugin) GRPCClient(ctx context.Context, broker *goplugin.GRPCBroker, c *grpc.ClientConn) (interface{}, error) {
+	return &client{conn: c}, nil
+}
+
+// Serve serves the trigger factory as a sigma trigger plugin and blocks
+// until the plugin is stopped by the host
+func Serve(f trigger.Factory) {
+	goplugin.Serve(&goplugin.ServeConfig{
+		HandshakeConfig: Handshake,
+		Plugins: map[string]goplugin.Plugin{
+			pluginName: &TriggerPlugin{Factory: f},
+		},
+		GRPCServer: goplugin.DefaultGRPCServer,
+	})
+}
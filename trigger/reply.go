@@ -0,0 +1,73 @@
+package trigger
+
+import (
+	"errors"
+
+	"github.com/homebot/sigma"
+)
+
+var (
+	// ErrEventDropped is replied to events dropped due to a rate limit or
+	// throttling
+	ErrEventDropped = errors.New("event dropped due to rate limit")
+
+	// ErrEventCoalesced is replied to debounced events replaced by a later
+	// event
+	ErrEventCoalesced = errors.New("event replaced by a later event")
+
+	// ErrEventFiltered is replied to events not satisfying the condition of
+	// the trigger
+	ErrEventFiltered = errors.New("event does not satisfy the trigger condition")
+)
+
+// Replier is implemented by events that expect the result of the function
+// execution they triggered, like request/reply messages
+type Replier interface {
+	// Reply is called with the result of the function execution
+	Reply(result []byte, err error) error
+}
+
+type replyEvent struct {
+	sigma.Event
+	Replier
+}
+
+// WithReplier returns evt with r attached so the result of the function
+// execution triggered by evt is passed to r. It is used to keep the replier of
+// an event that is replaced by a new one, e.g. when applying a transform
+func WithReplier(evt sigma.Event, r Replier) sigma.Event {
+	if _, ok := evt.(Replier); ok {
+		return evt
+	}
+
+	return &replyEvent{
+		Event:   evt,
+		Replier: r,
+	}
+}
+
+// Reject replies err to evt if it implements Replier. It is used for events
+// that are never dispatched to the function
+func Reject(evt sigma.Event, err error) error {
+	if r, ok := evt.(Replier); ok {
+		return r.Reply(nil, err)
+	}
+
+	return nil
+}
+
+// multiReplier passes the result of a batch to the repliers of all events
+// within the batch
+type multiReplier []Replier
+
+func (m multiReplier) Reply(result []byte, err error) error {
+	var first error
+
+	for _, r := range m {
+		if replyErr := r.Reply(result, err); replyErr != nil && first == nil {
+			first = replyErr
+		}
+	}
+
+	return first
+}
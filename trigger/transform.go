@@ -0,0 +1,111 @@
+package trigger
+
+import (
+	"bytes"
+	"encoding/json"
+	"text/template"
+
+	"github.com/homebot/sigma"
+)
+
+// TransformData is passed to transform templates
+type TransformData struct {
+	// Type is the type of the event
+	Type string
+
+	// Payload is the raw event payload
+	Payload string
+
+	// Event holds the decoded payload if it contains valid JSON
+	Event interface{}
+
+	// Params holds the parameters of the function
+	Params sigma.ValueMap
+}
+
+// Transform is a compiled payload transformation. Transformations are Go
+// templates executed with TransformData. The output of the template is used
+// as the new event payload. In addition to the default template functions,
+// the following functions are available:
+//
+//	toJson      encodes a value as JSON
+//	fromJson    decodes a JSON string
+//
+// Referring to a key that is missing in the event or the parameters fails the
+// transformation instead of rendering "<no value>".
+//
+// For example, the following transform reshapes the payload of the timer
+// trigger:
+//
+//	{"at": {{ toJson .Event.time }}, "room": {{ toJson .Params.room }}}
+type Transform struct {
+	source string
+	tmpl   *template.Template
+}
+
+var transformFuncs = template.FuncMap{
+	"toJson": func(v interface{}) (string, error) {
+		blob, err := json.Marshal(v)
+		return string(blob), err
+	},
+	"fromJson": func(s string) (interface{}, error) {
+		var v interface{}
+		err := json.Unmarshal([]byte(s), &v)
+		return v, err
+	},
+}
+
+// CompileTransform compiles the transform template. An empty transform does
+// not modify events
+func CompileTransform(transform string) (*Transform, error) {
+	t := &Transform{
+		source: transform,
+	}
+
+	if transform == "" {
+		return t, nil
+	}
+
+	tmpl, err := template.New("transform").
+		Option("missingkey=error").
+		Funcs(transformFuncs).
+		Parse(transform)
+	if err != nil {
+		return nil, err
+	}
+
+	t.tmpl = tmpl
+
+	return t, nil
+}
+
+// String returns the source of the transform
+func (t *Transform) String() string {
+	return t.source
+}
+
+// Apply applies the transformation to event and returns a new event with the
+// same type and the transformed payload
+func (t *Transform) Apply(event sigma.Event, values sigma.ValueMap) (sigma.Event, error) {
+	if t == nil || t.tmpl == nil {
+		return event, nil
+	}
+
+	data := TransformData{
+		Type:    event.Type(),
+		Payload: string(event.Payload()),
+		Params:  values,
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(event.Payload(), &decoded); err == nil {
+		data.Event = decoded
+	}
+
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+
+	return sigma.NewSimpleEvent(event.Type(), buf.Bytes()), nil
+}
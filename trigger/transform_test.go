@@ -0,0 +1,61 @@
+package trigger
+
+import (
+	"testing"
+
+	"github.com/homebot/sigma"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileTransform_Invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	tr, err := CompileTransform("{{ .Event.time ")
+	assert.Error(err)
+	assert.Nil(tr)
+}
+
+func TestTransform_Apply(t *testing.T) {
+	assert := assert.New(t)
+
+	evt := sigma.NewSimpleEvent("timer", []byte(`{"time": "2018-01-01T13:30:15Z", "tick": 3}`))
+	values := sigma.ValueMap{"room": "kitchen"}
+
+	tr, err := CompileTransform(`{"at": {{ toJson .Event.time }}, "room": "{{ .Params.room }}", "type": "{{ .Type }}"}`)
+	if !assert.NoError(err) {
+		return
+	}
+
+	res, err := tr.Apply(evt, values)
+	assert.NoError(err)
+	assert.Equal("timer", res.Type())
+	assert.Equal(`{"at": "2018-01-01T13:30:15Z", "room": "kitchen", "type": "timer"}`, string(res.Payload()))
+
+	// non-JSON payloads are available as .Payload
+	tr, err = CompileTransform(`{{ (fromJson .Payload).tick }}`)
+	assert.NoError(err)
+
+	res, err = tr.Apply(evt, nil)
+	assert.NoError(err)
+	assert.Equal("3", string(res.Payload()))
+
+	_, err = tr.Apply(sigma.NewSimpleEvent("text", []byte("not json")), nil)
+	assert.Error(err)
+
+	// missing keys are not rendered as "<no value>"
+	for _, source := range []string{`{{ .Event.missing }}`, `{{ .Params.missing }}`} {
+		tr, err = CompileTransform(source)
+		assert.NoError(err)
+
+		_, err = tr.Apply(evt, values)
+		assert.Error(err, source)
+	}
+
+	// empty transforms do not modify the event
+	tr, err = CompileTransform("")
+	assert.NoError(err)
+
+	res, err = tr.Apply(evt, nil)
+	assert.NoError(err)
+	assert.Equal(evt, res)
+}
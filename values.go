@@ -0,0 +1,114 @@
+package sigma
+
+import (
+	"fmt"
+
+	"github.com/homebot/protobuf/pkg/api/sigma/v1"
+)
+
+// ValueMap holds arbitrary parameter values like those decoded from
+// JSON or YAML
+type ValueMap map[string]interface{}
+
+// ToProto converts the value map to it's protocol buffer representation.
+// Values that cannot be represented are converted to their string
+// representation
+func (v ValueMap) ToProto() map[string]*sigma.Value {
+	if v == nil {
+		return nil
+	}
+
+	res := make(map[string]*sigma.Value, len(v))
+	for key, value := range v {
+		res[key] = valueToProto(value)
+	}
+
+	return res
+}
+
+// ValueMapFrom creates a value map from it's protocol buffer representation
+func ValueMapFrom(in map[string]*sigma.Value) ValueMap {
+	if in == nil {
+		return nil
+	}
+
+	res := make(ValueMap, len(in))
+	for key, value := range in {
+		res[key] = valueFromProto(value)
+	}
+
+	return res
+}
+
+func valueToProto(v interface{}) *sigma.Value {
+	switch x := v.(type) {
+	case nil:
+		return &sigma.Value{}
+	case string:
+		return &sigma.Value{Kind: &sigma.Value_StringValue{StringValue: x}}
+	case bool:
+		return &sigma.Value{Kind: &sigma.Value_BoolValue{BoolValue: x}}
+	case []byte:
+		return &sigma.Value{Kind: &sigma.Value_BytesValue{BytesValue: x}}
+	case float64:
+		return &sigma.Value{Kind: &sigma.Value_NumberValue{NumberValue: x}}
+	case float32:
+		return valueToProto(float64(x))
+	case int:
+		return valueToProto(float64(x))
+	case int32:
+		return valueToProto(float64(x))
+	case int64:
+		return valueToProto(float64(x))
+	case uint:
+		return valueToProto(float64(x))
+	case uint32:
+		return valueToProto(float64(x))
+	case uint64:
+		return valueToProto(float64(x))
+	case []interface{}:
+		list := &sigma.ValueList{}
+		for _, item := range x {
+			list.Values = append(list.Values, valueToProto(item))
+		}
+		return &sigma.Value{Kind: &sigma.Value_ListValue{ListValue: list}}
+	case map[string]interface{}:
+		return &sigma.Value{Kind: &sigma.Value_MapValue{MapValue: &sigma.ValueMap{
+			Values: ValueMap(x).ToProto(),
+		}}}
+	case ValueMap:
+		return valueToProto(map[string]interface{}(x))
+	case map[interface{}]interface{}:
+		// gopkg.in/yaml.v2 decodes nested maps with interface keys
+		m := make(map[string]interface{}, len(x))
+		for key, value := range x {
+			m[fmt.Sprintf("%v", key)] = value
+		}
+		return valueToProto(m)
+	default:
+		return valueToProto(fmt.Sprintf("%v", x))
+	}
+}
+
+func valueFromProto(v *sigma.Value) interface{} {
+	switch x := v.GetKind().(type) {
+	case *sigma.Value_StringValue:
+		return x.StringValue
+	case *sigma.Value_NumberValue:
+		return x.NumberValue
+	case *sigma.Value_BoolValue:
+		return x.BoolValue
+	case *sigma.Value_BytesValue:
+		return x.BytesValue
+	case *sigma.Value_ListValue:
+		res := make([]interface{}, 0, len(x.ListValue.GetValues()))
+		for _, item := range x.ListValue.GetValues() {
+			res = append(res, valueFromProto(item))
+		}
+		return res
+	case *sigma.Value_MapValue:
+		return map[string]interface{}(ValueMapFrom(x.MapValue.GetValues()))
+	default:
+		return nil
+	}
+}